@@ -0,0 +1,103 @@
+package cexfind
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen reports that queries to Cex are suspended by the
+// circuit breaker after repeated failures.
+var ErrCircuitOpen = errors.New("searches suspended after repeated failures, please try again later")
+
+// BreakerState is the state of the circuit breaker protecting Cex.
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // queries are made
+	BreakerOpen                         // queries are refused
+	BreakerHalfOpen                     // a trial query is allowed
+)
+
+func (b BreakerState) String() string {
+	return []string{"closed", "open", "half-open"}[b]
+}
+
+// breaker is a circuit breaker which opens after threshold consecutive
+// query failures, such as being blocked by CloudFlare, refusing queries
+// for the cooldown period so as not to prolong a block. After the
+// cooldown a single trial query is allowed, which closes the breaker if
+// it succeeds.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	mu        sync.Mutex
+	failures  int
+	state     BreakerState
+	opened    time.Time
+	trial     bool // a trial query is in flight
+	now       func() time.Time
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// allow reports ErrCircuitOpen if a query may not be made.
+func (b *breaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && b.now().Sub(b.opened) >= b.cooldown {
+		b.state = BreakerHalfOpen
+	}
+	switch {
+	case b.state == BreakerOpen:
+		return ErrCircuitOpen
+	case b.state == BreakerHalfOpen && b.trial:
+		return ErrCircuitOpen
+	case b.state == BreakerHalfOpen:
+		b.trial = true
+	}
+	return nil
+}
+
+// record records the outcome of a query allowed by allow. Finding no
+// results is a success; cancelled queries are neither success nor
+// failure.
+func (b *breaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	trial := b.trial
+	b.trial = false
+	switch QueryErrorKind(err) {
+	case "", "no_results":
+		b.failures = 0
+		b.state = BreakerClosed
+	case "cancelled":
+	default:
+		b.failures++
+		if trial || b.failures >= b.threshold {
+			b.state = BreakerOpen
+			b.opened = b.now()
+		}
+	}
+}
+
+// currentState reports the state of the breaker.
+func (b *breaker) currentState() BreakerState {
+	if b == nil {
+		return BreakerClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && b.now().Sub(b.opened) >= b.cooldown {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
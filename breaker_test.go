@@ -0,0 +1,104 @@
+package cexfind
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestBreaker tests the circuit breaker state transitions
+func TestBreaker(t *testing.T) {
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := newBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	blocked := fmt.Errorf("%w: Sorry, you have been blocked", ErrBlocked)
+
+	steps := []struct {
+		advance   time.Duration
+		outcome   error // recorded if the query is allowed
+		allowed   bool
+		wantState BreakerState
+	}{
+		{0, blocked, true, BreakerClosed},
+		{0, ErrNoResultsFound, true, BreakerClosed}, // resets failures
+		{0, blocked, true, BreakerClosed},
+		{0, blocked, true, BreakerOpen},
+		{0, nil, false, BreakerOpen},
+		{30 * time.Second, nil, false, BreakerOpen},
+		{30 * time.Second, blocked, true, BreakerOpen},         // failed trial
+		{time.Minute, context.Canceled, true, BreakerHalfOpen}, // cancelled trial
+		{0, nil, true, BreakerClosed},                          // successful trial
+		{0, nil, true, BreakerClosed},
+	}
+
+	for i, st := range steps {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			now = now.Add(st.advance)
+			err := b.allow()
+			if got, want := err == nil, st.allowed; got != want {
+				t.Fatalf("got allowed %t want %t (%v)", got, want, err)
+			}
+			if err == nil {
+				b.record(st.outcome)
+			} else if !errors.Is(err, ErrCircuitOpen) {
+				t.Errorf("got error %v want %v", err, ErrCircuitOpen)
+			}
+			if got, want := b.currentState(), st.wantState; got != want {
+				t.Errorf("got state %s want %s", got, want)
+			}
+		})
+	}
+
+	// only one trial is allowed at a time
+	b.record(blocked)
+	b.record(blocked)
+	now = now.Add(time.Minute)
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected trial to be allowed, got %v", err)
+	}
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected second trial to be refused, got %v", err)
+	}
+}
+
+// TestSearchCircuitBreaker checks searches fail fast once Cex has
+// blocked repeated queries
+func TestSearchCircuitBreaker(t *testing.T) {
+
+	blockPage, err := os.ReadFile("testdata/error.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusForbidden)
+		w.Write(blockPage)
+	}))
+	defer ts.Close()
+	URL = ts.URL
+
+	cex := NewCexFind(WithCircuitBreaker(2, time.Minute))
+	for range 3 {
+		_, err = cex.Search([]string{"lenovo x390s"}, false, "")
+	}
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("got error %v want %v", err, ErrCircuitOpen)
+	}
+	if got, want := requests, 2; got != want {
+		t.Errorf("got %d requests want %d", got, want)
+	}
+	if got, want := cex.BreakerState(), BreakerOpen; got != want {
+		t.Errorf("got state %s want %s", got, want)
+	}
+	if got, want := NewCexFind().BreakerState(), BreakerClosed; got != want {
+		t.Errorf("got state %s want %s without a breaker", got, want)
+	}
+}
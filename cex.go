@@ -37,10 +37,14 @@ package cexfind
 
 import (
 	"cmp"
+	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/rorycl/cexfind/location"
 	"github.com/shopspring/decimal"
@@ -101,6 +105,20 @@ func (b *Box) reverseID() string {
 	return string(r)
 }
 
+// Grade returns the condition grade of the Box, such as "A", "B" or
+// "C", which is the right-most character of its ID, or an empty string
+// if the ID has no grade.
+func (b Box) Grade() string {
+	if b.ID == "" {
+		return ""
+	}
+	g := b.ID[len(b.ID)-1:]
+	if g < "A" || g > "Z" {
+		return ""
+	}
+	return g
+}
+
 // StoresString returns the stores as a comma delimited string to
 // roughly length, truncating with "…" where necessary. Giving
 // StoreString an argument length of -1 means there is no limit on the
@@ -129,6 +147,24 @@ func (b *Box) StoresString(length int) string {
 	return storeString
 }
 
+// NearestStore returns the store holding the Box nearest to any
+// origin, if distances have been calculated.
+func (b *Box) NearestStore() (location.StoreWithDistance, bool) {
+	for _, s := range b.Stores {
+		if s.StoreID != 0 && s.Resolution != location.ResolutionNone {
+			return s, true // stores are sorted by distance
+		}
+	}
+	return location.StoreWithDistance{}, false
+}
+
+// Within reports if any store holding the Box is within miles of any
+// origin.
+func (b *Box) Within(miles float64) bool {
+	s, ok := b.NearestStore()
+	return ok && s.Within(miles)
+}
+
 // boxes is a slice of Box
 type boxes []Box
 
@@ -149,18 +185,36 @@ func (b *boxes) sort() {
 	})
 }
 
+// ErrNoResults is returned by a search in which no items were found
+// but no query reported an error, for example where strict matching
+// excluded every item. See also ErrNoResultsFound.
+var ErrNoResults = errors.New("no results")
+
+// StaleStoresAge is the age after which store location data loaded
+// from a snapshot is reported as stale.
+var StaleStoresAge = 7 * 24 * time.Hour
+
 // CexFind provides the means for searching Cex's API with store
 // location data.
 type CexFind struct {
 	storeDistances *location.StoreDistances
+	storeOptions   []location.Option
+	observer       Observer
+	client         *http.Client  // for queries to Cex
+	breaker        *breaker      // nil unless WithCircuitBreaker
+	stale          *staleResults // nil unless WithStaleResults
 }
 
-// NewCexFind makes a new Cex instance. This should only be initalised
-// once due to caching in the location submodules.
-func NewCexFind() *CexFind {
-	return &CexFind{
-		storeDistances: location.NewStoreDistances(true),
+// NewCexFind makes a new Cex instance, configured by any options
+// provided. This should only be initalised once due to caching in the
+// location submodules.
+func NewCexFind(options ...Option) *CexFind {
+	c := CexFind{client: &http.Client{}}
+	for _, o := range options {
+		o(&c)
 	}
+	c.storeDistances = location.NewStoreDistances(true, c.storeOptions...)
+	return &c
 }
 
 // LocationDistancesOK indicates if the storeDistances.store has been
@@ -171,6 +225,130 @@ func (c *CexFind) LocationDistancesOK() bool {
 	return c.storeDistances.IsOperational()
 }
 
+// LocationStoresAge reports the age of the store location data and
+// whether it was retrieved live or loaded from a snapshot.
+func (c *CexFind) LocationStoresAge() (time.Duration, location.StoresSource) {
+	return c.storeDistances.StoresAge()
+}
+
+// LocationStoresStale reports if the store location data was loaded
+// from a snapshot older than StaleStoresAge, together with its age.
+// Client apps may want to warn that distances could be out of date.
+func (c *CexFind) LocationStoresStale() (bool, time.Duration) {
+	age, source := c.storeDistances.StoresAge()
+	if source == location.StoresLive || source == location.StoresNone {
+		return false, age
+	}
+	return age > StaleStoresAge, age
+}
+
+// Stores returns all Cex stores with their locations. If origins are
+// provided the stores are sorted by increasing distance from the
+// nearest origin, otherwise by name.
+func (c *CexFind) Stores(origins ...location.Origin) ([]location.StoreWithDistance, error) {
+	stores, err := c.storeDistances.Stores(origins...)
+	if err != nil {
+		return nil, fmt.Errorf("location error: %w", err)
+	}
+	return stores, nil
+}
+
+// BoxStores returns the stores holding the Box b, with their details
+// such as region and closing time, and their distances from any
+// origins. This allows the stores of a Box found by a search to be
+// shown from other origins without searching again. A Box not found by
+// a search keeps its stores.
+func (c *CexFind) BoxStores(b Box, origins ...location.Origin) ([]location.StoreWithDistance, error) {
+	if b.storeNames == nil {
+		return b.Stores, nil
+	}
+	for _, origin := range origins {
+		if err := origin.Validate(); err != nil {
+			return nil, fmt.Errorf("location error: %w", err)
+		}
+	}
+	stores, err := c.storeDistances.StoresNamed(b.storeNames, origins...)
+	if err != nil {
+		return nil, fmt.Errorf("location error: %w", err)
+	}
+	return stores, nil
+}
+
+// Locate returns the location of an origin, which may be a postcode,
+// coordinate or named place.
+func (c *CexFind) Locate(origin location.Origin) (location.Location, error) {
+	l, err := c.storeDistances.Locate(origin)
+	if err != nil {
+		return location.Location{}, fmt.Errorf("location error: %w", err)
+	}
+	return *l, nil
+}
+
+// SaveLocationCache saves the cached postcode and place locations to
+// the file set with WithLocationCacheFile, if any.
+func (c *CexFind) SaveLocationCache() error {
+	return c.storeDistances.SaveLocationCache()
+}
+
+// LocationCacheStats reports the size and use of the postcode and place
+// location cache.
+func (c *CexFind) LocationCacheStats() location.LocationCacheStats {
+	return c.storeDistances.LocationCacheStats()
+}
+
+// DistanceUnit reports the unit used to format store distances.
+func (c *CexFind) DistanceUnit() location.Unit {
+	return c.storeDistances.Unit()
+}
+
+// UnmatchedStores reports store names returned by searches that could
+// not be matched to a store location, and which therefore have no
+// distance. These can be fixed with store aliases.
+func (c *CexFind) UnmatchedStores() []string {
+	return c.storeDistances.UnmatchedStores()
+}
+
+// StoresStatus reports the state of the store location data, including
+// the outcome of the last attempt to refresh it.
+func (c *CexFind) StoresStatus() location.StoresStatus {
+	return c.storeDistances.StoresStatus()
+}
+
+// BreakerState reports the state of the circuit breaker set with
+// WithCircuitBreaker, which is always closed if there is none.
+func (c *CexFind) BreakerState() BreakerState {
+	return c.breaker.currentState()
+}
+
+// queryBoxes makes a single query, if allowed by the circuit breaker,
+// reporting it to the observer. If the query fails the most recent
+// cached results of the query are returned, if kept, with a
+// StaleError. In strict mode boxes which don't match any of queries
+// are excluded.
+func (cex *CexFind) queryBoxes(ctx context.Context, query string, queries []string, strict bool) ([]Box, error) {
+	start := time.Now()
+	found, err := []Box(nil), cex.breaker.allow()
+	if err == nil {
+		found, err = queryBoxes(ctx, cex.client, query)
+		cex.breaker.record(err)
+	}
+	if cex.observer.Query != nil {
+		cex.observer.Query(query, time.Since(start), err)
+	}
+	switch {
+	case err == nil:
+		cex.stale.put(query, found)
+	case staleable(err):
+		if cached, age, ok := cex.stale.get(query); ok {
+			found, err = cached, &StaleError{Query: query, Age: age, Err: err}
+		}
+	}
+	if strict {
+		found = strictBoxes(found, queries)
+	}
+	return found, err
+}
+
 // Search searches the Cex json endpoint at URL for the provided
 // queries, returning a slice of Box or error.
 //
@@ -186,13 +364,49 @@ func (c *CexFind) LocationDistancesOK() bool {
 // model, then by price ascending. Duplicate results are removed at
 // aggregation.
 func (cex *CexFind) Search(queries []string, strict bool, postcode string) ([]Box, error) {
+	return cex.SearchFrom(queries, strict, location.PostcodeOrigin(postcode))
+}
+
+// SearchFrom is the same as Search but calculates store distances
+// from one or more origins, each of which may be a postcode,
+// coordinate or named place (see location.ParseOrigins). Where there
+// are several origins, such as "home" and "work", each store reports
+// its distance from every origin and is ordered by its distance from
+// the nearest. The origins are validated before any queries are made.
+func (cex *CexFind) SearchFrom(queries []string, strict bool, origins ...location.Origin) ([]Box, error) {
+	return cex.SearchContext(context.Background(), queries, strict, origins...)
+}
+
+// SearchContext is the same as SearchFrom but abandons the queries if
+// ctx is cancelled.
+//
+// With WithStaleResults, queries which fail return their most recent
+// cached results instead. If no other query failed the error is then a
+// *StaleError, with the age of the oldest results.
+func (cex *CexFind) SearchContext(ctx context.Context, queries []string, strict bool, origins ...location.Origin) ([]Box, error) {
+	for _, origin := range origins {
+		if err := origin.Validate(); err != nil {
+			return nil, fmt.Errorf("location error: %w", err)
+		}
+	}
+
+	// abandon outstanding queries on return
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	var allBoxes boxes
 	var idMap = make(map[string]struct{})
 
 	var err error
+	var staleErrs []*StaleError
 
-	results := makeQueries(queries, strict)
+	results := makeQueries(ctx, queries, strict, cex.queryBoxes)
 	for br := range results {
+		var staleErr *StaleError
+		if errors.As(br.err, &staleErr) {
+			staleErrs = append(staleErrs, staleErr)
+			continue
+		}
 		if br.err != nil {
 			if err != nil {
 				err = fmt.Errorf("\"%s\": %w\n%w", br.query, br.err, err)
@@ -209,23 +423,113 @@ func (cex *CexFind) Search(queries []string, strict bool, postcode string) ([]Bo
 		// location data. cached data only requires distances to be
 		// calculated. If stores are offline distance calcs are skipped,
 		// but stores "with distances" are still returned.
-		br.box.Stores, err = cex.storeDistances.Distances(postcode, br.box.storeNames)
-		if err != nil {
-			err = fmt.Errorf("postcode error: %w", err)
-			return nil, err
+		stores, locErr := cex.storeDistances.DistancesFrom(br.box.storeNames, origins...)
+		if locErr != nil {
+			return nil, fmt.Errorf("location error: %w", locErr)
 		}
+		br.box.Stores = stores
 
 		allBoxes = append(allBoxes, br.box)
 		idMap[br.box.ID] = struct{}{}
 	}
 	allBoxes.sort()
+
+	// results from the stale cache are reported as a single StaleError
+	// if no other query failed
+	if len(staleErrs) > 0 {
+		if err == nil {
+			err = joinStale(staleErrs)
+		} else {
+			for _, se := range staleErrs {
+				err = fmt.Errorf("%w\n%w", se, err)
+			}
+		}
+	}
 	if len(allBoxes) == 0 {
 		if err != nil {
 			err = fmt.Errorf("%w", err)
 		} else {
-			err = errors.New("no results")
+			err = ErrNoResults
 		}
 		return allBoxes, err
 	}
 	return allBoxes, err
 }
+
+// QueryResult is the result of a single query from SearchStream. Boxes
+// holds the items found by the query, sorted, excluding any already
+// reported for another query. If the query failed and its results are
+// from the stale cache, Err is a *StaleError.
+type QueryResult struct {
+	Query string
+	Boxes []Box
+	Err   error
+}
+
+// SearchStream is the same as SearchContext but reports the results of
+// each query as it completes on the returned channel, which is closed
+// once all the queries are done or ctx is cancelled. The origins are
+// resolved before any queries are made, so location errors are
+// returned immediately.
+func (cex *CexFind) SearchStream(ctx context.Context, queries []string, strict bool, origins ...location.Origin) (<-chan QueryResult, error) {
+	for _, origin := range origins {
+		if err := origin.Validate(); err != nil {
+			return nil, fmt.Errorf("location error: %w", err)
+		}
+	}
+	if _, err := cex.storeDistances.DistancesFrom(nil, origins...); err != nil {
+		return nil, fmt.Errorf("location error: %w", err)
+	}
+
+	queryResults := make(chan QueryResult)
+	var wg sync.WaitGroup
+	for _, query := range queries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			found, err := cex.queryBoxes(ctx, query, queries, strict)
+			select {
+			case queryResults <- QueryResult{Query: query, Boxes: found, Err: err}:
+			case <-ctx.Done():
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(queryResults)
+	}()
+
+	out := make(chan QueryResult)
+	go func() {
+		defer close(out)
+		var idMap = make(map[string]struct{})
+		for qr := range queryResults {
+			var unique boxes
+			for _, box := range qr.Boxes {
+				if _, ok := idMap[box.ID]; ok { // don't add duplicates
+					continue
+				}
+				var err error
+				box.Stores, err = cex.storeDistances.DistancesFrom(box.storeNames, origins...)
+				if err != nil {
+					qr.Err = fmt.Errorf("location error: %w", err)
+					break
+				}
+				unique = append(unique, box)
+				idMap[box.ID] = struct{}{}
+			}
+			unique.sort()
+			qr.Boxes = unique
+			var staleErr *StaleError
+			if qr.Err != nil && !errors.As(qr.Err, &staleErr) {
+				qr.Err = fmt.Errorf("\"%s\": %w", qr.Query, qr.Err)
+			}
+			select {
+			case out <- qr:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
@@ -1,12 +1,18 @@
 package cexfind
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -231,6 +237,25 @@ func TestBoxIDUrl(t *testing.T) {
 	}
 }
 
+// TestBoxGrade checks the grade is extracted from the box id
+func TestBoxGrade(t *testing.T) {
+	for i, tt := range []struct {
+		id    string
+		grade string
+	}{
+		{"PALSLENX39065B", "B"},
+		{"SLAPDELL5400A", "A"},
+		{"5030917299612", ""},
+		{"", ""},
+	} {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			if got, want := (Box{ID: tt.id}).Grade(), tt.grade; got != want {
+				t.Errorf("got %q want %q", got, want)
+			}
+		})
+	}
+}
+
 func TestCexInitialised(t *testing.T) {
 	cex := &CexFind{
 		storeDistances: location.NewStoreDistances(false),
@@ -238,4 +263,233 @@ func TestCexInitialised(t *testing.T) {
 	if got, want := cex.LocationDistancesOK(), false; got != want {
 		t.Errorf("got %t want %t", got, want)
 	}
+	if stale, _ := cex.LocationStoresStale(); stale {
+		t.Error("uninitialised stores should not be reported as stale")
+	}
+}
+
+// TestSearchInvalidOrigin checks that invalid origins fail before any
+// queries are made
+func TestSearchInvalidOrigin(t *testing.T) {
+
+	queried := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queried = true
+	}))
+	defer ts.Close()
+	URL = ts.URL
+
+	cex := &CexFind{
+		storeDistances: location.NewStoreDistances(false),
+	}
+	_, err := cex.Search([]string{"lenovo x390"}, false, "S10 1L")
+	if !errors.Is(err, location.ErrInvalidPostcode) {
+		t.Errorf("got error %v want %v", err, location.ErrInvalidPostcode)
+	}
+	_, err = cex.SearchFrom([]string{"lenovo x390"}, false, location.CoordOrigin(91, 0))
+	if !errors.Is(err, location.ErrInvalidCoordinate) {
+		t.Errorf("got error %v want %v", err, location.ErrInvalidCoordinate)
+	}
+	if queried {
+		t.Error("search endpoint should not have been queried")
+	}
+}
+
+func TestBoxNearestStore(t *testing.T) {
+	box := Box{ID: "whatever"}
+	if _, ok := box.NearestStore(); ok {
+		t.Error("expected no nearest store")
+	}
+	box.Stores = []location.StoreWithDistance{
+		{StoreName: "unknown"},
+		{StoreID: 1, StoreName: "a", DistanceMiles: 4, Resolution: location.ResolutionPostcode, Nearest: "work"},
+		{StoreID: 2, StoreName: "b", DistanceMiles: 9, Resolution: location.ResolutionPostcode, Nearest: "home"},
+	}
+	s, ok := box.NearestStore()
+	if !ok || s.StoreName != "a" {
+		t.Errorf("got nearest store %v want a", s)
+	}
+	if !box.Within(5) {
+		t.Error("expected box to be within 5 miles")
+	}
+	if box.Within(3) {
+		t.Error("expected box not to be within 3 miles")
+	}
+}
+
+func TestDistanceUnit(t *testing.T) {
+	if got, want := NewCexFind().DistanceUnit(), location.Miles; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+	if got, want := NewCexFind(WithDistanceUnit(location.Kilometres)).DistanceUnit(), location.Kilometres; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+}
+
+// TestObserver checks that each query is reported to the observer
+func TestObserver(t *testing.T) {
+
+	contents, err := os.ReadFile("testdata/example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), "missing") {
+			fmt.Fprint(w, `{"results":[{"hits":[]}]}`)
+			return
+		}
+		fmt.Fprintln(w, string(contents))
+	}))
+	defer ts.Close()
+	URL = ts.URL
+
+	var mu sync.Mutex
+	kinds := map[string]string{}
+	cex := NewCexFind(WithObserver(Observer{
+		Query: func(query string, duration time.Duration, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			kinds[query] = QueryErrorKind(err)
+		},
+	}))
+	_, err = cex.Search([]string{"lenovo x390s", "missing"}, false, "")
+	if err == nil {
+		t.Fatal("expected error for missing query")
+	}
+	want := map[string]string{"lenovo x390s": "", "missing": "no_results"}
+	if diff := cmp.Diff(want, kinds); diff != "" {
+		t.Errorf("observed queries mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// TestWithProxy checks queries are sent through the proxy
+func TestWithProxy(t *testing.T) {
+
+	contents, err := os.ReadFile("testdata/example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	URL = "http://cex.invalid/queries"
+
+	proxied := ""
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		fmt.Fprintln(w, string(contents))
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	results, err := NewCexFind(WithProxy(proxyURL)).Search([]string{"lenovo x390s"}, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(results), 6; got != want {
+		t.Errorf("expected %d box results, got %d", want, got)
+	}
+	if got, want := proxied, URL; got != want {
+		t.Errorf("got proxied url %s want %s", got, want)
+	}
+}
+
+func TestStoresInvalidOrigin(t *testing.T) {
+	_, err := NewCexFind().Stores(location.CoordOrigin(95, 0))
+	if !errors.Is(err, location.ErrInvalidCoordinate) {
+		t.Errorf("got error %v want %v", err, location.ErrInvalidCoordinate)
+	}
+}
+
+// TestBoxStores tests finding the stores of a box without searching
+// again, and that boxes not found by a search keep their stores
+func TestBoxStores(t *testing.T) {
+	cex := NewCexFind()
+
+	box := Box{ID: "x1A", storeNames: []string{"Walthamstow", "Havant"}}
+	stores, err := cex.BoxStores(box)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, s := range stores {
+		got = append(got, s.StoreName)
+	}
+	if diff := cmp.Diff([]string{"Havant", "Walthamstow"}, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := cex.BoxStores(box, location.CoordOrigin(95, 0)); !errors.Is(err, location.ErrInvalidCoordinate) {
+		t.Errorf("got error %v want %v", err, location.ErrInvalidCoordinate)
+	}
+
+	kept := []location.StoreWithDistance{{StoreName: "Truro"}}
+	stores, err = cex.BoxStores(Box{ID: "x2B", Stores: kept})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(kept, stores); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// TestSearchStream checks results are reported per query without
+// duplicates, and that cancelling the context abandons the queries
+func TestSearchStream(t *testing.T) {
+
+	contents, err := os.ReadFile("testdata/example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), "slow") {
+			select {
+			case <-block:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		fmt.Fprintln(w, string(contents))
+	}))
+	defer ts.Close()
+	defer close(block)
+
+	// overwrite global URL with test URL
+	URL = ts.URL
+
+	cex := NewCexFind()
+	stream, err := cex.SearchStream(context.Background(), []string{"lenovo x390", "x390 lenovo"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := []int{}
+	for qr := range stream {
+		if qr.Err != nil {
+			t.Fatal(qr.Err)
+		}
+		counts = append(counts, len(qr.Boxes))
+	}
+	// both queries return the same boxes, which are only reported once
+	if diff := cmp.Diff([]int{6, 0}, counts); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	// cancel with a query outstanding
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err = cex.SearchStream(ctx, []string{"lenovo x390", "slow query"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qr := <-stream
+	if got, want := qr.Query, "lenovo x390"; got != want {
+		t.Errorf("got query %s want %s", got, want)
+	}
+	cancel()
+	for range stream {
+	}
+
+	_, err = cex.SearchStream(context.Background(), []string{"lenovo x390"}, false, location.CoordOrigin(95, 0))
+	if !errors.Is(err, location.ErrInvalidCoordinate) {
+		t.Errorf("got error %v want %v", err, location.ErrInvalidCoordinate)
+	}
 }
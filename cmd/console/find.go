@@ -12,6 +12,7 @@ import (
 
 	cex "github.com/rorycl/cexfind"
 	"github.com/rorycl/cexfind/cmd"
+	"github.com/rorycl/cexfind/location"
 )
 
 // emptyItemOn defines if "empty items" should be added to make space
@@ -28,7 +29,9 @@ const emptyItemOn bool = false
 //
 // The query is received from the app as a single string with queries
 // separated (potentially) by a semicolon. Queries are expected to each
-// be at least 3 characters in length.
+// be at least 3 characters in length. The postcode may also be a place
+// name or "latitude,longitude" pair, or several of these separated by a
+// semicolon and optionally named, such as "home=S10 1LT;work=NW1 6LG".
 //
 // The itemNo number of items is included since heading and empty items
 // are introduced for formatting reasons. itemNo counts the number of
@@ -44,12 +47,16 @@ func find(m *model, query string, strict bool, postcode string) (items []list.It
 	if err != nil {
 		return items, 0, err
 	}
+	origins, err := location.ParseOrigins(postcode)
+	if err != nil {
+		return items, 0, err
+	}
 
 	var results []cex.Box
-	log.Printf("  making search for %v, strict %t", queries, strict)
+	log.Printf("  making search for %v, strict %t, from %v", queries, strict, origins)
 
 	// note that err does not cause a failure
-	results, err = m.cex.Search(queries, strict, postcode)
+	results, err = m.cex.SearchFrom(queries, strict, origins...)
 
 	log.Printf("results %#v\nerr %v", results, err)
 	if results == nil {
@@ -74,10 +74,10 @@ func newInModel() inModel {
 
 	p := textinput.New()
 	p.Cursor.Style = postcodeNormalStyle
-	p.CharLimit = 8
-	p.Placeholder = "postcode"
+	p.CharLimit = 80
+	p.Placeholder = "postcode/place"
 	p.PromptStyle = postcodeFocusedStyle
-	p.Width = 12
+	p.Width = 16
 
 	return inModel{
 		input:    t,
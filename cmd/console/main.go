@@ -30,4 +30,7 @@ func main() {
 		fmt.Println("Error running program:", err)
 		os.Exit(1)
 	}
+	if err := m.cex.SaveLocationCache(); err != nil {
+		log.Println(err)
+	}
 }
@@ -18,6 +18,8 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/rorycl/cexfind"
+	"github.com/rorycl/cexfind/cmd"
+	"github.com/rorycl/cexfind/location"
 )
 
 var (
@@ -108,8 +110,33 @@ func NewModel() *model {
 	m.inited = true
 	m.listLen = 0
 
-	// initialise the cex finder
-	m.cex = cexfind.NewCexFind()
+	// distances are shown in miles unless the CEXFIND_UNITS
+	// environment variable is set to "km"
+	unit, err := location.ParseUnit(os.Getenv("CEXFIND_UNITS"))
+	if err != nil {
+		log.Println(err)
+	}
+
+	// initialise the cex finder; store locations are cached for use
+	// when offline, and postcode locations between runs
+	options := []cexfind.Option{
+		cexfind.WithStoreCacheFile(cexfind.DefaultStoreCacheFile()),
+		cexfind.WithEmbeddedStores(),
+		cexfind.WithLocationCacheFile(cexfind.DefaultLocationCacheFile()),
+		cexfind.WithDistanceUnit(unit),
+	}
+
+	// queries are made through the proxy in the CEXFIND_PROXY
+	// environment variable, if set
+	if p := os.Getenv(cmd.ProxyEnv); p != "" {
+		proxy, err := cmd.ParseProxy(p)
+		if err != nil {
+			log.Println(err)
+		} else {
+			options = append(options, cexfind.WithProxy(proxy))
+		}
+	}
+	m.cex = cexfind.NewCexFind(options...)
 
 	// initialise the help model and related keys
 	m.help = help.New()
@@ -176,10 +203,11 @@ func (m *model) stateSwitch(targetState state, withStatus bool) tea.Cmd {
 			}
 		} else {
 			m.input.input.Blur()
-			m.input.postcode.Placeholder = "postcode"
+			m.input.postcode.Placeholder = "postcode/place"
 			m.input.postcode.Focus()
 			if withStatus {
-				m.status = m.status.setPostcoding()
+				stale, age := m.cex.LocationStoresStale()
+				m.status = m.status.setPostcoding(m.cex.DistanceUnit(), stale, age)
 			}
 		}
 		m.keys = getKeyMap(inputKeysState)
@@ -6,9 +6,11 @@ package main
 
 import (
 	"fmt"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/rorycl/cexfind/location"
 )
 
 var (
@@ -44,9 +46,15 @@ func (s status) setInputting() status {
 	return status("add searches separated by a comma, tab to switch fields, enter to search")
 }
 
-// status formatting when in postcode
-func (s status) setPostcoding() status {
-	return status("optionally add a postcode to see distances to stores")
+// status formatting when in postcode, noting the distance unit and if
+// the store locations are from a stale snapshot
+func (s status) setPostcoding(unit location.Unit, stale bool, age time.Duration) status {
+	tpl := "optionally add a postcode, place or lat,long to see distances to stores in %s"
+	if stale {
+		tpl += " (store locations are %d days old)"
+		return status(fmt.Sprintf(tpl, unit.Name(), int(age.Hours()/24)))
+	}
+	return status(fmt.Sprintf(tpl, unit.Name()))
 }
 
 // status formatting when in postcode but location searching is
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/rorycl/cexfind"
+	"github.com/rorycl/cexfind/location"
+	"github.com/shopspring/decimal"
+)
+
+// apiVersion is the version of the JSON api, used in the api paths.
+const apiVersion = "v1"
+
+// apiError is the json response for api errors.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// apiOriginDistance is the distance of a store from one origin.
+type apiOriginDistance struct {
+	Origin     string  `json:"origin"`
+	Miles      float64 `json:"miles"`
+	Km         float64 `json:"km"`
+	Resolution string  `json:"resolution"`
+}
+
+// apiDistance is the distance of a store from the nearest origin.
+type apiDistance struct {
+	Miles       float64             `json:"miles"`
+	Km          float64             `json:"km"`
+	Formatted   string              `json:"formatted"`
+	Resolution  string              `json:"resolution"`
+	Approximate bool                `json:"approximate"`
+	Nearest     string              `json:"nearest,omitempty"`
+	Origins     []apiOriginDistance `json:"origins"`
+}
+
+// apiStore is a store, with its distance if any origins were provided
+// and the store could be located.
+type apiStore struct {
+	ID          int          `json:"id,omitempty"`
+	Name        string       `json:"name"`
+	Region      string       `json:"region,omitempty"`
+	Latitude    float64      `json:"latitude,omitempty"`
+	Longitude   float64      `json:"longitude,omitempty"`
+	ClosingTime string       `json:"closingTime,omitempty"`
+	Distance    *apiDistance `json:"distance,omitempty"`
+}
+
+// apiBox is an item for sale.
+type apiBox struct {
+	ID            string          `json:"id"`
+	Model         string          `json:"model"`
+	Name          string          `json:"name"`
+	Category      string          `json:"category"`
+	URL           string          `json:"url"`
+	Price         decimal.Decimal `json:"price"`
+	PriceCash     decimal.Decimal `json:"priceCash"`
+	PriceExchange decimal.Decimal `json:"priceExchange"`
+	Stores        []apiStore      `json:"stores"`
+}
+
+// apiStoresMeta describes the store location data in use.
+type apiStoresMeta struct {
+	LocationDistancesOK bool   `json:"locationDistancesOK"`
+	StoresSource        string `json:"storesSource"`
+	StoresAgeSeconds    int64  `json:"storesAgeSeconds"`
+	StoresStale         bool   `json:"storesStale"`
+}
+
+// apiSearchMeta describes a search.
+type apiSearchMeta struct {
+	Queries    []string  `json:"queries"`
+	Strict     bool      `json:"strict"`
+	Origins    []string  `json:"origins"`
+	Units      string    `json:"units"`
+	Count      int       `json:"count"`
+	SearchedAt time.Time `json:"searchedAt"`
+	DurationMS int64     `json:"durationMS"`
+	// Stale reports results from the cache of recent results as the
+	// live search failed, which are StaleAgeSeconds old
+	Stale           bool  `json:"stale"`
+	StaleAgeSeconds int64 `json:"staleAgeSeconds,omitempty"`
+	apiStoresMeta
+}
+
+// setStale marks the results as from the stale results cache, of age.
+func (m *apiSearchMeta) setStale(age time.Duration) {
+	m.Stale, m.StaleAgeSeconds = true, int64(age.Seconds())
+}
+
+// apiSearchResponse is the json response of a search. Error reports
+// any errors from individual queries, or that nothing was found.
+type apiSearchResponse struct {
+	Meta  apiSearchMeta `json:"meta"`
+	Boxes []apiBox      `json:"boxes"`
+	Error string        `json:"error,omitempty"`
+}
+
+// apiStoresMetadata describes a stores listing.
+type apiStoresMetadata struct {
+	Origins []string `json:"origins"`
+	Units   string   `json:"units"`
+	Count   int      `json:"count"`
+	apiStoresMeta
+}
+
+// apiStoresResponse is the json response of a stores listing.
+type apiStoresResponse struct {
+	Meta   apiStoresMetadata `json:"meta"`
+	Stores []apiStore        `json:"stores"`
+}
+
+// apiCacheStats reports the use of the location cache.
+type apiCacheStats struct {
+	Size         int    `json:"size"`
+	Capacity     int    `json:"capacity"`
+	Hits         uint64 `json:"hits"`
+	NotFoundHits uint64 `json:"notFoundHits"`
+	Misses       uint64 `json:"misses"`
+	Evictions    uint64 `json:"evictions"`
+}
+
+// apiHealthResponse is the json response of the health endpoint.
+type apiHealthResponse struct {
+	Status        string        `json:"status"`
+	Version       string        `json:"version"`
+	LocationCache apiCacheStats `json:"locationCache"`
+	apiStoresMeta
+}
+
+// writeJSON writes v as json with the status code.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(v); err != nil {
+		log.Printf("api json encoding error: %v", err)
+	}
+}
+
+// newAPIStore converts a store with distance to an apiStore.
+func newAPIStore(s location.StoreWithDistance) apiStore {
+	as := apiStore{
+		ID:          s.StoreID,
+		Name:        s.StoreName,
+		Region:      s.RegionName,
+		Latitude:    s.Latitude,
+		Longitude:   s.Longitude,
+		ClosingTime: s.ClosingTime,
+	}
+	if s.StoreID == 0 || s.Resolution == location.ResolutionNone {
+		return as
+	}
+	as.Distance = &apiDistance{
+		Miles:       s.DistanceMiles,
+		Km:          s.DistanceKm,
+		Formatted:   s.FormatDistance(),
+		Resolution:  s.Resolution.String(),
+		Approximate: s.Resolution.Approximate(),
+		Nearest:     s.Nearest,
+		Origins:     []apiOriginDistance{},
+	}
+	for _, od := range s.Distances {
+		as.Distance.Origins = append(as.Distance.Origins, apiOriginDistance{
+			Origin:     od.Origin,
+			Miles:      od.DistanceMiles,
+			Km:         od.DistanceKm,
+			Resolution: od.Resolution.String(),
+		})
+	}
+	return as
+}
+
+// newAPIBox converts a Box to an apiBox.
+func newAPIBox(b cexfind.Box) apiBox {
+	ab := apiBox{
+		ID:            b.ID,
+		Model:         b.Model,
+		Name:          b.Name,
+		Category:      b.Category,
+		URL:           b.IDUrl(),
+		Price:         b.Price,
+		PriceCash:     b.PriceCash,
+		PriceExchange: b.PriceExchange,
+		Stores:        []apiStore{},
+	}
+	for _, s := range b.Stores {
+		ab.Stores = append(ab.Stores, newAPIStore(s))
+	}
+	return ab
+}
+
+// originLabels returns the labels of origins.
+func originLabels(origins []location.Origin) []string {
+	labels := []string{}
+	for _, o := range origins {
+		labels = append(labels, o.Label())
+	}
+	return labels
+}
+
+// storesMeta reports the store location data in use.
+func (s *server) storesMeta() apiStoresMeta {
+	age, source := s.cex.LocationStoresAge()
+	stale, _ := s.cex.LocationStoresStale()
+	return apiStoresMeta{
+		LocationDistancesOK: s.cex.LocationDistancesOK(),
+		StoresSource:        source.String(),
+		StoresAgeSeconds:    int64(age.Seconds()),
+		StoresStale:         stale,
+	}
+}
+
+// APISearch searches for the queries in the url query string or posted
+// form, taking the same parameters as the search form, and returns the
+// results as json. A search which finds nothing is not an error, but
+// failure to reach Cex for any query is reported as a bad gateway.
+func (s *server) APISearch(w http.ResponseWriter, r *http.Request) {
+
+	if err := r.ParseForm(); err != nil {
+		s.writeError(w, r, limitStatus(err, http.StatusBadRequest), fmt.Errorf("form error: %w", err))
+		return
+	}
+	search, err := s.parseSearch(r.Form)
+	if err != nil {
+		writeJSON(w, limitStatus(err, http.StatusBadRequest), apiError{err.Error()})
+		return
+	}
+	release, ok := s.acquireUpstream(w, r, len(search.Queries))
+	if !ok {
+		return
+	}
+	defer release()
+
+	start := time.Now()
+	results, err := s.searcher(s.cex, search.Queries, search.Form.Strict, search.Origins...)
+	setDistanceUnit(results, search.Unit)
+	_, stale, _ := splitStale(err)
+	s.history.recordLive(results, stale)
+
+	resp, status := s.newAPISearchResponse(search, results, err, start)
+	writeJSON(w, status, resp)
+}
+
+// newAPISearchResponse returns the api response for the results of a
+// search made at start, and its http status.
+func (s *server) newAPISearchResponse(search searchRequest, results []cexfind.Box, err error, start time.Time) (apiSearchResponse, int) {
+	resp := apiSearchResponse{
+		Meta: apiSearchMeta{
+			Queries:       search.Queries,
+			Strict:        search.Form.Strict,
+			Origins:       originLabels(search.Origins),
+			Units:         search.Unit.String(),
+			Count:         len(results),
+			SearchedAt:    start.UTC(),
+			DurationMS:    time.Since(start).Milliseconds(),
+			apiStoresMeta: s.storesMeta(),
+		},
+		Boxes: []apiBox{},
+	}
+	for _, b := range results {
+		resp.Boxes = append(resp.Boxes, newAPIBox(b))
+	}
+
+	age, stale, err := splitStale(err)
+	if stale {
+		resp.Meta.setStale(age)
+	}
+	status := http.StatusOK
+	if err != nil {
+		resp.Error = err.Error()
+		noResults := errors.Is(err, cexfind.ErrNoResults) || errors.Is(err, cexfind.ErrNoResultsFound)
+		if len(results) == 0 && !noResults {
+			status = http.StatusBadGateway
+		}
+	}
+	return resp, status
+}
+
+// storesErrorStatus returns the http status for an error listing the
+// stores: a bad request for a postcode that is invalid or can't be
+// found, service unavailable if the upstream is busy or suspended, and
+// otherwise a bad gateway, such as for a geocoding service failure.
+func storesErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, location.ErrLocationNotFound),
+		errors.Is(err, location.ErrInvalidPostcode),
+		errors.Is(err, location.ErrInvalidCoordinate):
+		return http.StatusBadRequest
+	case errors.Is(err, cexfind.ErrCircuitOpen), errors.Is(err, errUpstreamBusy):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadGateway
+}
+
+// APIStores lists all stores as json, sorted by distance if a postcode
+// is provided.
+func (s *server) APIStores(w http.ResponseWriter, r *http.Request) {
+
+	origins, err := location.ParseOrigins(r.FormValue("postcode"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{fmt.Sprintf("location error: %v", err)})
+		return
+	}
+	unit := s.cex.DistanceUnit()
+	if u := r.FormValue("units"); u != "" {
+		unit, err = location.ParseUnit(u)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{fmt.Sprintf("units error: %v", err)})
+			return
+		}
+	}
+
+	stores, err := s.storeLister(s.cex, origins...)
+	if err != nil {
+		writeJSON(w, storesErrorStatus(err), apiError{err.Error()})
+		return
+	}
+
+	resp := apiStoresResponse{
+		Meta: apiStoresMetadata{
+			Origins:       originLabels(origins),
+			Units:         unit.String(),
+			Count:         len(stores),
+			apiStoresMeta: s.storesMeta(),
+		},
+		Stores: []apiStore{},
+	}
+	for _, st := range stores {
+		st.Unit = unit
+		resp.Stores = append(resp.Stores, newAPIStore(st))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// APIHealth reports the status of the service and its store location
+// data as json.
+func (s *server) APIHealth(w http.ResponseWriter, r *http.Request) {
+	stats := s.cex.LocationCacheStats()
+	writeJSON(w, http.StatusOK, apiHealthResponse{
+		Status:  "up",
+		Version: apiVersion,
+		LocationCache: apiCacheStats{
+			Size:         stats.Size,
+			Capacity:     stats.Capacity,
+			Hits:         stats.Hits,
+			NotFoundHits: stats.NotFoundHits,
+			Misses:       stats.Misses,
+			Evictions:    stats.Evictions,
+		},
+		apiStoresMeta: s.storesMeta(),
+	})
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/rorycl/cexfind"
+	"github.com/rorycl/cexfind/location"
+	"github.com/shopspring/decimal"
+)
+
+// TestAPISearch tests the json search api; note that cexfind.Search is
+// swapped out
+func TestAPISearch(t *testing.T) {
+
+	s := newServer()
+
+	var searchErr error
+	s.searcher = func(cf *cexfind.CexFind, queries []string, strict bool, origins ...location.Origin) ([]cexfind.Box, error) {
+		if searchErr != nil {
+			return nil, searchErr
+		}
+		return []cexfind.Box{
+			cexfind.Box{Model: "1a", Name: "1a name", ID: "id1", Price: decimal.NewFromInt(1),
+				Stores: []location.StoreWithDistance{
+					{StoreID: 1, StoreName: "a", DistanceMiles: 5.6, DistanceKm: 9.01, Resolution: location.ResolutionPostcode,
+						Distances: []location.OriginDistance{{Origin: "S10 1LT", DistanceMiles: 5.6, DistanceKm: 9.01}}},
+					{StoreName: "b"},
+				},
+			},
+		}, nil
+	}
+
+	tests := []struct {
+		method     string
+		input      string
+		searchErr  error
+		statusCode int
+		count      int
+		formatted  string
+		errorText  string
+	}{
+		{
+			method:     http.MethodGet,
+			input:      "query=abc&strict=true",
+			statusCode: http.StatusOK,
+			count:      1,
+			formatted:  "5.6mi",
+		},
+		{
+			method:     http.MethodPost,
+			input:      "query=abc&postcode=S10+1LT&units=km",
+			statusCode: http.StatusOK,
+			count:      1,
+			formatted:  "9.0km",
+		},
+		{
+			method:     http.MethodGet,
+			input:      "query=ab",
+			statusCode: http.StatusBadRequest,
+			errorText:  "query error",
+		},
+		{
+			method:     http.MethodGet,
+			input:      "query=abc&postcode=S10+1L",
+			statusCode: http.StatusBadRequest,
+			errorText:  "location error",
+		},
+		{
+			method:     http.MethodGet,
+			input:      "strict=true",
+			statusCode: http.StatusBadRequest,
+			errorText:  "no query found",
+		},
+		{
+			method:     http.MethodGet,
+			input:      "query=abc",
+			searchErr:  fmt.Errorf("\"abc\": %w", cexfind.ErrNoResultsFound),
+			statusCode: http.StatusOK,
+			errorText:  "no results found",
+		},
+		{
+			method:     http.MethodGet,
+			input:      "query=abc",
+			searchErr:  errors.New("\"abc\": http call error"),
+			statusCode: http.StatusBadGateway,
+			errorText:  "http call error",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			searchErr = tt.searchErr
+
+			var r *http.Request
+			if tt.method == http.MethodGet {
+				r = httptest.NewRequest(tt.method, "http://example.com/api/v1/search?"+tt.input, nil)
+			} else {
+				r = httptest.NewRequest(tt.method, "http://example.com/api/v1/search", strings.NewReader(tt.input))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			w := httptest.NewRecorder()
+			s.APISearch(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+			if got, want := res.StatusCode, tt.statusCode; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := res.Header.Get("Content-Type"), "application/json; charset=utf-8"; got != want {
+				t.Errorf("got content type %s want %s", got, want)
+			}
+
+			// api errors share the "error" field of search responses
+			var resp apiSearchResponse
+			if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(resp.Error, tt.errorText) {
+				t.Errorf("got error %q want %q", resp.Error, tt.errorText)
+			}
+			if tt.statusCode == http.StatusBadRequest {
+				return
+			}
+			if got, want := resp.Meta.Count, tt.count; got != want {
+				t.Fatalf("got count %d want %d", got, want)
+			}
+			if tt.count == 0 {
+				return
+			}
+			box := resp.Boxes[0]
+			if got, want := box.URL, "https://uk.webuy.com/product-detail?id=id1"; got != want {
+				t.Errorf("got url %s want %s", got, want)
+			}
+			if got, want := box.Stores[0].Distance.Formatted, tt.formatted; got != want {
+				t.Errorf("got formatted distance %s want %s", got, want)
+			}
+			if box.Stores[1].Distance != nil {
+				t.Errorf("expected no distance for unlocated store, got %+v", box.Stores[1].Distance)
+			}
+		})
+	}
+}
+
+// TestAPIStores tests the stores listing
+func TestAPIStores(t *testing.T) {
+
+	s := newServer()
+
+	tests := []struct {
+		input      string
+		statusCode int
+	}{
+		{"", http.StatusOK},
+		{"postcode=51.5,-0.1&units=km", http.StatusOK},
+		{"postcode=S10+1L", http.StatusBadRequest},
+		{"units=furlongs", http.StatusBadRequest},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/api/v1/stores?"+tt.input, nil)
+			w := httptest.NewRecorder()
+			s.APIStores(w, r)
+			res := w.Result()
+			defer res.Body.Close()
+			if got, want := res.StatusCode, tt.statusCode; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if tt.statusCode != http.StatusOK {
+				return
+			}
+			var resp apiStoresResponse
+			if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := resp.Meta.Count, len(resp.Stores); got != want {
+				t.Errorf("got count %d want %d", got, want)
+			}
+		})
+	}
+}
+
+// TestStoresErrorStatus tests store listing errors are reported as
+// client or upstream failures
+func TestStoresErrorStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{fmt.Errorf("location error: could not resolve S10 1XX: %w", location.ErrLocationNotFound), http.StatusBadRequest},
+		{fmt.Errorf("location error: %w", location.ErrInvalidPostcode), http.StatusBadRequest},
+		{cexfind.ErrCircuitOpen, http.StatusServiceUnavailable},
+		{errUpstreamBusy, http.StatusServiceUnavailable},
+		{errors.New("location error: postcodes.io error: 500 Internal Server Error"), http.StatusBadGateway},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			if got := storesErrorStatus(tt.err); got != tt.want {
+				t.Errorf("got status %d want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestAPIHealth tests the json health endpoint
+func TestAPIHealth(t *testing.T) {
+	s := newServer()
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/api/v1/health", nil)
+	w := httptest.NewRecorder()
+	s.APIHealth(w, r)
+	res := w.Result()
+	defer res.Body.Close()
+
+	var resp apiHealthResponse
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resp.Status, "up"; got != want {
+		t.Errorf("got status %s want %s", got, want)
+	}
+	if got, want := resp.LocationCache.Capacity, location.DefaultLocationCacheSize; got != want {
+		t.Errorf("got cache capacity %d want %d", got, want)
+	}
+}
+
+// TestOpenAPI checks the generated specification describes each api
+// path and response type
+func TestOpenAPI(t *testing.T) {
+	s := newServer()
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/api/v1/openapi.json", nil)
+	w := httptest.NewRecorder()
+	s.OpenAPI(w, r)
+	res := w.Result()
+	defer res.Body.Close()
+
+	var spec struct {
+		OpenAPI    string         `json:"openapi"`
+		Paths      map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]any `json:"properties"`
+				Required   []string       `json:"required"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&spec); err != nil {
+		t.Fatal(err)
+	}
+
+	paths := []string{}
+	for p := range spec.Paths {
+		paths = append(paths, p)
+	}
+	slices.Sort(paths)
+	if got, want := paths, []string{"/api/v1/health", "/api/v1/search", "/api/v1/stores"}; !slices.Equal(got, want) {
+		t.Errorf("got paths %v want %v", got, want)
+	}
+
+	for _, name := range []string{"SearchResponse", "Box", "Store", "Distance", "StoresResponse", "HealthResponse", "Error"} {
+		if _, ok := spec.Components.Schemas[name]; !ok {
+			t.Errorf("schema %s not found", name)
+		}
+	}
+	// embedded structs are flattened
+	if _, ok := spec.Components.Schemas["SearchMeta"].Properties["storesSource"]; !ok {
+		t.Error("expected SearchMeta to include storesSource")
+	}
+	if _, ok := spec.Components.Schemas["Store"].Properties["distance"]; !ok {
+		t.Error("expected Store to include distance")
+	}
+
+	// unsupported types are reported rather than described
+	g := schemaGenerator{components: map[string]any{}}
+	g.schema(reflect.TypeFor[struct{ M map[string]int }]())
+	if g.err == nil || !strings.Contains(g.err.Error(), "unsupported type map[string]int") {
+		t.Errorf("expected unsupported type error, got %v", g.err)
+	}
+}
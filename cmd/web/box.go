@@ -0,0 +1,218 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/rorycl/cexfind"
+	"github.com/rorycl/cexfind/location"
+)
+
+// boxIDPattern is the route pattern of a box id.
+const boxIDPattern = "[A-Za-z0-9_-]{1,64}"
+
+// validBoxID matches a box id.
+var validBoxID = regexp.MustCompile("^" + boxIDPattern + "$")
+
+// errBoxNotFound reports a box that could not be found at Cex.
+var errBoxNotFound = errors.New("item not found")
+
+// gradeless returns the id of box without its grade, which is shared
+// by the other grades of the same item.
+func gradeless(box cexfind.Box) string {
+	return strings.TrimSuffix(box.ID, box.Grade())
+}
+
+// otherGrades returns the boxes which are other grades of box, sorted
+// by grade.
+func otherGrades(box cexfind.Box, boxes []cexfind.Box) []cexfind.Box {
+	grades := []cexfind.Box{}
+	if box.Grade() == "" {
+		return grades
+	}
+	for _, b := range boxes {
+		if b.ID != box.ID && b.Grade() != "" && gradeless(b) == gradeless(box) {
+			grades = append(grades, b)
+		}
+	}
+	slices.SortFunc(grades, func(a, b cexfind.Box) int { return cmp.Compare(a.Grade(), b.Grade()) })
+	return grades
+}
+
+// boxSearch returns the search parameters of the box page; the
+// postcode, which may be several origins, and the distance unit.
+func (s *server) boxSearch(vals url.Values) (postcode string, origins []location.Origin, unit location.Unit, err error) {
+	postcode = vals.Get("postcode")
+	origins, err = location.ParseOrigins(postcode)
+	if err != nil {
+		return postcode, nil, unit, fmt.Errorf("location error: %w", err)
+	}
+	unit = s.cex.DistanceUnit()
+	if units := vals.Get("units"); units != "" {
+		if unit, err = location.ParseUnit(units); err != nil {
+			return postcode, nil, unit, fmt.Errorf("units error: %w", err)
+		}
+	}
+	return postcode, origins, unit, nil
+}
+
+// lookupBox returns the box with id and the other grades of the same
+// item, taken from the cached search results if possible, when key is
+// that of the search in which it was found. Otherwise the box is
+// searched for by id with distances from origins, which counts towards
+// the client's rate limit, and key is empty. If the box can't be found
+// an error response is written.
+func (s *server) lookupBox(w http.ResponseWriter, r *http.Request, id string, origins []location.Origin, unit location.Unit) (box cexfind.Box, key string, grades []cexfind.Box, ok bool) {
+
+	if box, key, grades, ok := s.results.find(id); ok {
+		return box, key, grades, true
+	}
+	if !s.allowClient(w, r) {
+		return box, "", nil, false
+	}
+	release, ok := s.acquireUpstream(w, r, 1)
+	if !ok {
+		return box, "", nil, false
+	}
+	search := searchRequest{Queries: []string{id}, Origins: origins, Unit: unit}
+	boxes, err := s.searcher(s.cex, search.Queries, false, origins...)
+	release()
+	noResults := errors.Is(err, cexfind.ErrNoResults) || errors.Is(err, cexfind.ErrNoResultsFound)
+	if err != nil && len(boxes) == 0 && !noResults {
+		log.Printf("box %s search error: %v", id, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return box, "", nil, false
+	}
+	i := slices.IndexFunc(boxes, func(b cexfind.Box) bool { return b.ID == id })
+	if i < 0 {
+		http.Error(w, fmt.Sprintf("%s: %s", errBoxNotFound, id), http.StatusNotFound)
+		return box, "", nil, false
+	}
+	setDistanceUnit(boxes, unit)
+	age, stale, _ := splitStale(err)
+	s.history.recordLive(boxes, stale)
+	s.results.putStale(search.urlQuery(), boxes, age)
+	return boxes[i], "", otherGrades(boxes[i], boxes), true
+}
+
+// searchOrigins returns the postcode and origins of the cached search
+// with key, and its distance unit if useSearchUnit, otherwise unit.
+func (s *server) searchOrigins(key string, useSearchUnit bool, unit location.Unit) (string, []location.Origin, location.Unit) {
+	vals, _ := url.ParseQuery(key)
+	search, err := s.parseSearch(vals)
+	if err != nil {
+		return "", nil, unit
+	}
+	if useSearchUnit {
+		unit = search.Unit
+	}
+	return search.Form.Postcode, search.Origins, unit
+}
+
+// Box shows the detail page of the box with the id in the path, with
+// its prices, the stores holding it and their distances on a map, its
+// price history, if any, and links to other grades of the same item.
+// The page is a plain html page which can be shared.
+//
+// The box is taken from the cached search results if possible and
+// otherwise searched for by id, which counts towards the client's rate
+// limit. Distances are from the "postcode" parameter or, if there is
+// none, from the search in which the box was found.
+func (s *server) Box(w http.ResponseWriter, r *http.Request) {
+
+	id := mux.Vars(r)["id"]
+	if !validBoxID.MatchString(id) {
+		http.Error(w, "invalid item id", http.StatusBadRequest)
+		return
+	}
+	postcode, origins, unit, err := s.boxSearch(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	box, key, grades, ok := s.lookupBox(w, r, id, origins, unit)
+	if !ok {
+		return
+	}
+	if key != "" && postcode == "" {
+		postcode, origins, unit = s.searchOrigins(key, r.URL.Query().Get("units") == "", unit)
+	}
+
+	stores, err := s.cex.BoxStores(box, origins...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// the stores are shared with the results cache
+	stores = slices.Clone(stores)
+	for i := range stores {
+		stores[i].Unit = unit
+	}
+
+	// the query string for links to this and other boxes
+	linkQuery := url.Values{}
+	if postcode != "" {
+		linkQuery.Set("postcode", postcode)
+	}
+	if r.URL.Query().Get("units") != "" {
+		linkQuery.Set("units", unit.String())
+	}
+
+	data := struct {
+		Title      string
+		Box        cexfind.Box
+		Stores     []location.StoreWithDistance
+		Distances  bool
+		Origins    int
+		Grades     []cexfind.Box
+		Chart      *priceChart
+		Map        *storeMap
+		Postcode   string
+		Unit       string
+		LinkQuery  string
+		SearchLink string
+
+		LocationDistancesOK bool
+	}{
+		Title:    box.Name,
+		Box:      box,
+		Stores:   stores,
+		Origins:  len(origins),
+		Grades:   grades,
+		Chart:    newPriceChart(s.history.get(box.ID)),
+		Postcode: postcode,
+		Unit:     unit.String(),
+		LinkQuery: func() string {
+			if len(linkQuery) == 0 {
+				return ""
+			}
+			return "?" + linkQuery.Encode()
+		}(),
+		SearchLink: "./?" + searchRequest{
+			Form:    QueriesType{Postcode: postcode, Units: linkQuery.Get("units")},
+			Queries: []string{box.Model},
+			Unit:    unit,
+		}.urlQuery(),
+		LocationDistancesOK: s.cex.LocationDistancesOK(),
+	}
+	mapStores := make([]mapStore, len(stores))
+	for i, st := range stores {
+		mapStores[i] = mapStore{Store: st, Count: 1}
+	}
+	locations, labels := s.locateOrigins(origins)
+	data.Map = newStoreMap(mapStores, locations, labels)
+	data.Distances = slices.ContainsFunc(stores, func(st location.StoreWithDistance) bool {
+		return st.FormatDistance() != ""
+	})
+	s.render(w, "box.html", data)
+}
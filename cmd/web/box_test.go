@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
+
+	"github.com/rorycl/cexfind"
+	"github.com/rorycl/cexfind/location"
+)
+
+// TestOtherGrades tests finding the other grades of a box
+func TestOtherGrades(t *testing.T) {
+	boxes := []cexfind.Box{{ID: "x1C"}, {ID: "x1A"}, {ID: "x1B"}, {ID: "x2B"}, {ID: "x1"}}
+	got := []string{}
+	for _, b := range otherGrades(boxes[2], boxes) {
+		got = append(got, b.ID)
+	}
+	if got, want := strings.Join(got, ","), "x1A,x1C"; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+	if got := otherGrades(boxes[4], boxes); len(got) != 0 {
+		t.Errorf("expected no other grades for an ungraded box, got %v", got)
+	}
+}
+
+// TestBox tests the box page, from cached results or by searching for
+// the box
+func TestBox(t *testing.T) {
+
+	s := newServer()
+	s.DirFS = &fileSystem{}
+	s.DirFS.TplFS = os.DirFS("templates")
+
+	boxes := append(viewBoxes, cexfind.Box{
+		Model: "x390", Name: "Lenovo x390 i5", Category: "Laptops", ID: "x1A", Price: decimal.NewFromInt(190),
+		Stores: []location.StoreWithDistance{{StoreID: 4, StoreName: "Leeds", Latitude: 53.80, Longitude: -1.55, ClosingTime: "17:30", DistanceMiles: 1.5, Resolution: location.ResolutionPostcode}},
+	})
+	searches := []string{}
+	s.searcher = func(cex *cexfind.CexFind, queries []string, strict bool, origins ...location.Origin) ([]cexfind.Box, error) {
+		searches = append(searches, strings.Join(queries, ";"))
+		return boxes, nil
+	}
+
+	tests := []struct {
+		id         string
+		query      string
+		statusCode int
+		contains   []string
+		searches   string
+	}{
+		{
+			id:         "x1A",
+			statusCode: http.StatusOK,
+			contains:   []string{"Lenovo x390 i5", "Same model, other grades", `href="./box/x1C"`, "Leeds", "closes 17:30", "1.5mi", `<base href="../">`, `<a href="#store-4">`, `id="store-4"`},
+			searches:   "x1A",
+		},
+		{
+			id:         "x1C",
+			query:      "postcode=S10+1LT&units=km",
+			statusCode: http.StatusOK,
+			contains:   []string{`href="./box/x1A?postcode=S10&#43;1LT&amp;units=km"`, `value="S10 1LT"`},
+			searches:   "x1A", // cached
+		},
+		{
+			id:         "zz9Z",
+			statusCode: http.StatusNotFound,
+			searches:   "x1A,zz9Z",
+		},
+		{
+			id:         "x1A",
+			query:      "postcode=95,0",
+			statusCode: http.StatusBadRequest,
+			searches:   "x1A,zz9Z",
+		},
+		{
+			id:         "x1A/../..",
+			statusCode: http.StatusBadRequest,
+			searches:   "x1A,zz9Z",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/box/x?"+tt.query, nil)
+			r = mux.SetURLVars(r, map[string]string{"id": tt.id})
+			w := httptest.NewRecorder()
+			s.Box(w, r)
+
+			if got, want := w.Code, tt.statusCode; got != want {
+				t.Fatalf("got status %d want %d: %s", got, want, w.Body.String())
+			}
+			for _, c := range tt.contains {
+				if !strings.Contains(w.Body.String(), c) {
+					t.Errorf("expected body to contain %q, got\n%s", c, w.Body.String())
+				}
+			}
+			if got, want := strings.Join(searches, ","), tt.searches; got != want {
+				t.Errorf("got searches %s want %s", got, want)
+			}
+		})
+	}
+
+	// the price history is charted once the price has been seen to
+	// change
+	boxes[len(boxes)-1].Price = decimal.NewFromInt(170)
+	s.history.record(boxes)
+	r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "http://example.com/box/x1A", nil), map[string]string{"id": "x1A"})
+	w := httptest.NewRecorder()
+	s.Box(w, r)
+	for _, c := range []string{"Price history", `<polyline class="price"`, "&pound;170</span> now"} {
+		if !strings.Contains(w.Body.String(), c) {
+			t.Errorf("expected body to contain %q, got\n%s", c, w.Body.String())
+		}
+	}
+}
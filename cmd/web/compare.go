@@ -0,0 +1,346 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/rorycl/cexfind"
+)
+
+// maxCompare is the most boxes which can be compared.
+const maxCompare = 6
+
+// specKind is a kind of specification, matching parts of box names.
+type specKind struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// specKinds are the kinds of specification parsed from box names, such
+// as "Lenovo X390/i5-8265U/8GB Ram/256GB SSD/13"/W10/B", in the order
+// they are compared. Memory is matched before storage.
+var specKinds = []specKind{
+	{"processor", regexp.MustCompile(`(?i)^(i[3579]-\w+|(core|ryzen|celeron|pentium|xeon|athlon|snapdragon)\b.*|m[1-4]( (pro|max|ultra))?)$`)},
+	{"memory", regexp.MustCompile(`(?i)^(\d+(?:\.\d+)?)\s*([GT]B)\s*ram$`)},
+	{"storage", regexp.MustCompile(`(?i)^(\d+(?:\.\d+)?)\s*([GT]B)\b.*$`)},
+	{"screen", regexp.MustCompile(`^\d+(\.\d+)?("|in)$`)},
+	{"os", regexp.MustCompile(`(?i)^(w\d+\w*|win(dows)?\s?\d+.*|macos.*|chrome\s?os|linux)$`)},
+}
+
+// boxSpecs is the specification of a box parsed from its name, by kind,
+// and the size of its memory and storage in GB, if known.
+type boxSpecs struct {
+	values    map[string]string
+	memoryGB  float64
+	storageGB float64
+}
+
+// sizeGB returns the size in GB of the number and unit of a memory or
+// storage match.
+func sizeGB(match []string) float64 {
+	n, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0
+	}
+	if strings.EqualFold(match[2], "TB") {
+		n *= 1000
+	}
+	return n
+}
+
+// parseSpecs parses the specification of a box from its name. The parts
+// of names with slashes after the first, other than the grade, which
+// are not of a known kind are kept as "other". Names without slashes
+// are split into words, and only words of a known kind are kept.
+func parseSpecs(b cexfind.Box) boxSpecs {
+	specs := boxSpecs{values: map[string]string{}}
+	parts := strings.Split(b.Name, "/")
+	keepOther := len(parts) > 1
+	if keepOther {
+		parts = parts[1:]
+		if n := len(parts); n > 0 && parts[n-1] == b.Grade() {
+			parts = parts[:n-1]
+		}
+	} else {
+		parts = strings.FieldsFunc(b.Name, func(r rune) bool { return r == ' ' || r == ',' })
+	}
+	var others []string
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		i := slices.IndexFunc(specKinds, func(k specKind) bool { return k.re.MatchString(p) })
+		if i < 0 {
+			if keepOther {
+				others = append(others, p)
+			}
+			continue
+		}
+		kind := specKinds[i]
+		if _, ok := specs.values[kind.name]; ok {
+			continue
+		}
+		specs.values[kind.name] = p
+		switch kind.name {
+		case "memory":
+			specs.memoryGB = sizeGB(kind.re.FindStringSubmatch(p))
+		case "storage":
+			specs.storageGB = sizeGB(kind.re.FindStringSubmatch(p))
+		}
+	}
+	if len(others) > 0 {
+		specs.values["other"] = strings.Join(others, ", ")
+	}
+	return specs
+}
+
+// compareCell is a cell of a comparison row; Best marks the best value
+// of a row, such as the lowest price.
+type compareCell struct {
+	Value string
+	Best  bool
+}
+
+// compareRow is a row of a comparison, which Differs if its values are
+// not all the same.
+type compareRow struct {
+	Label   string
+	Cells   []compareCell
+	Differs bool
+}
+
+// compareColumn is a compared box, with the link to the comparison
+// without it.
+type compareColumn struct {
+	Box    cexfind.Box
+	Remove string
+}
+
+// newCompareRow returns a row of values, marking the lowest of the
+// numbers as the best if there is more than one value and they differ.
+// Numbers which are zero are ignored.
+func newCompareRow(label string, values []string, numbers []float64) compareRow {
+	row := compareRow{Label: label, Cells: make([]compareCell, len(values))}
+	for i, v := range values {
+		row.Cells[i].Value = v
+		if v != values[0] {
+			row.Differs = true
+		}
+	}
+	if !row.Differs || numbers == nil {
+		return row
+	}
+	lowest := 0.0
+	for _, n := range numbers {
+		if n > 0 && (lowest == 0 || n < lowest) {
+			lowest = n
+		}
+	}
+	for i, n := range numbers {
+		row.Cells[i].Best = n > 0 && n == lowest
+	}
+	return row
+}
+
+// pricePer returns the price per GB of size, or zero if the size isn't
+// known.
+func pricePer(price decimal.Decimal, gb float64) float64 {
+	if gb <= 0 {
+		return 0
+	}
+	return price.InexactFloat64() / gb
+}
+
+// compareRows returns the comparison of boxes, whose stores are those
+// holding them, as rows of their grades, prices, specifications, price
+// per GB of memory and storage and nearest stores. Rows are only shown
+// if any box has a value.
+func compareRows(boxes []cexfind.Box) []compareRow {
+	n := len(boxes)
+	column := func(value func(b cexfind.Box) string) []string {
+		values := make([]string, n)
+		for i, b := range boxes {
+			values[i] = value(b)
+		}
+		return values
+	}
+	numbers := func(number func(b cexfind.Box) float64) []float64 {
+		ns := make([]float64, n)
+		for i, b := range boxes {
+			ns[i] = number(b)
+		}
+		return ns
+	}
+	specs := make(map[string]boxSpecs, n)
+	for _, b := range boxes {
+		specs[b.ID] = parseSpecs(b)
+	}
+
+	rows := []compareRow{
+		newCompareRow("model", column(func(b cexfind.Box) string { return b.Model }), nil),
+		newCompareRow("category", column(func(b cexfind.Box) string { return b.Category }), nil),
+		newCompareRow("grade", column(cexfind.Box.Grade), nil),
+		newCompareRow("price",
+			column(func(b cexfind.Box) string { return "£" + b.Price.String() }),
+			numbers(func(b cexfind.Box) float64 { return b.Price.InexactFloat64() })),
+		newCompareRow("cash", column(func(b cexfind.Box) string { return "£" + b.PriceCash.String() }), nil),
+		newCompareRow("exchange", column(func(b cexfind.Box) string { return "£" + b.PriceExchange.String() }), nil),
+	}
+	kinds := []string{}
+	for _, k := range specKinds {
+		kinds = append(kinds, k.name)
+	}
+	for _, kind := range append(kinds, "other") {
+		values := column(func(b cexfind.Box) string { return specs[b.ID].values[kind] })
+		if slices.ContainsFunc(values, func(v string) bool { return v != "" }) {
+			rows = append(rows, newCompareRow(kind, values, nil))
+		}
+	}
+	for _, per := range []struct {
+		label string
+		gb    func(boxSpecs) float64
+	}{
+		{"£ per GB memory", func(sp boxSpecs) float64 { return sp.memoryGB }},
+		{"£ per GB storage", func(sp boxSpecs) float64 { return sp.storageGB }},
+	} {
+		ns := numbers(func(b cexfind.Box) float64 { return pricePer(b.Price, per.gb(specs[b.ID])) })
+		if !slices.ContainsFunc(ns, func(f float64) bool { return f > 0 }) {
+			continue
+		}
+		values := make([]string, n)
+		for i, f := range ns {
+			if f > 0 {
+				values[i] = "£" + strconv.FormatFloat(f, 'f', 2, 64)
+			}
+		}
+		rows = append(rows, newCompareRow(per.label, values, ns))
+	}
+
+	nearest := make([]string, n)
+	distances := make([]float64, n)
+	for i, b := range boxes {
+		st, ok := b.NearestStore()
+		switch {
+		case ok:
+			nearest[i] = st.StoreName + " " + st.FormatDistance()
+			distances[i] = max(st.DistanceMiles, 0.001)
+		case len(b.Stores) > 0:
+			nearest[i] = b.Stores[0].StoreName
+		}
+	}
+	if slices.ContainsFunc(nearest, func(v string) bool { return v != "" }) {
+		rows = append(rows, newCompareRow("nearest store", nearest, distances))
+	}
+	return rows
+}
+
+// compareIDs returns the distinct box ids in vals, in order, checking
+// that there are between two and maxCompare.
+func compareIDs(vals url.Values) ([]string, error) {
+	var ids []string
+	for _, id := range vals["id"] {
+		if !validBoxID.MatchString(id) {
+			return nil, fmt.Errorf("invalid item id %q", id)
+		}
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) < 2 || len(ids) > maxCompare {
+		return nil, fmt.Errorf("choose between 2 and %d items to compare", maxCompare)
+	}
+	return ids, nil
+}
+
+// Compare shows the boxes with the "id" parameters side by side, with
+// their prices, specifications parsed from their names, the price per
+// GB of memory and storage and their nearest stores. Rows which differ
+// are highlighted, as are the best values. The page is plain html and
+// may be shared.
+//
+// Boxes are looked up as for the box page. Distances are from the
+// "postcode" parameter or, if there is none, from the search in which
+// the first box was found.
+func (s *server) Compare(w http.ResponseWriter, r *http.Request) {
+
+	ids, err := compareIDs(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	postcode, origins, unit, err := s.boxSearch(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	boxes := make([]cexfind.Box, len(ids))
+	for i, id := range ids {
+		box, key, _, ok := s.lookupBox(w, r, id, origins, unit)
+		if !ok {
+			return
+		}
+		if i == 0 && key != "" && postcode == "" {
+			postcode, origins, unit = s.searchOrigins(key, r.URL.Query().Get("units") == "", unit)
+		}
+		boxes[i] = box
+	}
+	for i, b := range boxes {
+		stores, err := s.cex.BoxStores(b, origins...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// the stores are shared with the results cache
+		boxes[i].Stores = slices.Clone(stores)
+		for j := range boxes[i].Stores {
+			boxes[i].Stores[j].Unit = unit
+		}
+	}
+
+	columns := make([]compareColumn, len(boxes))
+	for i, b := range boxes {
+		vals := url.Values{}
+		for _, id := range ids {
+			if id != b.ID {
+				vals.Add("id", id)
+			}
+		}
+		if postcode != "" {
+			vals.Set("postcode", postcode)
+		}
+		if r.URL.Query().Get("units") != "" {
+			vals.Set("units", unit.String())
+		}
+		columns[i] = compareColumn{Box: b}
+		if len(ids) > 2 {
+			columns[i].Remove = "./compare?" + vals.Encode()
+		}
+	}
+
+	s.render(w, "compare.html", struct {
+		Title               string
+		Columns             []compareColumn
+		Rows                []compareRow
+		IDs                 []string
+		Postcode            string
+		Unit                string
+		LocationDistancesOK bool
+	}{
+		Title:               "compare items",
+		Columns:             columns,
+		Rows:                compareRows(boxes),
+		IDs:                 ids,
+		Postcode:            postcode,
+		Unit:                unit.String(),
+		LocationDistancesOK: s.cex.LocationDistancesOK(),
+	})
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/rorycl/cexfind"
+	"github.com/rorycl/cexfind/location"
+)
+
+// TestParseSpecs tests parsing specifications from box names
+func TestParseSpecs(t *testing.T) {
+	tests := []struct {
+		name      string
+		id        string
+		want      string
+		memoryGB  float64
+		storageGB float64
+	}{
+		{
+			name:      `Lenovo X390/i5-8265U/8GB Ram/256GB SSD/13"/W10/B`,
+			id:        "PALSLENX39065B",
+			want:      `memory:8GB Ram os:W10 processor:i5-8265U screen:13" storage:256GB SSD`,
+			memoryGB:  8,
+			storageGB: 256,
+		},
+		{
+			name:      `Apple MacBook Air 13/M1/16GB Ram/1TB SSD/Space Grey/C`,
+			id:        "SLAPMBAM1C",
+			want:      `memory:16GB Ram other:Space Grey processor:M1 storage:1TB SSD`,
+			memoryGB:  16,
+			storageGB: 1000,
+		},
+		{
+			name:      "Apple iPhone 13 128GB Midnight, Unlocked B",
+			id:        "SAPPI13128GMUB",
+			want:      "storage:128GB",
+			storageGB: 128,
+		},
+		{
+			name: "Lenovo ThinkPad X390 Power Adapter",
+			id:   "ADLENX390",
+			want: "",
+		},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			specs := parseSpecs(cexfind.Box{Name: tt.name, ID: tt.id})
+			got := []string{}
+			for _, k := range []string{"memory", "os", "other", "processor", "screen", "storage"} {
+				if v, ok := specs.values[k]; ok {
+					got = append(got, k+":"+v)
+				}
+			}
+			if got := strings.Join(got, " "); got != tt.want {
+				t.Errorf("got %s want %s", got, tt.want)
+			}
+			if specs.memoryGB != tt.memoryGB || specs.storageGB != tt.storageGB {
+				t.Errorf("got %vGB memory %vGB storage want %v %v", specs.memoryGB, specs.storageGB, tt.memoryGB, tt.storageGB)
+			}
+		})
+	}
+}
+
+// TestCompareRows tests the comparison highlights differences and the
+// best values
+func TestCompareRows(t *testing.T) {
+	boxes := []cexfind.Box{
+		{Model: "x390", Name: `Lenovo X390/i5-8265U/8GB Ram/256GB SSD/13"/W10/B`, ID: "x65B", Price: decimal.NewFromInt(200),
+			Stores: []location.StoreWithDistance{{StoreID: 1, StoreName: "Leeds", DistanceMiles: 5, Resolution: location.ResolutionPostcode}}},
+		{Model: "x390", Name: `Lenovo X390/i7-8665U/16GB Ram/512GB SSD/13"/W11/B`, ID: "x97B", Price: decimal.NewFromInt(320),
+			Stores: []location.StoreWithDistance{{StoreID: 2, StoreName: "York", DistanceMiles: 2, Resolution: location.ResolutionPostcode}}},
+	}
+	rows := map[string]compareRow{}
+	for _, r := range compareRows(boxes) {
+		rows[r.Label] = r
+	}
+	cells := func(r compareRow) string {
+		s := []string{}
+		for _, c := range r.Cells {
+			if c.Best {
+				c.Value += "*"
+			}
+			s = append(s, c.Value)
+		}
+		return strings.Join(s, "|")
+	}
+
+	tests := []struct {
+		label   string
+		want    string
+		differs bool
+	}{
+		{"model", "x390|x390", false},
+		{"grade", "B|B", false},
+		{"price", "£200*|£320", true},
+		{"memory", "8GB Ram|16GB Ram", true},
+		{"screen", `13"|13"`, false},
+		{"£ per GB memory", "£25.00|£20.00*", true},
+		{"£ per GB storage", "£0.78|£0.62*", true},
+		{"nearest store", "Leeds 5.0mi|York 2.0mi*", true},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			r, ok := rows[tt.label]
+			if !ok {
+				t.Fatalf("no %s row", tt.label)
+			}
+			if got := cells(r); got != tt.want {
+				t.Errorf("got %s want %s", got, tt.want)
+			}
+			if r.Differs != tt.differs {
+				t.Errorf("got differs %t want %t", r.Differs, tt.differs)
+			}
+		})
+	}
+	if _, ok := rows["other"]; ok {
+		t.Error("unexpected other row")
+	}
+}
+
+// TestCompareIDs tests the compared ids are checked
+func TestCompareIDs(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"id=a1B&id=b2C", "a1B,b2C"},
+		{"id=a1B&id=b2C&id=a1B", "a1B,b2C"},
+		{"id=a1B", "error"},
+		{"id=a1B&id=a1B", "error"},
+		{"id=a1B&id=b/2", "error"},
+		{"id=1&id=2&id=3&id=4&id=5&id=6&id=7", "error"},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			vals, _ := url.ParseQuery(tt.query)
+			ids, err := compareIDs(vals)
+			got := strings.Join(ids, ",")
+			if err != nil {
+				got = "error"
+			}
+			if got != tt.want {
+				t.Errorf("got %s want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCompare tests the comparison page, from the results cache or by
+// searching for each box
+func TestCompare(t *testing.T) {
+
+	s := newServer()
+	s.DirFS = &fileSystem{}
+	s.DirFS.TplFS = os.DirFS("templates")
+
+	searches := []string{}
+	s.searcher = func(cex *cexfind.CexFind, queries []string, strict bool, origins ...location.Origin) ([]cexfind.Box, error) {
+		searches = append(searches, strings.Join(queries, ";"))
+		if queries[0] == "zz9Z" {
+			return nil, cexfind.ErrNoResultsFound
+		}
+		return viewBoxes, nil
+	}
+	s.results.put("query=thinkpad", viewBoxes)
+
+	tests := []struct {
+		query      string
+		statusCode int
+		contains   []string
+		searches   string
+	}{
+		{
+			query:      "id=x1C&id=x2B&id=t2B",
+			statusCode: http.StatusOK,
+			contains:   []string{`href="./box/x1C"`, `<tr class="differs"><th>price</th>`, `<td class="best">£90</td>`, `href="./compare?id=x2B&amp;id=t2B"`},
+			searches:   "",
+		},
+		{
+			query:      "id=x1C&id=t1A&units=km",
+			statusCode: http.StatusOK,
+			contains:   []string{`<td class="best">£150</td>`, `<option value="km" selected>`},
+			searches:   "",
+		},
+		{
+			query:      "id=x1C&id=zz9Z",
+			statusCode: http.StatusNotFound,
+			searches:   "zz9Z",
+		},
+		{
+			query:      "id=x1C",
+			statusCode: http.StatusBadRequest,
+			searches:   "zz9Z",
+		},
+		{
+			query:      "id=x1C&id=x2B&postcode=95,0",
+			statusCode: http.StatusBadRequest,
+			searches:   "zz9Z",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/compare?"+tt.query, nil)
+			w := httptest.NewRecorder()
+			s.Compare(w, r)
+
+			if got, want := w.Code, tt.statusCode; got != want {
+				t.Fatalf("got status %d want %d: %s", got, want, w.Body.String())
+			}
+			for _, c := range tt.contains {
+				if !strings.Contains(w.Body.String(), c) {
+					t.Errorf("expected body to contain %q, got\n%s", c, w.Body.String())
+				}
+			}
+			if got, want := strings.Join(searches, ","), tt.searches; got != want {
+				t.Errorf("got searches %q want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rorycl/cexfind"
+	"github.com/rorycl/cexfind/cmd"
+)
+
+// Feeds of search results are provided in Atom and RSS formats, taking
+// the same parameters as the search form. Each entry is a box, with an
+// id made of the box id and price, so that a price change shows as a
+// new entry in a feed reader.
+
+// atomFeed is an Atom feed (RFC 4287).
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title    string   `xml:"title"`
+	ID       string   `xml:"id"`
+	Updated  string   `xml:"updated"`
+	Link     atomLink `xml:"link"`
+	Category struct {
+		Term string `xml:"term,attr"`
+	} `xml:"category"`
+	Summary atomText `xml:"summary"`
+}
+
+// rssFeed is an RSS 2.0 feed.
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Description string  `xml:"description"`
+	Category    string  `xml:"category"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	ID          string `xml:",chardata"`
+}
+
+// feedEntryID returns the stable id of a box in a feed, made of the box
+// id and its price.
+func feedEntryID(b cexfind.Box) string {
+	return fmt.Sprintf("urn:cexfind:box:%s:%s", b.ID, b.Price.StringFixed(2))
+}
+
+// feedEntryTime returns the time of the entry for a box in a feed, when
+// it was first seen at its current price, so that feed readers don't
+// show unchanged entries as updated on each poll. Boxes without a price
+// history use now.
+func (s *server) feedEntryTime(b cexfind.Box, now time.Time) time.Time {
+	if since, ok := s.history.priceSince(b); ok {
+		return since.UTC()
+	}
+	return now
+}
+
+// feedTitle returns the title of a box in a feed.
+func feedTitle(b cexfind.Box) string {
+	return fmt.Sprintf("£%s %s", b.Price, b.Name)
+}
+
+// feedSummary returns a summary of a box in a feed.
+func feedSummary(b cexfind.Box) string {
+	summary := fmt.Sprintf("%s: £%s (cash £%s/exchange £%s)", b.Category, b.Price, b.PriceCash, b.PriceExchange)
+	if stores := b.StoresString(-1); stores != "" {
+		summary += "; stores: " + stores
+	}
+	return summary
+}
+
+// feedSearch makes the search for a feed, writing an error response
+// and returning false if the search could not be made.
+func (s *server) feedSearch(w http.ResponseWriter, r *http.Request) (searchRequest, []cexfind.Box, bool) {
+	search, err := s.parseSearch(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), limitStatus(err, http.StatusBadRequest))
+		return search, nil, false
+	}
+	release, ok := s.acquireUpstream(w, r, len(search.Queries))
+	if !ok {
+		return search, nil, false
+	}
+	defer release()
+	results, err := s.searcher(s.cex, search.Queries, search.Form.Strict, search.Origins...)
+	setDistanceUnit(results, search.Unit)
+	_, stale, _ := splitStale(err)
+	s.history.recordLive(results, stale)
+	if err != nil {
+		noResults := errors.Is(err, cexfind.ErrNoResults) || errors.Is(err, cexfind.ErrNoResultsFound)
+		if len(results) == 0 && !noResults {
+			log.Printf("feed search error: %v", err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return search, nil, false
+		}
+	}
+	return search, results, true
+}
+
+// feedTitleFor returns the title of the feed for a search.
+func feedTitleFor(search searchRequest) string {
+	return "Cex search for " + strings.Join(search.Queries, cmd.QuerySplitChar+" ")
+}
+
+// absoluteBaseURL returns the server BaseURL or, if it is not set, the
+// scheme and host of the request, since feed readers require absolute
+// links.
+func (s *server) absoluteBaseURL(r *http.Request) string {
+	if strings.HasPrefix(s.BaseURL, "http") {
+		return s.BaseURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + s.BaseURL
+}
+
+// writeXML writes v as xml with the content type.
+func writeXML(w http.ResponseWriter, contentType string, v any) {
+	w.Header().Set("Content-Type", contentType)
+	fmt.Fprint(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Printf("feed xml encoding error: %v", err)
+	}
+}
+
+// FeedAtom serves the results of a search as an Atom feed.
+func (s *server) FeedAtom(w http.ResponseWriter, r *http.Request) {
+	search, results, ok := s.feedSearch(w, r)
+	if !ok {
+		return
+	}
+	base := s.absoluteBaseURL(r)
+	now := time.Now().UTC()
+	feed := atomFeed{
+		Title:   feedTitleFor(search),
+		ID:      fmt.Sprintf("urn:cexfind:search:%x", sha1.Sum([]byte(search.urlQuery()))),
+		Updated: now.Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: base + "/feed.atom?" + search.urlQuery(), Rel: "self", Type: "application/atom+xml"},
+			{Href: base + "/?" + search.urlQuery(), Rel: "alternate", Type: "text/html"},
+		},
+		Author:  atomAuthor{Name: "cexfind"},
+		Entries: []atomEntry{},
+	}
+	for _, b := range results {
+		e := atomEntry{
+			Title:   feedTitle(b),
+			ID:      feedEntryID(b),
+			Updated: s.feedEntryTime(b, now).Format(time.RFC3339),
+			Link:    atomLink{Href: b.IDUrl(), Rel: "alternate"},
+			Summary: atomText{Type: "text", Body: feedSummary(b)},
+		}
+		e.Category.Term = b.Category
+		feed.Entries = append(feed.Entries, e)
+	}
+	writeXML(w, "application/atom+xml; charset=utf-8", feed)
+}
+
+// FeedRSS serves the results of a search as an RSS feed.
+func (s *server) FeedRSS(w http.ResponseWriter, r *http.Request) {
+	search, results, ok := s.feedSearch(w, r)
+	if !ok {
+		return
+	}
+	base := s.absoluteBaseURL(r)
+	now := time.Now().UTC()
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         feedTitleFor(search),
+			Link:          base + "/?" + search.urlQuery(),
+			Description:   "Cex items for sale matching " + strings.Join(search.Queries, cmd.QuerySplitChar+" "),
+			LastBuildDate: now.Format(time.RFC1123Z),
+			Items:         []rssItem{},
+		},
+	}
+	for _, b := range results {
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       feedTitle(b),
+			Link:        b.IDUrl(),
+			Description: feedSummary(b),
+			Category:    b.Category,
+			GUID:        rssGUID{IsPermaLink: false, ID: feedEntryID(b)},
+			PubDate:     s.feedEntryTime(b, now).Format(time.RFC1123Z),
+		})
+	}
+	writeXML(w, "application/rss+xml; charset=utf-8", feed)
+}
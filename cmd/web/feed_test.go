@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rorycl/cexfind"
+	"github.com/rorycl/cexfind/location"
+	"github.com/shopspring/decimal"
+)
+
+// feedTestServer returns a server with the searcher swapped out,
+// returning the boxes and error given
+func feedTestServer(boxes []cexfind.Box, err error) *server {
+	s := newServer()
+	s.DirFS = &fileSystem{}
+	s.DirFS.TplFS = os.DirFS("templates")
+	s.searcher = func(cf *cexfind.CexFind, queries []string, strict bool, origins ...location.Origin) ([]cexfind.Box, error) {
+		return boxes, err
+	}
+	return s
+}
+
+var feedTestBoxes = []cexfind.Box{
+	cexfind.Box{Model: "1a", Name: "1a name", ID: "id1", Category: "laptops", Price: decimal.NewFromInt(1)},
+	cexfind.Box{Model: "1b", Name: "1b name", ID: "id2", Category: "laptops", Price: decimal.NewFromFloat(2.5)},
+}
+
+// TestFeedAtom tests the Atom feed
+func TestFeedAtom(t *testing.T) {
+
+	tt := []struct {
+		input      string
+		boxes      []cexfind.Box
+		err        error
+		statusCode int
+		ids        []string
+	}{
+		{
+			input:      "query=abc",
+			boxes:      feedTestBoxes,
+			statusCode: http.StatusOK,
+			ids:        []string{"urn:cexfind:box:id1:1.00", "urn:cexfind:box:id2:2.50"},
+		},
+		{
+			input:      "query=abc",
+			err:        cexfind.ErrNoResults,
+			statusCode: http.StatusOK,
+			ids:        []string{},
+		},
+		{
+			input:      "query=abc",
+			err:        errors.New("http call error"),
+			statusCode: http.StatusBadGateway,
+		},
+		{
+			input:      "query=ab",
+			statusCode: http.StatusBadRequest,
+		},
+	}
+
+	for i, tc := range tt {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			s := feedTestServer(tc.boxes, tc.err)
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/feed.atom?"+tc.input, nil)
+			w := httptest.NewRecorder()
+
+			s.FeedAtom(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+			if got, want := res.StatusCode, tc.statusCode; got != want {
+				t.Fatalf("expected status %d, got %d", want, got)
+			}
+			if tc.statusCode != http.StatusOK {
+				return
+			}
+			if got, want := res.Header.Get("Content-Type"), "application/atom+xml; charset=utf-8"; got != want {
+				t.Errorf("got content type %s want %s", got, want)
+			}
+			var feed atomFeed
+			if err := xml.NewDecoder(res.Body).Decode(&feed); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := feed.Title, "Cex search for abc"; got != want {
+				t.Errorf("got title %q want %q", got, want)
+			}
+			if got, want := feed.Links[0].Href, "http://example.com/feed.atom?strict=false&query=abc"; got != want {
+				t.Errorf("got self link %q want %q", got, want)
+			}
+			ids := []string{}
+			for _, e := range feed.Entries {
+				ids = append(ids, e.ID)
+			}
+			if got, want := strings.Join(ids, " "), strings.Join(tc.ids, " "); got != want {
+				t.Errorf("got ids %q want %q", got, want)
+			}
+		})
+	}
+}
+
+// TestFeedRSS tests the RSS feed
+func TestFeedRSS(t *testing.T) {
+
+	s := feedTestServer(feedTestBoxes, nil)
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/feed.rss?query=abc&query=def", nil)
+	w := httptest.NewRecorder()
+
+	s.FeedRSS(w, r)
+
+	res := w.Result()
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("expected status %d, got %d", want, got)
+	}
+	if got, want := res.Header.Get("Content-Type"), "application/rss+xml; charset=utf-8"; got != want {
+		t.Errorf("got content type %s want %s", got, want)
+	}
+	for _, c := range []string{
+		`<rss version="2.0">`,
+		`<title>Cex search for abc; def</title>`,
+		`<guid isPermaLink="false">urn:cexfind:box:id1:1.00</guid>`,
+		`<title>£2.5 1b name</title>`,
+		`<link>https://uk.webuy.com/product-detail?id=id2</link>`,
+	} {
+		if !strings.Contains(string(body), c) {
+			t.Errorf("expected body to contain %q, got\n%s", c, body)
+		}
+	}
+}
+
+// TestFeedEntryTimes tests feed entries keep the time their box was
+// first seen at its price, rather than the time of each poll
+func TestFeedEntryTimes(t *testing.T) {
+
+	boxes := slices.Clone(feedTestBoxes)
+	s := feedTestServer(nil, nil)
+	s.searcher = func(cf *cexfind.CexFind, queries []string, strict bool, origins ...location.Origin) ([]cexfind.Box, error) {
+		return slices.Clone(boxes), nil
+	}
+	first := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := first
+	s.history.now = func() time.Time { return now }
+
+	poll := func() atomFeed {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/feed.atom?query=abc", nil)
+		w := httptest.NewRecorder()
+		s.FeedAtom(w, r)
+		var feed atomFeed
+		if err := xml.NewDecoder(w.Result().Body).Decode(&feed); err != nil {
+			t.Fatal(err)
+		}
+		return feed
+	}
+	updated := func(feed atomFeed) string {
+		times := []string{}
+		for _, e := range feed.Entries {
+			times = append(times, e.Updated)
+		}
+		return strings.Join(times, " ")
+	}
+
+	// unchanged entries keep their time between polls
+	poll()
+	now = now.Add(time.Hour)
+	feed := poll()
+	want := first.Format(time.RFC3339) + " " + first.Format(time.RFC3339)
+	if got := updated(feed); got != want {
+		t.Errorf("got entry times %q want %q", got, want)
+	}
+	if feed.Updated == first.Format(time.RFC3339) {
+		t.Error("expected the feed time to be the time of the poll")
+	}
+
+	// a price change gives the entry the time of the change
+	boxes[1].Price = decimal.NewFromInt(2)
+	feed = poll()
+	want = first.Format(time.RFC3339) + " " + now.Format(time.RFC3339)
+	if got := updated(feed); got != want {
+		t.Errorf("got entry times %q want %q", got, want)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/rorycl/cexfind"
+)
+
+// notifyContext is an indirect of signal.NotifyContext to allow testing
+var notifyContext = signal.NotifyContext
+
+// shutdownSignals are the signals which shut down the server
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// draining reports and signals that the server is shutting down, so
+// that it reports itself as not ready and long running streams end.
+type draining struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   atomic.Bool
+}
+
+func newDraining() *draining {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &draining{ctx: ctx, cancel: cancel}
+}
+
+// start marks the server as draining.
+func (d *draining) start() {
+	d.done.Store(true)
+	d.cancel()
+}
+
+// healthCheck is the result of a readiness check.
+type healthCheck struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// healthResponse is the json response of the liveness and readiness
+// endpoints.
+type healthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]healthCheck `json:"checks,omitempty"`
+}
+
+// Livez reports that the server is running.
+func (s *server) Livez(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, healthResponse{Status: "alive"})
+}
+
+// Readyz reports whether the server is ready to take searches, with
+// the result of each check, returning 503 Service Unavailable if any
+// check fails: the store locations must be loaded, the upstream
+// circuit breaker not open, the templates parse and the server not be
+// shutting down.
+func (s *server) Readyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]healthCheck{}
+
+	stores := s.cex.StoresStatus()
+	detail := fmt.Sprintf("%d stores from %s", stores.Count, stores.Source)
+	if stores.LastError != nil {
+		detail += fmt.Sprintf("; last refresh error: %v", stores.LastError)
+	}
+	checks["stores"] = healthCheck{OK: s.cex.LocationDistancesOK(), Detail: detail}
+
+	breaker := s.cex.BreakerState()
+	checks["upstream"] = healthCheck{
+		OK:     breaker != cexfind.BreakerOpen,
+		Detail: "circuit breaker " + breaker.String(),
+	}
+
+	checks["templates"] = healthCheck{OK: true}
+	if _, err := s.templates.get(s.tplFS()); err != nil {
+		checks["templates"] = healthCheck{Detail: err.Error()}
+	}
+
+	checks["shutdown"] = healthCheck{OK: !s.draining.done.Load()}
+	if s.draining.done.Load() {
+		checks["shutdown"] = healthCheck{Detail: "shutting down"}
+	}
+
+	status, resp := http.StatusOK, healthResponse{Status: "ready", Checks: checks}
+	for _, c := range checks {
+		if !c.OK {
+			status, resp.Status = http.StatusServiceUnavailable, "not ready"
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, resp)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// TestLivez checks the liveness endpoint
+func TestLivez(t *testing.T) {
+	s := newServer()
+	w := httptest.NewRecorder()
+	s.Livez(w, httptest.NewRequest(http.MethodGet, "http://example.com/livez", nil))
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("got status %d want %d", got, want)
+	}
+	if got, want := w.Body.String(), `{"status":"alive"}`+"\n"; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+}
+
+// TestReadyz checks the readiness checks are reported, and that a
+// failing check makes the server not ready
+func TestReadyz(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		setup     func(s *server)
+		failCheck string
+	}{
+		{
+			name:  "templates ok",
+			setup: func(s *server) {},
+		},
+		{
+			name: "templates broken",
+			setup: func(s *server) {
+				s.DirFS.TplFS = fstest.MapFS{"home.html": {Data: []byte("{{ .Unclosed ")}}
+			},
+			failCheck: "templates",
+		},
+		{
+			name:      "shutting down",
+			setup:     func(s *server) { s.draining.start() },
+			failCheck: "shutdown",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newServer()
+			s.DirFS = &fileSystem{}
+			s.DirFS.TplFS = os.DirFS("templates")
+			tt.setup(s)
+
+			w := httptest.NewRecorder()
+			s.Readyz(w, httptest.NewRequest(http.MethodGet, "http://example.com/readyz", nil))
+
+			var resp healthResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"stores", "upstream", "templates", "shutdown"} {
+				if _, ok := resp.Checks[name]; !ok {
+					t.Errorf("expected %s check in %s", name, w.Body.String())
+				}
+			}
+			if !resp.Checks["upstream"].OK {
+				t.Errorf("expected upstream check to pass, got %+v", resp.Checks["upstream"])
+			}
+			ready := true
+			for _, c := range resp.Checks {
+				ready = ready && c.OK
+			}
+			wantCode, wantStatus := http.StatusOK, "ready"
+			if !ready {
+				wantCode, wantStatus = http.StatusServiceUnavailable, "not ready"
+			}
+			if got, want := w.Code, wantCode; got != want {
+				t.Errorf("got status code %d want %d", got, want)
+			}
+			if got, want := resp.Status, wantStatus; got != want {
+				t.Errorf("got status %s want %s", got, want)
+			}
+			if tt.failCheck == "" {
+				if !resp.Checks["templates"].OK || !resp.Checks["shutdown"].OK {
+					t.Errorf("unexpected failing checks %s", w.Body.String())
+				}
+				return
+			}
+			if resp.Checks[tt.failCheck].OK {
+				t.Errorf("expected %s check to fail", tt.failCheck)
+			}
+			if got, want := w.Code, http.StatusServiceUnavailable; got != want {
+				t.Errorf("got status code %d want %d", got, want)
+			}
+		})
+	}
+}
+
+// TestGracefulShutdown checks the server drains and returns when it
+// receives a shutdown signal
+func TestGracefulShutdown(t *testing.T) {
+
+	cfg := defaultConfig()
+	cfg.Port = "0"
+	cfg.StoreCacheFile = ""
+	cfg.LocationCacheFile = ""
+	s := newServerWithConfig(cfg)
+	s.DirFS = &fileSystem{}
+	s.DirFS.TplFS = os.DirFS("templates")
+	s.DirFS.StaticFS = os.DirFS("static")
+
+	signalled, signal := context.WithCancel(context.Background())
+	originalNotifyContext := notifyContext
+	t.Cleanup(func() { notifyContext = originalNotifyContext })
+	notifyContext = func(parent context.Context, signals ...os.Signal) (context.Context, context.CancelFunc) {
+		return signalled, func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.serve()
+		close(done)
+	}()
+	signal()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+	if !s.draining.done.Load() {
+		t.Error("expected server to be draining")
+	}
+	if s.draining.ctx.Err() == nil {
+		t.Error("expected streams to be cancelled")
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/rorycl/cexfind"
+)
+
+// maxPricePoints is the most price changes kept for each box.
+const maxPricePoints = 50
+
+// pricePoint is the prices of a box from when it was seen in a search.
+type pricePoint struct {
+	Time          time.Time
+	Price         decimal.Decimal
+	PriceCash     decimal.Decimal
+	PriceExchange decimal.Decimal
+}
+
+// samePrices reports if the prices of p and b are the same.
+func (p pricePoint) samePrices(b cexfind.Box) bool {
+	return p.Price.Equal(b.Price) && p.PriceCash.Equal(b.PriceCash) && p.PriceExchange.Equal(b.PriceExchange)
+}
+
+// boxHistory is the price changes of a box and when it was last seen.
+type boxHistory struct {
+	points []pricePoint
+	seen   time.Time
+}
+
+// priceHistory records the prices of the boxes found by searches so
+// that price changes can be charted on the box page. Only changes are
+// recorded, up to maxPricePoints for each of at most size boxes, the
+// boxes seen least recently being forgotten first. The history is held
+// in memory and lost on restart.
+type priceHistory struct {
+	mu    sync.Mutex
+	size  int
+	boxes map[string]*boxHistory
+	now   func() time.Time
+}
+
+func newPriceHistory(size int) *priceHistory {
+	return &priceHistory{
+		size:  size,
+		boxes: map[string]*boxHistory{},
+		now:   time.Now,
+	}
+}
+
+// recordLive records the prices of boxes unless they were served from
+// the stale cache, as their prices may be older than those already
+// recorded.
+func (h *priceHistory) recordLive(boxes []cexfind.Box, stale bool) {
+	if stale {
+		return
+	}
+	h.record(boxes)
+}
+
+// record records the prices of boxes.
+func (h *priceHistory) record(boxes []cexfind.Box) {
+	if h.size < 1 || len(boxes) == 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.now()
+	for _, b := range boxes {
+		bh, ok := h.boxes[b.ID]
+		if !ok {
+			bh = &boxHistory{}
+			h.boxes[b.ID] = bh
+		}
+		bh.seen = now
+		if n := len(bh.points); n > 0 && bh.points[n-1].samePrices(b) {
+			continue
+		}
+		bh.points = append(bh.points, pricePoint{now, b.Price, b.PriceCash, b.PriceExchange})
+		if len(bh.points) > maxPricePoints {
+			bh.points = slices.Delete(bh.points, 0, len(bh.points)-maxPricePoints)
+		}
+	}
+	if len(h.boxes) <= h.size {
+		return
+	}
+	ids := make([]string, 0, len(h.boxes))
+	for id := range h.boxes {
+		ids = append(ids, id)
+	}
+	slices.SortFunc(ids, func(a, b string) int {
+		return h.boxes[a].seen.Compare(h.boxes[b].seen)
+	})
+	for _, id := range ids[:len(ids)-h.size] {
+		delete(h.boxes, id)
+	}
+}
+
+// get returns the price changes of the box with id, followed by its
+// last prices when it was last seen if that was after the last change.
+func (h *priceHistory) get(id string) []pricePoint {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	bh, ok := h.boxes[id]
+	if !ok {
+		return nil
+	}
+	points := slices.Clone(bh.points)
+	if last := points[len(points)-1]; bh.seen.After(last.Time) {
+		last.Time = bh.seen
+		points = append(points, last)
+	}
+	return points
+}
+
+// priceSince returns when b was first seen at its current prices, if
+// its history is kept.
+func (h *priceHistory) priceSince(b cexfind.Box) (time.Time, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	bh, ok := h.boxes[b.ID]
+	if !ok {
+		return time.Time{}, false
+	}
+	last := bh.points[len(bh.points)-1]
+	if !last.samePrices(b) {
+		return time.Time{}, false
+	}
+	return last.Time, true
+}
+
+// chart dimensions in pixels
+const (
+	chartWidth   = 600
+	chartHeight  = 200
+	chartPadLeft = 50
+	chartPadX    = 10
+	chartPadY    = 15
+)
+
+// chartLabel is an axis label of a price chart.
+type chartLabel struct {
+	X, Y int
+	Text string
+}
+
+// priceChart is a step chart of the price history of a box, drawn as
+// svg polylines by the box page.
+type priceChart struct {
+	Width, Height   int
+	Left, Bottom    int // the position of the axes
+	Price           string
+	PriceCash       string
+	PriceExchange   string
+	YLabels         []chartLabel
+	XLabels         []chartLabel
+	Points          []pricePoint
+	Current, Lowest decimal.Decimal
+}
+
+// newPriceChart returns a chart of the price points, or nil if there
+// are too few to chart.
+func newPriceChart(points []pricePoint) *priceChart {
+	if len(points) < 2 {
+		return nil
+	}
+	first, last := points[0].Time, points[len(points)-1].Time
+	low, high := points[0].Price, points[0].Price
+	lowest := points[0].Price
+	for _, p := range points {
+		for _, d := range []decimal.Decimal{p.Price, p.PriceCash, p.PriceExchange} {
+			low = decimal.Min(low, d)
+			high = decimal.Max(high, d)
+		}
+		lowest = decimal.Min(lowest, p.Price)
+	}
+	if low.Equal(high) {
+		low, high = low.Sub(decimal.NewFromInt(1)), high.Add(decimal.NewFromInt(1))
+	}
+
+	c := &priceChart{
+		Width:   chartWidth,
+		Height:  chartHeight,
+		Left:    chartPadLeft,
+		Bottom:  chartHeight - chartPadY,
+		Points:  points,
+		Current: points[len(points)-1].Price,
+		Lowest:  lowest,
+	}
+	span := last.Sub(first)
+	x := func(t time.Time) int {
+		if span <= 0 {
+			return chartPadLeft
+		}
+		return chartPadLeft + int(float64(chartWidth-chartPadLeft-chartPadX)*float64(t.Sub(first))/float64(span))
+	}
+	y := func(d decimal.Decimal) int {
+		f, _ := d.Sub(low).Div(high.Sub(low)).Float64()
+		return c.Bottom - int(f*float64(chartHeight-2*chartPadY))
+	}
+
+	// each price holds until the next change
+	line := func(price func(pricePoint) decimal.Decimal) string {
+		var sb strings.Builder
+		for i, p := range points {
+			if i > 0 {
+				fmt.Fprintf(&sb, " %d,%d", x(p.Time), y(price(points[i-1])))
+			}
+			fmt.Fprintf(&sb, " %d,%d", x(p.Time), y(price(p)))
+		}
+		return strings.TrimSpace(sb.String())
+	}
+	c.Price = line(func(p pricePoint) decimal.Decimal { return p.Price })
+	c.PriceCash = line(func(p pricePoint) decimal.Decimal { return p.PriceCash })
+	c.PriceExchange = line(func(p pricePoint) decimal.Decimal { return p.PriceExchange })
+
+	c.YLabels = []chartLabel{
+		{X: chartPadLeft - 5, Y: y(high), Text: "£" + high.StringFixed(0)},
+		{X: chartPadLeft - 5, Y: y(low), Text: "£" + low.StringFixed(0)},
+	}
+	dateFormat := "2 Jan"
+	if span < 48*time.Hour {
+		dateFormat = "2 Jan 15:04"
+	}
+	c.XLabels = []chartLabel{
+		{X: x(first), Y: chartHeight - 2, Text: first.Format(dateFormat)},
+		{X: x(last), Y: chartHeight - 2, Text: last.Format(dateFormat)},
+	}
+	return c
+}
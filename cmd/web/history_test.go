@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/rorycl/cexfind"
+)
+
+// TestPriceHistory tests only price changes are recorded and the boxes
+// seen least recently are forgotten
+func TestPriceHistory(t *testing.T) {
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := newPriceHistory(2)
+	h.now = func() time.Time { return now }
+
+	box := func(id string, price int64) cexfind.Box {
+		return cexfind.Box{ID: id, Price: decimal.NewFromInt(price)}
+	}
+
+	h.record([]cexfind.Box{box("a", 100), box("b", 50)})
+	now = now.Add(time.Hour)
+	h.record([]cexfind.Box{box("a", 100)})
+	now = now.Add(time.Hour)
+	h.record([]cexfind.Box{box("a", 90)})
+	now = now.Add(time.Hour)
+	h.record([]cexfind.Box{box("a", 90)})
+
+	// a change at 2 hours, then seen again at 3
+	points := h.get("a")
+	if got, want := len(points), 3; got != want {
+		t.Fatalf("got %d points want %d", got, want)
+	}
+	if got, want := points[1].Price, decimal.NewFromInt(90); !got.Equal(want) {
+		t.Errorf("got price %s want %s", got, want)
+	}
+	if got, want := points[2].Time.Sub(points[1].Time), time.Hour; got != want {
+		t.Errorf("got last seen %s after the change want %s", got, want)
+	}
+
+	// b was seen least recently
+	h.record([]cexfind.Box{box("c", 10)})
+	if h.get("b") != nil {
+		t.Error("expected b to be forgotten")
+	}
+	if h.get("a") == nil || h.get("c") == nil {
+		t.Error("expected a and c to be kept")
+	}
+
+	// stale results are not recorded
+	now = now.Add(time.Hour)
+	lastPrice := func() decimal.Decimal {
+		points := h.get("a")
+		return points[len(points)-1].Price
+	}
+	h.recordLive([]cexfind.Box{box("a", 100)}, true)
+	if got, want := lastPrice(), decimal.NewFromInt(90); !got.Equal(want) {
+		t.Errorf("got price %s after stale results want %s", got, want)
+	}
+	h.recordLive([]cexfind.Box{box("a", 100)}, false)
+	if got, want := lastPrice(), decimal.NewFromInt(100); !got.Equal(want) {
+		t.Errorf("got price %s after live results want %s", got, want)
+	}
+
+	// a zero size history keeps nothing
+	h = newPriceHistory(0)
+	h.record([]cexfind.Box{box("a", 100)})
+	if h.get("a") != nil {
+		t.Error("expected nothing to be recorded")
+	}
+}
+
+// TestPriceChart tests the step chart of a price history
+func TestPriceChart(t *testing.T) {
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	point := func(days int, price, cash, exchange int64) pricePoint {
+		return pricePoint{
+			Time:          start.Add(time.Duration(days) * 24 * time.Hour),
+			Price:         decimal.NewFromInt(price),
+			PriceCash:     decimal.NewFromInt(cash),
+			PriceExchange: decimal.NewFromInt(exchange),
+		}
+	}
+
+	if newPriceChart([]pricePoint{point(0, 100, 50, 60)}) != nil {
+		t.Error("expected no chart for a single point")
+	}
+
+	c := newPriceChart([]pricePoint{point(0, 200, 100, 120), point(10, 180, 90, 100), point(20, 180, 90, 100)})
+	if c == nil {
+		t.Fatal("expected a chart")
+	}
+	// from the left axis at £200, stepping down to £180 at 10 days
+	if got, want := c.Price, "50,15 320,15 320,46 590,46 590,46"; got != want {
+		t.Errorf("got price points %q want %q", got, want)
+	}
+	if !strings.HasSuffix(c.PriceCash, " 590,185") {
+		t.Errorf("expected the lowest price at the bottom, got %q", c.PriceCash)
+	}
+	if got, want := c.YLabels[0].Text+" "+c.YLabels[1].Text, "£200 £90"; got != want {
+		t.Errorf("got y labels %q want %q", got, want)
+	}
+	if got, want := c.XLabels[0].Text+" "+c.XLabels[1].Text, "1 Jan 21 Jan"; got != want {
+		t.Errorf("got x labels %q want %q", got, want)
+	}
+	if got, want := c.Lowest, decimal.NewFromInt(180); !got.Equal(want) {
+		t.Errorf("got lowest %s want %s", got, want)
+	}
+}
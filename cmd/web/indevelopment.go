@@ -1,4 +1,4 @@
-//go:build inDevelopment
+//go:build inDevelopment || development
 
 package main
 
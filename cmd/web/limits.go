@@ -0,0 +1,252 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The upstream Cex search is protected by CloudFlare, which may block
+// this host if it makes too many requests. Searches are therefore rate
+// limited per client, capped in the number of queries and size of
+// request, and the number of queries in flight to the upstream is
+// limited across all clients.
+
+// errTooManyQueries reports a search with more than the permitted
+// number of queries.
+var errTooManyQueries = errors.New("too many queries")
+
+// errUpstreamBusy reports that the global limit of upstream queries has
+// been reached.
+var errUpstreamBusy = errors.New("too many searches in progress, please try again shortly")
+
+// errRateLimited reports that a client has made too many requests.
+var errRateLimited = errors.New("too many requests, please try again shortly")
+
+// errBodyTooLarge reports a request body over the permitted size.
+var errBodyTooLarge = errors.New("request too large")
+
+// tokenBucket is the rate limiting state of a client.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a per client token bucket rate limiter. Each client
+// may make burst requests at once, with tokens replenished at rate per
+// second.
+type rateLimiter struct {
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+	mu      sync.Mutex
+	swept   time.Time
+	now     func() time.Time
+}
+
+// newRateLimiter returns a rateLimiter allowing rate requests per second
+// with bursts of burst requests.
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: map[string]*tokenBucket{},
+		now:     time.Now,
+	}
+}
+
+// allow reports whether a request by client is allowed and, if not,
+// how long the client should wait before retrying.
+func (rl *rateLimiter) allow(client string) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	rl.sweep(now)
+	b, ok := rl.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: rl.burst, last: now}
+		rl.buckets[client] = b
+	}
+	b.tokens = math.Min(rl.burst, b.tokens+now.Sub(b.last).Seconds()*rl.rate)
+	b.last = now
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / rl.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep removes the buckets of clients which have been idle long enough
+// to have refilled, at most once a minute.
+func (rl *rateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.swept) < time.Minute {
+		return
+	}
+	rl.swept = now
+	full := time.Duration(rl.burst / rl.rate * float64(time.Second))
+	for client, b := range rl.buckets {
+		if now.Sub(b.last) > full {
+			delete(rl.buckets, client)
+		}
+	}
+}
+
+// upstreamLimiter limits the number of queries in flight to the
+// upstream across all clients.
+type upstreamLimiter struct {
+	max   int
+	inUse int
+	mu    sync.Mutex
+}
+
+// acquire reserves n queries, reporting false if that would exceed the
+// limit. A search with more queries than the limit is allowed only
+// when no other queries are in flight.
+func (ul *upstreamLimiter) acquire(n int) bool {
+	ul.mu.Lock()
+	defer ul.mu.Unlock()
+	if ul.inUse > 0 && ul.inUse+n > ul.max {
+		return false
+	}
+	ul.inUse += n
+	return true
+}
+
+// release returns n queries reserved by acquire.
+func (ul *upstreamLimiter) release(n int) {
+	ul.mu.Lock()
+	defer ul.mu.Unlock()
+	ul.inUse -= n
+}
+
+// clientIP returns the address of the client making a request. The
+// address in the TrustedProxyHeader is only used if the request comes
+// from one of the TrustedProxies; for X-Forwarded-For style lists the
+// right-most address not itself a trusted proxy is used.
+func (s *server) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	if s.TrustedProxyHeader == "" || !s.trustedProxy(remote) {
+		return remote.Unmap().String()
+	}
+	values := r.Header.Values(s.TrustedProxyHeader)
+	addrs := strings.Split(strings.Join(values, ","), ",")
+	for i := len(addrs) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(addrs[i]))
+		if err != nil {
+			break
+		}
+		if !s.trustedProxy(addr) {
+			return addr.Unmap().String()
+		}
+	}
+	return remote.Unmap().String()
+}
+
+// trustedProxy reports if addr is one of the TrustedProxies.
+func (s *server) trustedProxy(addr netip.Addr) bool {
+	for _, p := range s.TrustedProxies {
+		if p.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimit limits the rate of requests to handler by each client.
+func (s *server) rateLimit(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.allowClient(w, r) {
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// allowClient takes a search from the client's rate limit, writing a
+// 429 response with a Retry-After header if none are available.
+func (s *server) allowClient(w http.ResponseWriter, r *http.Request) bool {
+	ok, wait := s.limiter.allow(s.clientIP(r))
+	if !ok {
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(wait.Seconds()))))
+		s.writeError(w, r, http.StatusTooManyRequests, errRateLimited)
+	}
+	return ok
+}
+
+// bodyLimitMiddleware limits the size of request bodies to
+// MaxBodyBytes.
+func (s *server) bodyLimitMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > s.MaxBodyBytes {
+			s.writeError(w, r, http.StatusRequestEntityTooLarge, errBodyTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, s.MaxBodyBytes)
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// acquireUpstream reserves n upstream queries, writing a 429 response
+// and returning false if the server is too busy. The returned func
+// releases the queries.
+func (s *server) acquireUpstream(w http.ResponseWriter, r *http.Request, n int) (func(), bool) {
+	if !s.upstream.acquire(n) {
+		w.Header().Set("Retry-After", "1")
+		s.writeError(w, r, http.StatusTooManyRequests, errUpstreamBusy)
+		return nil, false
+	}
+	return func() { s.upstream.release(n) }, true
+}
+
+// limitStatus returns the http status for request limit errors, or
+// fallback for other errors.
+func limitStatus(err error, fallback int) int {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr), errors.Is(err, errTooManyQueries):
+		return http.StatusRequestEntityTooLarge
+	}
+	return fallback
+}
+
+// writeError writes an error response suitable for the client: json
+// for the api, an html partial for htmx requests and plain text
+// otherwise.
+func (s *server) writeError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		err = errBodyTooLarge
+	}
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/api/"):
+		writeJSON(w, status, apiError{err.Error()})
+	case r.Header.Get("HX-Request") == "true":
+		buf, tErr := s.executeTemplate("partial-error.html", err)
+		if tErr != nil {
+			log.Printf("error template error: %v", tErr)
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		buf.WriteTo(w)
+	default:
+		http.Error(w, err.Error(), status)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rorycl/cexfind"
+	"github.com/rorycl/cexfind/location"
+)
+
+// TestRateLimiter tests the token bucket rate limiter
+func TestRateLimiter(t *testing.T) {
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rl := newRateLimiter(1, 2)
+	rl.now = func() time.Time { return now }
+
+	tests := []struct {
+		advance time.Duration
+		client  string
+		allowed bool
+		wait    time.Duration
+	}{
+		{0, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", false, time.Second},
+		{0, "b", true, 0},
+		{500 * time.Millisecond, "a", false, 500 * time.Millisecond},
+		{500 * time.Millisecond, "a", true, 0},
+		{10 * time.Second, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", false, time.Second},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			now = now.Add(tt.advance)
+			allowed, wait := rl.allow(tt.client)
+			if got, want := allowed, tt.allowed; got != want {
+				t.Errorf("got allowed %t want %t", got, want)
+			}
+			if got, want := wait, tt.wait; got != want {
+				t.Errorf("got wait %s want %s", got, want)
+			}
+		})
+	}
+
+	// idle clients are swept
+	now = now.Add(time.Hour)
+	rl.allow("c")
+	if got, want := len(rl.buckets), 1; got != want {
+		t.Errorf("got %d buckets after sweep want %d", got, want)
+	}
+}
+
+// TestUpstreamLimiter tests the global upstream query limit
+func TestUpstreamLimiter(t *testing.T) {
+	ul := &upstreamLimiter{max: 4}
+	if !ul.acquire(6) {
+		t.Fatal("expected large search to be allowed when idle")
+	}
+	if ul.acquire(1) {
+		t.Fatal("expected search to be refused when over limit")
+	}
+	ul.release(6)
+	if !ul.acquire(3) || !ul.acquire(1) {
+		t.Fatal("expected searches to be allowed under limit")
+	}
+	if ul.acquire(1) {
+		t.Fatal("expected search to be refused at limit")
+	}
+}
+
+// TestClientIP tests client address extraction with and without a
+// trusted proxy header
+func TestClientIP(t *testing.T) {
+
+	tests := []struct {
+		header     string
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{"", "192.0.2.1:1234", "", "192.0.2.1"},
+		{"", "127.0.0.1:1234", "198.51.100.1", "127.0.0.1"},
+		{"X-Forwarded-For", "127.0.0.1:1234", "198.51.100.1", "198.51.100.1"},
+		{"X-Forwarded-For", "127.0.0.1:1234", "203.0.113.9, 198.51.100.1", "198.51.100.1"},
+		{"X-Forwarded-For", "127.0.0.1:1234", "198.51.100.1, 127.0.0.2", "198.51.100.1"},
+		{"X-Forwarded-For", "192.0.2.1:1234", "198.51.100.1", "192.0.2.1"}, // untrusted proxy
+		{"X-Forwarded-For", "127.0.0.1:1234", "", "127.0.0.1"},
+		{"X-Forwarded-For", "127.0.0.1:1234", "junk", "127.0.0.1"},
+		{"X-Real-IP", "[::1]:1234", "2001:db8::1", "2001:db8::1"},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			s := &server{
+				TrustedProxyHeader: tt.header,
+				TrustedProxies: []netip.Prefix{
+					netip.MustParsePrefix("127.0.0.0/8"),
+					netip.MustParsePrefix("::1/128"),
+				},
+			}
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set(tt.header, tt.forwarded)
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if got, want := s.clientIP(r), tt.want; got != want {
+				t.Errorf("got %s want %s", got, want)
+			}
+		})
+	}
+}
+
+// TestLimits tests the rate, body size, query and upstream limits on
+// the search endpoints
+func TestLimits(t *testing.T) {
+
+	tests := []struct {
+		path       string
+		input      string
+		htmx       bool
+		limited    bool // exhaust the client rate limit first
+		busy       bool // fill the upstream limit first
+		statusCode int
+		contains   string
+	}{
+		{
+			path:       "/results",
+			input:      "query=abc",
+			statusCode: http.StatusOK,
+		},
+		{
+			path:       "/results",
+			input:      "query=abc",
+			htmx:       true,
+			limited:    true,
+			statusCode: http.StatusTooManyRequests,
+			contains:   `<p class="error">Error: too many requests, please try again shortly</p>`,
+		},
+		{
+			path:       "/results",
+			input:      "query=abc",
+			htmx:       true,
+			busy:       true,
+			statusCode: http.StatusTooManyRequests,
+			contains:   `too many searches in progress`,
+		},
+		{
+			path:       "/results",
+			input:      "query=abc&query=def&query=ghi&query=jkl&query=mno&query=pqr",
+			htmx:       true,
+			statusCode: http.StatusRequestEntityTooLarge,
+			contains:   `<p class="error">Error: query error: too many queries: 6 given, the maximum is 5</p>`,
+		},
+		{
+			path:       "/results",
+			input:      "query=" + strings.Repeat("a", 5000),
+			htmx:       true,
+			statusCode: http.StatusRequestEntityTooLarge,
+			contains:   `<p class="error">Error: request too large</p>`,
+		},
+		{
+			path:       "/stream",
+			input:      "query=abc&query=def&query=ghi&query=jkl&query=mno&query=pqr",
+			statusCode: http.StatusRequestEntityTooLarge,
+			contains:   "too many queries",
+		},
+		{
+			path:       "/api/v1/search",
+			input:      "query=abc",
+			limited:    true,
+			statusCode: http.StatusTooManyRequests,
+			contains:   `{"error":"too many requests, please try again shortly"}`,
+		},
+		{
+			path:       "/api/v1/search",
+			input:      "query=" + strings.Repeat("a", 5000),
+			statusCode: http.StatusRequestEntityTooLarge,
+			contains:   `{"error":"request too large"}`,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			s := newServer()
+			s.DirFS = &fileSystem{}
+			s.DirFS.TplFS = os.DirFS("templates")
+			s.searcher = func(cf *cexfind.CexFind, queries []string, strict bool, origins ...location.Origin) ([]cexfind.Box, error) {
+				return nil, cexfind.ErrNoResults
+			}
+			handlers := map[string]http.HandlerFunc{
+				"/results":       s.Results,
+				"/stream":        s.Stream,
+				"/api/v1/search": s.APISearch,
+			}
+			handler := s.bodyLimitMiddleware(s.rateLimit(handlers[tt.path]))
+
+			if tt.limited {
+				for range s.RateBurst {
+					s.limiter.allow("192.0.2.1")
+				}
+			}
+			if tt.busy {
+				s.upstream.acquire(s.MaxUpstream)
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "http://example.com"+tt.path, strings.NewReader(tt.input))
+			r.RemoteAddr = "192.0.2.1:1234"
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.htmx {
+				r.Header.Set("HX-Request", "true")
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := res.StatusCode, tt.statusCode; got != want {
+				t.Fatalf("expected status %d, got %d (%s)", want, got, body)
+			}
+			if !strings.Contains(string(body), tt.contains) {
+				t.Errorf("expected body to contain %q, got %s", tt.contains, body)
+			}
+			if tt.statusCode == http.StatusTooManyRequests && res.Header.Get("Retry-After") == "" {
+				t.Error("expected Retry-After header")
+			}
+			if got, want := s.upstream.inUse, map[bool]int{true: s.MaxUpstream}[tt.busy]; got != want {
+				t.Errorf("got %d upstream queries in use after request want %d", got, want)
+			}
+		})
+	}
+}
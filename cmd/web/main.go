@@ -4,28 +4,28 @@ package main
 import (
 	"flag"
 	"fmt"
-	"net"
 	"os"
-	"strconv"
 )
 
 var usage = `
 run a webserver to search Cex/Webuy for second hand equipment
 
 eg <programme> -address 192.168.4.5 -port 8001
+
+Settings are read from the defaults, a json -config file, environment
+variables and flags, in increasing order of precedence. Use
+-print-config to show the effective settings.
 `
 
 // indirect Exit for testing
 var Exit func(code int) = os.Exit
 
 // flagGetter indirects flagGet for testing
-var flagGetter func() (string, string) = flagGet
-
-// flagGet checks the flags
-func flagGet() (address, port string) {
+var flagGetter func() config = flagGet
 
-	flag.StringVar(&address, "address", "127.0.0.1", "server network address")
-	flag.StringVar(&port, "port", "8000", "server network port")
+// flagGet loads the config from the flags, environment and any config
+// file, printing the config and exiting if -print-config is given
+func flagGet() config {
 
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
@@ -33,31 +33,26 @@ func flagGet() (address, port string) {
 		fmt.Fprint(flag.CommandLine.Output(), usage)
 	}
 
-	flag.Parse()
-	if address == "" || port == "" {
-		flag.Usage()
-		Exit(1)
-	}
-
-	// check address and port are valid
-	a := net.ParseIP(address)
-	if a == nil {
-		fmt.Printf("address %s invalid\n", address)
-		Exit(1)
-	}
-	_, err := strconv.Atoi(port)
+	cfg, printConfig, err := loadConfig(flag.CommandLine, os.Args[1:], os.LookupEnv)
 	if err != nil {
-		fmt.Printf("port %s invalid\n", port)
+		fmt.Println(err)
 		Exit(1)
+		return cfg
 	}
-
-	return
+	if printConfig {
+		if err := cfg.write(os.Stdout); err != nil {
+			fmt.Println(err)
+			Exit(1)
+		}
+		Exit(0)
+	}
+	return cfg
 }
 
 var serveFunc func(s *server, address, port string) = (*server).Serve
 
 func main() {
-	address, port := flagGetter()
-	server := newServer()
-	serveFunc(server, address, port)
+	cfg := flagGetter()
+	server := newServerWithConfig(cfg)
+	serveFunc(server, cfg.Address, cfg.Port)
 }
@@ -63,7 +63,8 @@ func TestWebMainFlags(t *testing.T) {
 
 		os.Args = tt.args
 
-		a, p := flagGet()
+		cfg := flagGet()
+		a, p := cfg.Address, cfg.Port
 		t.Logf("subtest %d, args %v", i, tt.args)
 		if got, want := exit, tt.exitCode; got != want {
 			t.Errorf("got exit code %d expected %d", got, want)
@@ -79,10 +80,10 @@ func TestWebMainFlags(t *testing.T) {
 
 func TestWebMain(t *testing.T) {
 
-	flagGetter = func() (string, string) {
-		return "127.0.0.1", "8000"
+	flagGetter = func() config {
+		return defaultConfig()
 	}
-	if a, b := flagGetter(); a+":"+b != "127.0.0.1:8000" {
+	if cfg := flagGetter(); cfg.Address+":"+cfg.Port != "127.0.0.1:8000" {
 		t.Errorf("expected indirected flagGetter == 127.0.0.1:8000")
 	}
 	serveFunc = func(s *server, address, port string) { log.Print("got here") }
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/gorilla/mux"
+
+	"github.com/rorycl/cexfind"
+	"github.com/rorycl/cexfind/location"
+)
+
+// Metrics are served at /metrics in the Prometheus text exposition
+// format. Request metrics are recorded by middleware, query, location
+// lookup and store refresh metrics through the cexfind Observer hooks,
+// and the location cache and store status are read at each scrape.
+
+// defaultBuckets are the upper bounds, in seconds, of the latency
+// histogram buckets.
+var defaultBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// labelKey joins label values into a map key.
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// formatLabels formats the label names and values for exposition.
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, n := range names {
+		pairs[i] = fmt.Sprintf("%s=%q", n, values[i])
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// formatFloat formats a sample value.
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// counterVec is a counter partitioned by labels.
+type counterVec struct {
+	name, help string
+	labels     []string
+	mu         sync.Mutex
+	values     map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+}
+
+// inc increments the counter with the label values.
+func (c *counterVec) inc(values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[labelKey(values)]++
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, k := range slices.Sorted(maps.Keys(c.values)) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, strings.Split(k, "\xff")), formatFloat(c.values[k]))
+	}
+}
+
+// histogram is a single histogram series.
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// histogramVec is a histogram partitioned by labels.
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+	mu         sync.Mutex
+	series     map[string]*histogram
+}
+
+func newHistogramVec(name, help string, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: defaultBuckets, series: map[string]*histogram{}}
+}
+
+// observe records a duration with the label values.
+func (h *histogramVec) observe(d time.Duration, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	k := labelKey(values)
+	s, ok := h.series[k]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[k] = s
+	}
+	v := d.Seconds()
+	if i, _ := slices.BinarySearch(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	names := append(slices.Clone(h.labels), "le")
+	for _, k := range slices.Sorted(maps.Keys(h.series)) {
+		s := h.series[k]
+		values := strings.Split(k, "\xff")
+		if len(h.labels) == 0 {
+			values = nil
+		}
+		var cumulative uint64
+		for i, b := range append(slices.Clone(h.buckets), math.Inf(1)) {
+			if i < len(s.counts) {
+				cumulative += s.counts[i]
+			} else {
+				cumulative = s.count
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(names, append(slices.Clone(values), formatFloat(b))), cumulative)
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, values), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, values), s.count)
+	}
+}
+
+// writeGauge writes a single gauge or, with typ "counter", a counter
+// whose value is read from elsewhere.
+func writeGauge(w io.Writer, typ, name, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, typ, name, formatFloat(value))
+}
+
+// metrics holds the metrics recorded by the server.
+type metrics struct {
+	requests        *counterVec
+	requestDuration *histogramVec
+	queryDuration   *histogramVec
+	queryErrors     *counterVec
+	lookupDuration  *histogramVec
+	lookupErrors    *counterVec
+	storesRefreshes *counterVec
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests: newCounterVec("cexfind_http_requests_total",
+			"HTTP requests by route, method and status code.", "route", "method", "code"),
+		requestDuration: newHistogramVec("cexfind_http_request_duration_seconds",
+			"HTTP request latency by route.", "route"),
+		queryDuration: newHistogramVec("cexfind_upstream_query_duration_seconds",
+			"Latency of queries to the Cex search endpoint."),
+		queryErrors: newCounterVec("cexfind_upstream_query_errors_total",
+			"Failed queries to the Cex search endpoint by kind.", "kind"),
+		lookupDuration: newHistogramVec("cexfind_location_lookup_duration_seconds",
+			"Latency of geocoder lookups by origin kind.", "kind"),
+		lookupErrors: newCounterVec("cexfind_location_lookup_errors_total",
+			"Failed geocoder lookups by origin kind.", "kind"),
+		storesRefreshes: newCounterVec("cexfind_stores_refreshes_total",
+			"Attempts to retrieve the store locations by result.", "result"),
+	}
+}
+
+// observer returns the cexfind Observer recording query, location
+// lookup and store refresh metrics.
+func (m *metrics) observer() cexfind.Observer {
+	return cexfind.Observer{
+		Query: func(query string, d time.Duration, err error) {
+			m.queryDuration.observe(d)
+			if kind := cexfind.QueryErrorKind(err); kind != "" {
+				m.queryErrors.inc(kind)
+			}
+		},
+		Observer: location.Observer{
+			LocationLookup: func(kind location.OriginKind, d time.Duration, err error) {
+				m.lookupDuration.observe(d, kind.String())
+				if err != nil {
+					m.lookupErrors.inc(kind.String())
+				}
+			},
+			StoresRefresh: func(d time.Duration, err error) {
+				if err != nil {
+					m.storesRefreshes.inc("error")
+					return
+				}
+				m.storesRefreshes.inc("ok")
+			},
+		},
+	}
+}
+
+// middleware records the count and latency of requests by route.
+func (m *metrics) middleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unknown"
+		if cr := mux.CurrentRoute(r); cr != nil {
+			if tpl, err := cr.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+		snoop := httpsnoop.CaptureMetrics(handler, w, r)
+		m.requests.inc(route, r.Method, strconv.Itoa(snoop.Code))
+		m.requestDuration.observe(snoop.Duration, route)
+	})
+}
+
+// Metrics serves the metrics in the Prometheus text format.
+func (s *server) Metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	var b strings.Builder
+
+	m := s.metrics
+	m.requests.write(&b)
+	m.requestDuration.write(&b)
+	m.queryDuration.write(&b)
+	m.queryErrors.write(&b)
+	m.lookupDuration.write(&b)
+	m.lookupErrors.write(&b)
+	m.storesRefreshes.write(&b)
+
+	s.upstream.mu.Lock()
+	inFlight := s.upstream.inUse
+	s.upstream.mu.Unlock()
+	writeGauge(&b, "gauge", "cexfind_upstream_queries_in_flight",
+		"Queries to the Cex search endpoint in progress.", float64(inFlight))
+
+	breaker := s.cex.BreakerState()
+	fmt.Fprintf(&b, "# HELP cexfind_upstream_breaker_state State of the circuit breaker protecting Cex.\n# TYPE cexfind_upstream_breaker_state gauge\n")
+	for _, st := range []cexfind.BreakerState{cexfind.BreakerClosed, cexfind.BreakerOpen, cexfind.BreakerHalfOpen} {
+		fmt.Fprintf(&b, "cexfind_upstream_breaker_state{state=%q} %s\n", st.String(), formatFloat(boolFloat(st == breaker)))
+	}
+
+	cache := s.cex.LocationCacheStats()
+	writeGauge(&b, "gauge", "cexfind_location_cache_entries", "Entries in the location cache.", float64(cache.Size))
+	writeGauge(&b, "gauge", "cexfind_location_cache_capacity", "Capacity of the location cache.", float64(cache.Capacity))
+	writeGauge(&b, "counter", "cexfind_location_cache_hits_total", "Location cache hits.", float64(cache.Hits))
+	writeGauge(&b, "counter", "cexfind_location_cache_not_found_hits_total", "Location cache hits for locations not found.", float64(cache.NotFoundHits))
+	writeGauge(&b, "counter", "cexfind_location_cache_misses_total", "Location cache misses.", float64(cache.Misses))
+	writeGauge(&b, "counter", "cexfind_location_cache_evictions_total", "Location cache evictions.", float64(cache.Evictions))
+
+	stores := s.cex.StoresStatus()
+	writeGauge(&b, "gauge", "cexfind_stores_initialised", "Whether store distances can be calculated.", boolFloat(stores.Initialised))
+	writeGauge(&b, "gauge", "cexfind_stores_count", "Stores with known locations.", float64(stores.Count))
+	writeGauge(&b, "gauge", "cexfind_stores_age_seconds", "Age of the store location data.", stores.Age.Seconds())
+	writeGauge(&b, "gauge", "cexfind_stores_refresh_failures", "Consecutive failed attempts to retrieve the store locations.", float64(stores.Failures))
+	lastAttempt := 0.0
+	if !stores.LastAttempt.IsZero() {
+		lastAttempt = float64(stores.LastAttempt.Unix())
+	}
+	writeGauge(&b, "gauge", "cexfind_stores_last_refresh_timestamp_seconds", "Time of the last attempt to retrieve the store locations.", lastAttempt)
+	fmt.Fprintf(&b, "# HELP cexfind_stores_source Source of the store location data.\n# TYPE cexfind_stores_source gauge\n")
+	for _, src := range []location.StoresSource{location.StoresNone, location.StoresLive, location.StoresCache, location.StoresEmbedded} {
+		fmt.Fprintf(&b, "cexfind_stores_source{source=%q} %s\n", src.String(), formatFloat(boolFloat(src == stores.Source)))
+	}
+
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		log.Printf("metrics write error: %v", err)
+	}
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/rorycl/cexfind"
+	"github.com/rorycl/cexfind/location"
+)
+
+// TestMetrics checks request, query, lookup and store metrics are
+// exposed in the Prometheus text format
+func TestMetrics(t *testing.T) {
+
+	s := newServer()
+
+	r := mux.NewRouter()
+	r.HandleFunc("/health", s.Health)
+	r.HandleFunc("/teapot", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	r.HandleFunc("/metrics", s.Metrics)
+	r.Use(s.metrics.middleware)
+
+	for _, path := range []string{"/health", "/health", "/teapot"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com"+path, nil))
+	}
+
+	observer := s.metrics.observer()
+	observer.Query("abc", 300*time.Millisecond, nil)
+	observer.Query("def", 2*time.Second, cexfind.ErrBlocked)
+	observer.Query("ghi", 20*time.Millisecond, cexfind.ErrNoResultsFound)
+	observer.LocationLookup(location.OriginPostcode, 50*time.Millisecond, location.ErrLocationNotFound)
+	observer.StoresRefresh(time.Second, errors.New("no stores found"))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/metrics", nil))
+	res := w.Result()
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.Header.Get("Content-Type"), "text/plain; version=0.0.4; charset=utf-8"; got != want {
+		t.Errorf("got content type %s want %s", got, want)
+	}
+
+	for _, line := range []string{
+		"# TYPE cexfind_http_requests_total counter",
+		`cexfind_http_requests_total{route="/health",method="GET",code="200"} 2`,
+		`cexfind_http_requests_total{route="/teapot",method="GET",code="418"} 1`,
+		"# TYPE cexfind_http_request_duration_seconds histogram",
+		`cexfind_http_request_duration_seconds_count{route="/health"} 2`,
+		`cexfind_upstream_query_duration_seconds_bucket{le="0.25"} 1`,
+		`cexfind_upstream_query_duration_seconds_bucket{le="0.5"} 2`,
+		`cexfind_upstream_query_duration_seconds_bucket{le="+Inf"} 3`,
+		`cexfind_upstream_query_duration_seconds_sum 2.32`,
+		`cexfind_upstream_query_errors_total{kind="blocked"} 1`,
+		`cexfind_upstream_query_errors_total{kind="no_results"} 1`,
+		`cexfind_location_lookup_duration_seconds_bucket{kind="postcode",le="0.05"} 1`,
+		`cexfind_location_lookup_errors_total{kind="postcode"} 1`,
+		`cexfind_stores_refreshes_total{result="error"} `, // includes the refresh at startup
+		"cexfind_upstream_queries_in_flight 0",
+		`cexfind_upstream_breaker_state{state="closed"} 1`,
+		"# TYPE cexfind_location_cache_hits_total counter",
+		"cexfind_location_cache_capacity 10000",
+		"# TYPE cexfind_stores_initialised gauge",
+		`cexfind_stores_source{source="none"} `,
+	} {
+		if !strings.Contains(string(body), line) {
+			t.Errorf("expected metrics to contain %q, got\n%s", line, body)
+		}
+	}
+}
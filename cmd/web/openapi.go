@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// The OpenAPI specification is generated from the api response types
+// by reflection, so that it cannot drift from the json actually
+// served. Only the features of the type system used by the api types
+// are supported.
+
+var (
+	timeType    = reflect.TypeFor[time.Time]()
+	decimalType = reflect.TypeFor[decimal.Decimal]()
+)
+
+// schemaName is the component name for an api type, so "apiBox"
+// becomes "Box".
+func schemaName(t reflect.Type) string {
+	return strings.TrimPrefix(t.Name(), "api")
+}
+
+// schemaGenerator generates json schemas, recording the schema of each
+// named struct as a component and the first unsupported type as err.
+type schemaGenerator struct {
+	components map[string]any
+	err        error
+}
+
+// schema returns the schema for t, or a reference to the component for
+// a named struct.
+func (g *schemaGenerator) schema(t reflect.Type) map[string]any {
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case decimalType:
+		return map[string]any{"type": "string", "format": "decimal", "example": "175"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64", "minimum": 0}
+	case reflect.Float64:
+		return map[string]any{"type": "number", "format": "double"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Struct:
+		name := schemaName(t)
+		if _, ok := g.components[name]; !ok {
+			g.components[name] = nil // guard against recursion
+			g.components[name] = g.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	if g.err == nil {
+		g.err = fmt.Errorf("openapi: unsupported type %s", t)
+	}
+	return map[string]any{}
+}
+
+// object returns the object schema of struct t, flattening embedded
+// structs as encoding/json does.
+func (g *schemaGenerator) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := range t.NumField() {
+			f := t.Field(i)
+			if f.Anonymous {
+				addFields(f.Type)
+				continue
+			}
+			if !f.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			properties[name] = g.schema(f.Type)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+	return map[string]any{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+// openAPIParameter describes a query parameter.
+func openAPIParameter(name, description string, required bool, schema map[string]any) map[string]any {
+	return map[string]any{
+		"name":        name,
+		"in":          "query",
+		"description": description,
+		"required":    required,
+		"schema":      schema,
+	}
+}
+
+// response describes a json response of type t.
+func (g *schemaGenerator) response(description string, t reflect.Type) map[string]any {
+	return map[string]any{
+		"description": description,
+		"content": map[string]any{
+			"application/json": map[string]any{"schema": g.schema(t)},
+		},
+	}
+}
+
+// openAPISpec generates the OpenAPI specification of the json api,
+// returning an error if an api type can't be described.
+func openAPISpec() (map[string]any, error) {
+	g := schemaGenerator{components: map[string]any{}}
+
+	var (
+		searchType = reflect.TypeFor[apiSearchResponse]()
+		storesType = reflect.TypeFor[apiStoresResponse]()
+		healthType = reflect.TypeFor[apiHealthResponse]()
+		errorType  = reflect.TypeFor[apiError]()
+	)
+
+	stringSchema := map[string]any{"type": "string"}
+	postcode := openAPIParameter("postcode",
+		`postcode, place name or "latitude,longitude" for store distances; separate several with ";", optionally named, eg "home=S10 1LT;work=NW1 6LG"`,
+		false, stringSchema)
+	units := openAPIParameter("units", "distance units, defaulting to the server setting", false,
+		map[string]any{"type": "string", "enum": []string{"mi", "km"}})
+
+	searchParams := []any{
+		openAPIParameter("query", "search terms; several may be given, or separated by \";\"", true,
+			map[string]any{"type": "array", "items": stringSchema}),
+		openAPIParameter("strict", "only return items that strictly match the search terms", false,
+			map[string]any{"type": "boolean"}),
+		postcode,
+		units,
+	}
+	searchOperation := func(method string) map[string]any {
+		op := map[string]any{
+			"summary":     "search Cex for items for sale",
+			"operationId": method + "Search",
+			"responses": map[string]any{
+				"200": g.response("search results, with any query errors", searchType),
+				"400": g.response("invalid parameters", errorType),
+				"502": g.response("Cex could not be searched", searchType),
+			},
+		}
+		if method == "get" {
+			op["parameters"] = searchParams
+			return op
+		}
+		op["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				"application/x-www-form-urlencoded": map[string]any{
+					"schema": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"query":    map[string]any{"type": "array", "items": stringSchema},
+							"strict":   map[string]any{"type": "boolean"},
+							"postcode": stringSchema,
+							"units":    map[string]any{"type": "string", "enum": []string{"mi", "km"}},
+						},
+						"required": []string{"query"},
+					},
+				},
+			},
+		}
+		return op
+	}
+
+	base := "/api/" + apiVersion
+	spec := map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "cexfind",
+			"description": "search Cex/Webuy for second hand equipment",
+			"version":     apiVersion,
+		},
+		"paths": map[string]any{
+			base + "/search": map[string]any{
+				"get":  searchOperation("get"),
+				"post": searchOperation("post"),
+			},
+			base + "/stores": map[string]any{
+				"get": map[string]any{
+					"summary":     "list Cex stores, by distance if a postcode is given",
+					"operationId": "getStores",
+					"parameters":  []any{postcode, units},
+					"responses": map[string]any{
+						"200": g.response("stores", storesType),
+						"400": g.response("invalid parameters", errorType),
+						"502": g.response("location service failure", errorType),
+						"503": g.response("service busy", errorType),
+					},
+				},
+			},
+			base + "/health": map[string]any{
+				"get": map[string]any{
+					"summary":     "report the service status",
+					"operationId": "getHealth",
+					"responses": map[string]any{
+						"200": g.response("service status", healthType),
+					},
+				},
+			},
+		},
+		"components": map[string]any{
+			"schemas": g.components,
+		},
+	}
+	return spec, g.err
+}
+
+// openAPIJSON is the encoded OpenAPI specification, generated once.
+var openAPIJSON = sync.OnceValues(func() ([]byte, error) {
+	spec, err := openAPISpec()
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(spec, "", "  ")
+})
+
+// OpenAPI serves the OpenAPI specification of the json api.
+func (s *server) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	spec, err := openAPIJSON()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError{err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(spec)
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rorycl/cexfind"
+)
+
+// resultFormat is a format of the results of a GET /results request.
+type resultFormat struct {
+	Name      string
+	MediaType string
+}
+
+// resultFormats are the formats of the results of a GET /results
+// request; the first is the default.
+var resultFormats = []resultFormat{
+	{"html", "text/html"},
+	{"json", "application/json"},
+	{"csv", "text/csv"},
+	{"text", "text/plain"},
+	{"xlsx", xlsxMediaType},
+}
+
+// errFormat reports an unknown results format.
+var errFormat = errors.New("format error")
+
+// mediaMatch reports if the media range of an Accept header, such as
+// "text/*", matches mediaType.
+func mediaMatch(mediaRange, mediaType string) bool {
+	if mediaRange == "*/*" || mediaRange == mediaType {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(mediaRange, "*")
+	return ok && strings.HasSuffix(prefix, "/") && strings.HasPrefix(mediaType, prefix)
+}
+
+// negotiateFormat returns the results format named by the "format"
+// parameter or, if there is none, the format most preferred by the
+// Accept header, which is html if none is acceptable. Of formats
+// preferred equally the first in the Accept header is chosen.
+func negotiateFormat(r *http.Request) (resultFormat, error) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		for _, f := range resultFormats {
+			if f.Name == name {
+				return f, nil
+			}
+		}
+		return resultFormat{}, fmt.Errorf("%w: unknown format %q", errFormat, name)
+	}
+	best, bestQ := resultFormats[0], 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaRange, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		for _, f := range resultFormats {
+			if q > bestQ && mediaMatch(mediaRange, f.MediaType) {
+				best, bestQ = f, q
+			}
+		}
+	}
+	return best, nil
+}
+
+// writeFormatError writes an error response in the results format.
+func writeFormatError(w http.ResponseWriter, format resultFormat, status int, err error) {
+	if format.Name == "json" {
+		writeJSON(w, status, apiError{err.Error()})
+		return
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// ResultsPage shows the results of a search in the url query as a full
+// html page, for clients without javascript and text browsers, or as
+// json, csv, plain text or an xlsx spreadsheet, chosen by the "format"
+// parameter or the Accept header, so that one link to a search works
+// everywhere. Any sorting and filtering in the url query is applied to
+// every format. The csv and xlsx formats are downloads. Cached results
+// are used where possible.
+func (s *server) ResultsPage(w http.ResponseWriter, r *http.Request) {
+
+	w.Header().Set("Vary", "Accept")
+	format, err := negotiateFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	search, err := s.parseSearch(r.URL.Query())
+	if errors.Is(err, errNoQuery) && format.Name == "html" {
+		s.Home(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("cex results : %+v %v", search.Form, err)
+		writeFormatError(w, format, limitStatus(err, http.StatusBadRequest), err)
+		return
+	}
+	view, err := parseView(r.URL.Query())
+	if err != nil {
+		writeFormatError(w, format, http.StatusBadRequest, err)
+		return
+	}
+
+	start := time.Now()
+	key := search.urlQuery()
+	boxes, ok := s.results.get(key)
+	if !ok {
+		release, ok := s.acquireUpstream(w, r, len(search.Queries))
+		if !ok {
+			return
+		}
+		boxes, err = s.searcher(s.cex, search.Queries, search.Form.Strict, search.Origins...)
+		release()
+		setDistanceUnit(boxes, search.Unit)
+		age, stale, _ := splitStale(err)
+		s.history.recordLive(boxes, stale)
+		if len(boxes) > 0 {
+			s.results.putStale(key, boxes, age)
+		}
+	}
+	// results from the stale cache are shown with a notice rather than
+	// an error
+	searchErr := err
+	age, stale, err := splitStale(err)
+	if cachedAge, ok := s.results.staleAge(key); ok {
+		age, stale = cachedAge, true
+	}
+	status := http.StatusOK
+	noResults := errors.Is(err, cexfind.ErrNoResults) || errors.Is(err, cexfind.ErrNoResultsFound)
+	if err != nil && len(boxes) == 0 && !noResults {
+		log.Printf("cex results : %v", err)
+		status = http.StatusBadGateway
+	}
+
+	shown := []cexfind.Box{}
+	for _, g := range view.apply(boxes, search.Unit) {
+		shown = append(shown, g.Boxes...)
+	}
+
+	switch format.Name {
+	case "json":
+		resp, status := s.newAPISearchResponse(search, shown, searchErr, start)
+		if stale {
+			resp.Meta.setStale(age)
+		}
+		writeJSON(w, status, resp)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", downloadDisposition(search, "csv"))
+		w.WriteHeader(status)
+		if err := writeResultsCSV(w, shown); err != nil {
+			log.Printf("results csv write error: %v", err)
+		}
+	case "xlsx":
+		w.Header().Set("Content-Type", xlsxMediaType)
+		w.Header().Set("Content-Disposition", downloadDisposition(search, "xlsx"))
+		w.WriteHeader(status)
+		if err := writeXLSX(w, resultsRows(shown), resultsPriceColumns); err != nil {
+			log.Printf("results xlsx write error: %v", err)
+		}
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		if stale {
+			fmt.Fprintf(w, "%s\n\n", staleBanner(age))
+		}
+		writeResultsText(w, shown, err)
+	default:
+		data := s.newHomeData(r)
+		data.Results = &searchResults{Results: boxes, Err: err}
+		if len(boxes) > 0 {
+			data.Results.View = newViewData(search, view, boxes, "#result-listing")
+			data.Results.View.Map = s.newResultsMap(boxes, search.Origins, search.Unit)
+			data.Results.View.Saving = s.canSave(r)
+			if stale {
+				data.Results.View.Stale = staleBanner(age)
+			}
+		}
+		s.renderStatus(w, status, "home.html", data)
+	}
+}
+
+// resultsHeader is the header row of results in csv and xlsx.
+var resultsHeader = []string{
+	"model", "name", "category", "id", "grade", "price", "cash", "exchange",
+	"url", "nearest store", "distance", "stores",
+}
+
+// resultsPriceColumns are the columns of the prices in resultsHeader.
+var resultsPriceColumns = []int{5, 6, 7}
+
+// resultsRows returns boxes as rows of strings after a header row.
+func resultsRows(boxes []cexfind.Box) [][]string {
+	rows := [][]string{resultsHeader}
+	for _, b := range boxes {
+		nearest, distance := "", ""
+		if st, ok := b.NearestStore(); ok {
+			nearest, distance = st.StoreName, st.FormatDistance()
+		}
+		rows = append(rows, []string{
+			b.Model, b.Name, b.Category, b.ID, b.Grade(),
+			b.Price.StringFixed(2), b.PriceCash.StringFixed(2), b.PriceExchange.StringFixed(2),
+			b.IDUrl(), nearest, distance, b.StoresString(-1),
+		})
+	}
+	return rows
+}
+
+// formulaSafe returns cell prefixed with "'" if it starts with a
+// character which spreadsheet applications read as the start of a
+// formula, so that it is shown as text.
+func formulaSafe(cell string) string {
+	if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return "'" + cell
+	}
+	return cell
+}
+
+// writeResultsCSV writes boxes as csv with a header row. Text cells
+// which could be read as formulas are made safe.
+func writeResultsCSV(w io.Writer, boxes []cexfind.Box) error {
+	rows := resultsRows(boxes)
+	for _, row := range rows[1:] {
+		for c, cell := range row {
+			if !slices.Contains(resultsPriceColumns, c) {
+				row[c] = formulaSafe(cell)
+			}
+		}
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// nonFilename matches runs of characters not used in download file
+// names.
+var nonFilename = regexp.MustCompile(`[^a-z0-9]+`)
+
+// downloadDisposition returns the Content-Disposition header of a
+// download of the results of search, named after its queries, such as
+// "cexfind-lenovo-x390.csv".
+func downloadDisposition(search searchRequest, ext string) string {
+	name := strings.Trim(nonFilename.ReplaceAllString(strings.ToLower(strings.Join(search.Queries, " ")), "-"), "-")
+	if len(name) > 60 {
+		name = strings.TrimRight(name[:60], "-")
+	}
+	if name == "" {
+		name = "results"
+	}
+	return mime.FormatMediaType("attachment", map[string]string{"filename": "cexfind-" + name + "." + ext})
+}
+
+// writeResultsText writes boxes as plain text grouped by model, in the
+// manner of the cli client, followed by any search error.
+func writeResultsText(w io.Writer, boxes []cexfind.Box, err error) {
+	model := ""
+	for i, b := range boxes {
+		if i == 0 || b.Model != model {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintln(w, b.Model)
+			model = b.Model
+		}
+		fmt.Fprintf(w, "  £%s %s [%s]\n", b.Price, b.Name, b.Category)
+		fmt.Fprintf(w, "     %s\n", b.IDUrl())
+		fmt.Fprintf(w, "     cash £%s / exchange £%s\n", b.PriceCash, b.PriceExchange)
+		if stores := b.StoresString(-1); stores != "" {
+			fmt.Fprintf(w, "     %s\n", stores)
+		}
+	}
+	if err != nil {
+		if len(boxes) > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "error: %v\n", err)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rorycl/cexfind"
+	"github.com/rorycl/cexfind/location"
+)
+
+// TestNegotiateFormat tests choosing the results format
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		query  string
+		accept string
+		want   string
+	}{
+		{"", "", "html"},
+		{"", "*/*", "html"},
+		{"", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "html"},
+		{"", "application/json", "json"},
+		{"", "text/csv", "csv"},
+		{"", "text/plain", "text"},
+		{"", "text/plain, text/html", "text"},
+		{"", "text/html;q=0.5, application/json", "json"},
+		{"", "text/*;q=0.9, text/csv", "csv"},
+		{"", "image/png", "html"},
+		{"format=csv", "application/json", "csv"},
+		{"format=json", "", "json"},
+		{"format=text", "text/html", "text"},
+		{"format=xml", "", "error"},
+		{"format=xlsx", "", "xlsx"},
+		{"", xlsxMediaType, "xlsx"},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/results?"+tt.query, nil)
+			r.Header.Set("Accept", tt.accept)
+			f, err := negotiateFormat(r)
+			got := f.Name
+			if err != nil {
+				got = "error"
+			}
+			if got != tt.want {
+				t.Errorf("got %s want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestResultsPage tests GET /results in each format, from the search
+// or the results cache
+func TestResultsPage(t *testing.T) {
+
+	s := newServer()
+	s.DirFS = &fileSystem{}
+	s.DirFS.TplFS = os.DirFS("templates")
+
+	searches := 0
+	s.searcher = func(cex *cexfind.CexFind, queries []string, strict bool, origins ...location.Origin) ([]cexfind.Box, error) {
+		searches++
+		if queries[0] == "nothing" {
+			return nil, cexfind.ErrNoResultsFound
+		}
+		if queries[0] == "blocked" {
+			return nil, cexfind.ErrBlocked
+		}
+		return viewBoxes, nil
+	}
+
+	tests := []struct {
+		query       string
+		accept      string
+		statusCode  int
+		contentType string
+		contains    []string
+		searches    int
+	}{
+		{
+			query:       "query=thinkpad",
+			statusCode:  http.StatusOK,
+			contentType: "text/html",
+			contains:    []string{"<!DOCTYPE html>", `value="thinkpad"`, "showing 4 of 4 items", `action="./results"`, "x1C"},
+			searches:    1,
+		},
+		{
+			query:       "query=thinkpad&grade=B",
+			accept:      "application/json",
+			statusCode:  http.StatusOK,
+			contentType: "application/json",
+			contains:    []string{`"id":"x2B"`, `"count":2`},
+			searches:    1, // cached
+		},
+		{
+			query:       "query=thinkpad&store=1",
+			statusCode:  http.StatusOK,
+			contentType: "text/html",
+			contains:    []string{"showing 1 of 4 items", `name="store" value="1" checked /> only at store 1`},
+			searches:    1,
+		},
+		{
+			query:       "query=thinkpad&format=csv&sort=price",
+			statusCode:  http.StatusOK,
+			contentType: "text/csv",
+			contains:    []string{"model,name,category,id,grade", "t480,,Spares,t2B,B,90.00"},
+			searches:    1,
+		},
+		{
+			query:       "query=thinkpad&format=xlsx",
+			statusCode:  http.StatusOK,
+			contentType: xlsxMediaType,
+			contains:    []string{"xl/worksheets/sheet1.xml"},
+			searches:    1,
+		},
+		{
+			query:       "query=thinkpad",
+			accept:      "text/plain",
+			statusCode:  http.StatusOK,
+			contentType: "text/plain",
+			contains:    []string{"x390\n  £150  [Laptops]\n     https://uk.webuy.com/product-detail?id=x1C"},
+			searches:    1,
+		},
+		{
+			query:       "query=nothing&format=text",
+			statusCode:  http.StatusOK,
+			contentType: "text/plain",
+			contains:    []string{"error: "},
+			searches:    2,
+		},
+		{
+			query:       "query=blocked",
+			statusCode:  http.StatusBadGateway,
+			contentType: "text/html",
+			contains:    []string{"search blocked"},
+			searches:    3,
+		},
+		{
+			query:       "query=blocked&format=json",
+			statusCode:  http.StatusBadGateway,
+			contentType: "application/json",
+			contains:    []string{`"error":"search blocked"`},
+			searches:    4,
+		},
+		{
+			query:       "",
+			statusCode:  http.StatusOK,
+			contentType: "text/html",
+			contains:    []string{`<form id="trip"`},
+			searches:    4,
+		},
+		{
+			query:       "format=json",
+			statusCode:  http.StatusBadRequest,
+			contentType: "application/json",
+			searches:    4,
+		},
+		{
+			query:      "query=thinkpad&format=xml",
+			statusCode: http.StatusBadRequest,
+			searches:   4,
+		},
+		{
+			query:      "query=thinkpad&sort=colour&format=csv",
+			statusCode: http.StatusBadRequest,
+			searches:   4,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/results?"+tt.query, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			s.Results(w, r)
+
+			if got, want := w.Code, tt.statusCode; got != want {
+				t.Fatalf("got status %d want %d: %s", got, want, w.Body.String())
+			}
+			if !strings.HasPrefix(w.Header().Get("Content-Type"), tt.contentType) {
+				t.Errorf("got content type %s want %s", w.Header().Get("Content-Type"), tt.contentType)
+			}
+			if got, want := w.Header().Get("Vary"), "Accept"; got != want {
+				t.Errorf("got vary %q want %q", got, want)
+			}
+			for _, c := range tt.contains {
+				if !strings.Contains(w.Body.String(), c) {
+					t.Errorf("expected body to contain %q, got\n%s", c, w.Body.String())
+				}
+			}
+			if got, want := searches, tt.searches; got != want {
+				t.Errorf("got %d searches want %d", got, want)
+			}
+		})
+	}
+}
+
+// TestWriteResultsCSV tests the csv is well formed
+func TestWriteResultsCSV(t *testing.T) {
+	var sb strings.Builder
+	if err := writeResultsCSV(&sb, viewBoxes); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(strings.NewReader(sb.String())).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(records), len(viewBoxes)+1; got != want {
+		t.Fatalf("got %d records want %d", got, want)
+	}
+	for _, rec := range records {
+		if got, want := len(rec), len(resultsHeader); got != want {
+			t.Errorf("got %d fields want %d", got, want)
+		}
+	}
+	var js strings.Builder
+	json.NewEncoder(&js).Encode(records[1])
+	if got, want := strings.TrimSpace(js.String()), `["x390","","Laptops","x1C","C","150.00","0.00","0.00","https://uk.webuy.com/product-detail?id=x1C","","2.0mi"," (2.0mi)"]`; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+}
+
+// TestFormulaSafe tests csv cells which could be read as formulas are
+// prefixed
+func TestFormulaSafe(t *testing.T) {
+	tests := []struct {
+		cell, want string
+	}{
+		{"", ""},
+		{"Lenovo X390", "Lenovo X390"},
+		{"=1+2", "'=1+2"},
+		{"+44 20", "'+44 20"},
+		{"-cmd", "'-cmd"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\tx", "'\tx"},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			if got := formulaSafe(tt.cell); got != tt.want {
+				t.Errorf("got %q want %q", got, tt.want)
+			}
+		})
+	}
+
+	boxes := []cexfind.Box{{Model: "x390", Name: "=HYPERLINK(\"http://example.com\")", ID: "x1"}}
+	var sb strings.Builder
+	if err := writeResultsCSV(&sb, boxes); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sb.String(), `'=HYPERLINK(""http://example.com"")`) {
+		t.Errorf("expected the name to be prefixed, got %s", sb.String())
+	}
+}
+
+// TestDownloadDisposition tests download file names
+func TestDownloadDisposition(t *testing.T) {
+	tests := []struct {
+		queries []string
+		ext     string
+		want    string
+	}{
+		{[]string{"lenovo x390"}, "csv", `attachment; filename=cexfind-lenovo-x390.csv`},
+		{[]string{"Lenovo X390", "t480/i5"}, "xlsx", `attachment; filename=cexfind-lenovo-x390-t480-i5.xlsx`},
+		{[]string{"\"£?"}, "csv", `attachment; filename=cexfind-results.csv`},
+		{[]string{strings.Repeat("ab ", 30)}, "csv", `attachment; filename=cexfind-` + strings.TrimSuffix(strings.Repeat("ab-", 20), "-") + `.csv`},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			if got := downloadDisposition(searchRequest{Queries: tt.queries}, tt.ext); got != tt.want {
+				t.Errorf("got %s want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,530 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
+	bolt "go.etcd.io/bbolt"
+)
+
+// savedNamePattern is the route pattern of a saved search name.
+const savedNamePattern = "[a-z0-9][a-z0-9-]{0,39}"
+
+// validSavedName matches a saved search name.
+var validSavedName = regexp.MustCompile("^" + savedNamePattern + "$")
+
+// saved search errors
+var (
+	errSavedDisabled = errors.New("saved searches are not enabled")
+	errSavedNotFound = errors.New("saved search not found")
+	errSavedExists   = errors.New("a saved search with that name already exists")
+	errSavedName     = errors.New("saved search names are 1 to 40 lower case letters, digits or hyphens, other than login")
+	errSavedLimit    = errors.New("too many saved searches")
+	errSavedOperator = errors.New("saved searches may only be changed by the operator")
+	errAlertRules    = errors.New("alert rules error")
+)
+
+// savedCookie is the cookie of an operator signed in to change saved
+// searches.
+const savedCookie = "cexfind-saved"
+
+// alertRules are the rules for alerts of a saved search. Items found by
+// the search which newly match the rules are notified on the channels,
+// or on every channel if none are chosen. An item matches if it is
+// priced under PriceUnder and held within Within of an origin of the
+// search, where these are set; with NewItems only items not found by
+// the search before are notified, otherwise items also match when
+// their price falls under PriceUnder.
+type alertRules struct {
+	NewItems   bool     `json:"newItems,omitempty"`
+	PriceUnder string   `json:"priceUnder,omitempty"`
+	Within     float64  `json:"within,omitempty"`
+	Channels   []string `json:"channels,omitempty"`
+}
+
+// equal reports if the rules are the same as b.
+func (a alertRules) equal(b alertRules) bool {
+	return a.NewItems == b.NewItems && a.PriceUnder == b.PriceUnder && a.Within == b.Within && slices.Equal(a.Channels, b.Channels)
+}
+
+// enabled reports if any alert rule is set.
+func (a alertRules) enabled() bool {
+	return a.NewItems || a.PriceUnder != "" || a.Within > 0
+}
+
+// savedSearch is a search, with its view, saved under a short name.
+// Seen and Matched are the ids of the items found and matching the
+// alert rules when the search was last checked for alerts. Attempted
+// is the time of the last check, even if it failed.
+type savedSearch struct {
+	Name      string     `json:"name"`
+	Query     string     `json:"query"`
+	Created   time.Time  `json:"created"`
+	Alerts    alertRules `json:"alerts"`
+	Attempted time.Time  `json:"attempted,omitzero"`
+	Checked   time.Time  `json:"checked,omitzero"`
+	Alerted   time.Time  `json:"alerted,omitzero"`
+	LastError string     `json:"lastError,omitempty"`
+	Seen      []string   `json:"seen,omitempty"`
+	Matched   []string   `json:"matched,omitempty"`
+}
+
+// savedBucket is the bolt bucket of saved searches, keyed by name.
+var savedBucket = []byte("searches")
+
+// savedStore keeps saved searches in a local bolt database.
+type savedStore struct {
+	db *bolt.DB
+}
+
+// openSavedStore opens, or creates, the saved search database at path.
+func openSavedStore(path string) (*savedStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("saved search database error: %w", err)
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("saved search database %s error: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(savedBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("saved search database %s error: %w", path, err)
+	}
+	return &savedStore{db: db}, nil
+}
+
+// close closes the database.
+func (st *savedStore) close() error {
+	return st.db.Close()
+}
+
+// create saves ss, unless a search with its name is already saved or,
+// if max is more than 0, max searches are saved.
+func (st *savedStore) create(ss savedSearch, max int) error {
+	return st.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(savedBucket)
+		if b.Get([]byte(ss.Name)) != nil {
+			return errSavedExists
+		}
+		if max > 0 && b.Stats().KeyN >= max {
+			return fmt.Errorf("%w: the maximum is %d", errSavedLimit, max)
+		}
+		return putSaved(b, ss)
+	})
+}
+
+// putSaved writes ss to the bucket.
+func putSaved(b *bolt.Bucket, ss savedSearch) error {
+	v, err := json.Marshal(ss)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(ss.Name), v)
+}
+
+// getSaved reads the saved search called name from the bucket.
+func getSaved(b *bolt.Bucket, name string) (savedSearch, error) {
+	var ss savedSearch
+	v := b.Get([]byte(name))
+	if v == nil {
+		return ss, errSavedNotFound
+	}
+	err := json.Unmarshal(v, &ss)
+	return ss, err
+}
+
+// get returns the saved search called name.
+func (st *savedStore) get(name string) (savedSearch, error) {
+	var ss savedSearch
+	err := st.db.View(func(tx *bolt.Tx) error {
+		var err error
+		ss, err = getSaved(tx.Bucket(savedBucket), name)
+		return err
+	})
+	return ss, err
+}
+
+// list returns the saved searches, by name.
+func (st *savedStore) list() ([]savedSearch, error) {
+	searches := []savedSearch{}
+	err := st.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(savedBucket).ForEach(func(k, v []byte) error {
+			var ss savedSearch
+			if err := json.Unmarshal(v, &ss); err != nil {
+				return fmt.Errorf("saved search %s error: %w", k, err)
+			}
+			searches = append(searches, ss)
+			return nil
+		})
+	})
+	return searches, err
+}
+
+// update changes the saved search called name with fn.
+func (st *savedStore) update(name string, fn func(ss *savedSearch) error) error {
+	return st.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(savedBucket)
+		ss, err := getSaved(b, name)
+		if err != nil {
+			return err
+		}
+		if err := fn(&ss); err != nil {
+			return err
+		}
+		return putSaved(b, ss)
+	})
+}
+
+// delete deletes the saved search called name.
+func (st *savedStore) delete(name string) error {
+	return st.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(savedBucket)
+		if b.Get([]byte(name)) == nil {
+			return errSavedNotFound
+		}
+		return b.Delete([]byte(name))
+	})
+}
+
+// savedStatus returns the http status for saved search errors.
+func savedStatus(err error) int {
+	switch {
+	case errors.Is(err, errSavedDisabled), errors.Is(err, errSavedNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errSavedExists):
+		return http.StatusConflict
+	case errors.Is(err, errSavedLimit), errors.Is(err, errSavedOperator):
+		return http.StatusForbidden
+	case errors.Is(err, errSavedName), errors.Is(err, errAlertRules), errors.Is(err, errView):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// savedCookieValue returns the value of the operator's cookie, which is
+// derived from, rather than, the saved search token.
+func (s *server) savedCookieValue() string {
+	sum := sha256.Sum256([]byte("cexfind saved searches:" + s.savedToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// operator reports if r is from the operator, given the saved search
+// token as a bearer token or with the cookie set by signing in.
+func (s *server) operator(r *http.Request) bool {
+	if s.savedToken == "" {
+		return false
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return subtle.ConstantTimeCompare([]byte(token), []byte(s.savedToken)) == 1
+	}
+	c, err := r.Cookie(savedCookie)
+	return err == nil && subtle.ConstantTimeCompare([]byte(c.Value), []byte(s.savedCookieValue())) == 1
+}
+
+// canSave reports if saved searches are enabled and r is from the
+// operator, who may save searches.
+func (s *server) canSave(r *http.Request) bool {
+	return s.saved != nil && s.operator(r)
+}
+
+// sameOrigin reports if the Origin header of r, if sent, is the server,
+// so that forms posted from other sites are refused.
+func (s *server) sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if base, err := url.Parse(s.BaseURL); err == nil && base.Host != "" && u.Host == base.Host {
+		return true
+	}
+	return u.Host == r.Host
+}
+
+// operatorOnly restricts handler to the operator. Others are redirected
+// to sign in if they are getting a page and otherwise refused, as are
+// requests changing saved searches from other sites.
+func (s *server) operatorOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case s.saved == nil:
+			http.Error(w, errSavedDisabled.Error(), savedStatus(errSavedDisabled))
+		case r.Method != http.MethodGet && !s.sameOrigin(r):
+			http.Error(w, errSavedOperator.Error(), savedStatus(errSavedOperator))
+		case s.operator(r):
+			handler(w, r)
+		case r.Method == http.MethodGet:
+			http.Redirect(w, r, s.BaseURL+"/s/login", http.StatusSeeOther)
+		default:
+			http.Error(w, errSavedOperator.Error(), savedStatus(errSavedOperator))
+		}
+	}
+}
+
+// SavedLogin shows the operator's sign in form and, on POST, checks the
+// "token" parameter is the saved search token, setting the operator's
+// cookie and redirecting to the saved searches.
+func (s *server) SavedLogin(w http.ResponseWriter, r *http.Request) {
+
+	if s.saved == nil {
+		http.Error(w, errSavedDisabled.Error(), savedStatus(errSavedDisabled))
+		return
+	}
+	if r.Method != http.MethodPost {
+		s.render(w, "saved-login.html", struct{ Title string }{"sign in"})
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), limitStatus(err, http.StatusBadRequest))
+		return
+	}
+	token := r.PostForm.Get("token")
+	if !s.sameOrigin(r) || subtle.ConstantTimeCompare([]byte(token), []byte(s.savedToken)) != 1 {
+		http.Error(w, errSavedOperator.Error(), savedStatus(errSavedOperator))
+		return
+	}
+	path := "/"
+	if base, err := url.Parse(s.BaseURL); err == nil && base.Path != "" {
+		path = base.Path
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     savedCookie,
+		Value:    s.savedCookieValue(),
+		Path:     path,
+		MaxAge:   int((30 * 24 * time.Hour).Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || strings.HasPrefix(s.BaseURL, "https:"),
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.Redirect(w, r, s.BaseURL+"/s", http.StatusSeeOther)
+}
+
+// savedQuery returns the search and view url query of vals, checking
+// that it is a valid search.
+func (s *server) savedQuery(vals url.Values) (string, error) {
+	search, err := s.parseSearch(vals)
+	if err != nil {
+		return "", err
+	}
+	view, err := parseView(vals)
+	if err != nil {
+		return "", err
+	}
+	return search.urlQuery() + view.urlQuery(), nil
+}
+
+// parseAlertRules returns the alert rules of the settings form, checking
+// that the channels are configured.
+func (s *server) parseAlertRules(form url.Values) (alertRules, error) {
+	a := alertRules{
+		NewItems:   form.Get("new") == "true",
+		PriceUnder: form.Get("under"),
+		Channels:   form["channel"],
+	}
+	if a.PriceUnder != "" {
+		price, err := decimal.NewFromString(a.PriceUnder)
+		if err != nil || !price.IsPositive() {
+			return a, fmt.Errorf("%w: price %q invalid", errAlertRules, a.PriceUnder)
+		}
+	}
+	if within := form.Get("within"); within != "" {
+		var err error
+		if a.Within, err = strconv.ParseFloat(within, 64); err != nil || a.Within < 0 || math.IsNaN(a.Within) {
+			return a, fmt.Errorf("%w: distance %q invalid", errAlertRules, within)
+		}
+	}
+	for _, c := range a.Channels {
+		if _, ok := s.notifiers[c]; !ok {
+			return a, fmt.Errorf("%w: unknown channel %q", errAlertRules, c)
+		}
+	}
+	return a, nil
+}
+
+// SaveSearch saves the search and view in the "query" parameter under
+// the "name" parameter, and redirects to its settings page.
+func (s *server) SaveSearch(w http.ResponseWriter, r *http.Request) {
+
+	if s.saved == nil {
+		http.Error(w, errSavedDisabled.Error(), savedStatus(errSavedDisabled))
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), limitStatus(err, http.StatusBadRequest))
+		return
+	}
+	name := r.PostForm.Get("name")
+	if !validSavedName.MatchString(name) || name == "login" {
+		http.Error(w, errSavedName.Error(), savedStatus(errSavedName))
+		return
+	}
+	vals, err := url.ParseQuery(r.PostForm.Get("query"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query, err := s.savedQuery(vals)
+	if err != nil {
+		http.Error(w, err.Error(), limitStatus(err, http.StatusBadRequest))
+		return
+	}
+	err = s.saved.create(savedSearch{Name: name, Query: query, Created: time.Now()}, s.MaxSavedSearches)
+	if err != nil {
+		log.Printf("saved search %s error: %v", name, err)
+		http.Error(w, err.Error(), savedStatus(err))
+		return
+	}
+	http.Redirect(w, r, s.BaseURL+"/s/"+name+"/settings", http.StatusSeeOther)
+}
+
+// SavedSearch redirects to the results page of the saved search named
+// in the path.
+func (s *server) SavedSearch(w http.ResponseWriter, r *http.Request) {
+
+	if s.saved == nil {
+		http.Error(w, errSavedDisabled.Error(), savedStatus(errSavedDisabled))
+		return
+	}
+	ss, err := s.saved.get(mux.Vars(r)["name"])
+	if err != nil {
+		http.Error(w, err.Error(), savedStatus(err))
+		return
+	}
+	http.Redirect(w, r, s.BaseURL+"/results?"+ss.Query, http.StatusFound)
+}
+
+// savedItem is a saved search shown by the saved search pages.
+type savedItem struct {
+	savedSearch
+	Search string // the queries of the search
+	Unit   string // the distance unit of the search
+}
+
+// newSavedItem returns the saved search for display.
+func (s *server) newSavedItem(ss savedSearch) savedItem {
+	item := savedItem{savedSearch: ss, Unit: s.cex.DistanceUnit().String()}
+	vals, _ := url.ParseQuery(ss.Query)
+	if search, err := s.parseSearch(vals); err == nil {
+		item.Search = strings.Join(search.Queries, ", ")
+		item.Unit = search.Unit.String()
+	}
+	return item
+}
+
+// SavedSearches lists the saved searches.
+func (s *server) SavedSearches(w http.ResponseWriter, r *http.Request) {
+
+	if s.saved == nil {
+		http.Error(w, errSavedDisabled.Error(), savedStatus(errSavedDisabled))
+		return
+	}
+	searches, err := s.saved.list()
+	if err != nil {
+		log.Printf("saved searches error: %v", err)
+		http.Error(w, err.Error(), savedStatus(err))
+		return
+	}
+	items := make([]savedItem, len(searches))
+	for i, ss := range searches {
+		items[i] = s.newSavedItem(ss)
+	}
+	s.render(w, "saved.html", struct {
+		Title    string
+		Searches []savedItem
+	}{"saved searches", items})
+}
+
+// SavedSettings shows and, on POST, updates the alert rules of the saved
+// search named in the path.
+func (s *server) SavedSettings(w http.ResponseWriter, r *http.Request) {
+
+	if s.saved == nil {
+		http.Error(w, errSavedDisabled.Error(), savedStatus(errSavedDisabled))
+		return
+	}
+	name := mux.Vars(r)["name"]
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), limitStatus(err, http.StatusBadRequest))
+			return
+		}
+		rules, err := s.parseAlertRules(r.PostForm)
+		if err == nil {
+			err = s.saved.update(name, func(ss *savedSearch) error {
+				// items matching the new rules are notified from the
+				// next check
+				ss.Alerts, ss.Checked, ss.Matched = rules, time.Time{}, nil
+				return nil
+			})
+		}
+		if err != nil {
+			http.Error(w, err.Error(), savedStatus(err))
+			return
+		}
+		http.Redirect(w, r, s.BaseURL+"/s/"+name+"/settings", http.StatusSeeOther)
+		return
+	}
+
+	ss, err := s.saved.get(name)
+	if err != nil {
+		http.Error(w, err.Error(), savedStatus(err))
+		return
+	}
+	channels := s.channelNames()
+	type channel struct {
+		Name    string
+		Checked bool
+	}
+	data := struct {
+		Title         string
+		Search        savedItem
+		Channels      []channel
+		AlertInterval time.Duration
+	}{
+		Title:         ss.Name,
+		Search:        s.newSavedItem(ss),
+		AlertInterval: s.AlertInterval,
+	}
+	for _, c := range channels {
+		data.Channels = append(data.Channels, channel{c, slices.Contains(ss.Alerts.Channels, c)})
+	}
+	s.render(w, "saved-settings.html", data)
+}
+
+// DeleteSaved deletes the saved search named in the path.
+func (s *server) DeleteSaved(w http.ResponseWriter, r *http.Request) {
+
+	if s.saved == nil {
+		http.Error(w, errSavedDisabled.Error(), savedStatus(errSavedDisabled))
+		return
+	}
+	if err := s.saved.delete(mux.Vars(r)["name"]); err != nil {
+		http.Error(w, err.Error(), savedStatus(err))
+		return
+	}
+	http.Redirect(w, r, s.BaseURL+"/s", http.StatusSeeOther)
+}
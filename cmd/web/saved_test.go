@@ -0,0 +1,386 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// newSavedServer returns a test server with saved searches in a
+// temporary database
+func newSavedServer(t *testing.T) *server {
+	t.Helper()
+	s := newServer()
+	s.DirFS = &fileSystem{}
+	s.DirFS.TplFS = os.DirFS("templates")
+	saved, err := openSavedStore(filepath.Join(t.TempDir(), "cexfind", "saved.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { saved.close() })
+	s.saved = saved
+	s.savedToken = "secret"
+	return s
+}
+
+// signIn adds the cookie of the operator of s to r.
+func signIn(s *server, r *http.Request) *http.Request {
+	r.AddCookie(&http.Cookie{Name: savedCookie, Value: s.savedCookieValue()})
+	return r
+}
+
+// TestSavedStore tests saving, listing, updating and deleting searches,
+// which persist when the database is reopened
+func TestSavedStore(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "saved.db")
+	st, err := openSavedStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"x390", "thinkpads"} {
+		if err := st.create(savedSearch{Name: name, Query: "query=" + name}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.create(savedSearch{Name: "x390"}, 2); !errors.Is(err, errSavedExists) {
+		t.Errorf("got %v want %v", err, errSavedExists)
+	}
+	if err := st.create(savedSearch{Name: "t480"}, 2); !errors.Is(err, errSavedLimit) {
+		t.Errorf("got %v want %v", err, errSavedLimit)
+	}
+	err = st.update("x390", func(ss *savedSearch) error {
+		ss.Alerts.NewItems = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.update("t480", func(ss *savedSearch) error { return nil }); !errors.Is(err, errSavedNotFound) {
+		t.Errorf("got %v want %v", err, errSavedNotFound)
+	}
+	if err := st.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err = openSavedStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.close()
+	searches, err := st.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, ss := range searches {
+		names = append(names, ss.Name)
+	}
+	if got, want := strings.Join(names, ","), "thinkpads,x390"; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+	ss, err := st.get("x390")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ss.Alerts.NewItems || ss.Query != "query=x390" {
+		t.Errorf("unexpected saved search %+v", ss)
+	}
+	if err := st.delete("x390"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.get("x390"); !errors.Is(err, errSavedNotFound) {
+		t.Errorf("got %v want %v", err, errSavedNotFound)
+	}
+	if err := st.delete("x390"); !errors.Is(err, errSavedNotFound) {
+		t.Errorf("got %v want %v", err, errSavedNotFound)
+	}
+}
+
+// TestParseAlertRules tests the alert rules form is checked
+func TestParseAlertRules(t *testing.T) {
+	s := newServer()
+	tests := []struct {
+		form string
+		want string
+	}{
+		{"new=true&under=150&within=10&channel=log", "{NewItems:true PriceUnder:150 Within:10 Channels:[log]}"},
+		{"under=99.50", "{NewItems:false PriceUnder:99.50 Within:0 Channels:[]}"},
+		{"under=-1", "error"},
+		{"under=cheap", "error"},
+		{"within=-5", "error"},
+		{"within=NaN", "error"},
+		{"channel=pigeon", "error"},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			form, _ := url.ParseQuery(tt.form)
+			a, err := s.parseAlertRules(form)
+			got := strings.Replace(fmt.Sprintf("%+v", a), "Channels:[]]", "Channels:[]", 1)
+			if err != nil {
+				got = "error"
+			}
+			if got != tt.want {
+				t.Errorf("got %s want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSavedHandlers tests saving a search from the results, following
+// its short link, changing its alert rules and deleting it
+func TestSavedHandlers(t *testing.T) {
+
+	s := newSavedServer(t)
+
+	tests := []struct {
+		method     string
+		path       string
+		name       string
+		body       string
+		handler    http.HandlerFunc
+		statusCode int
+		location   string
+		contains   []string
+	}{
+		{
+			method:     http.MethodPost,
+			path:       "/s",
+			body:       "name=thinkpads&query=" + url.QueryEscape("query=thinkpad&grade=B&sort=price"),
+			handler:    s.SaveSearch,
+			statusCode: http.StatusSeeOther,
+			location:   "/s/thinkpads/settings",
+		},
+		{
+			method:     http.MethodPost,
+			path:       "/s",
+			body:       "name=thinkpads&query=query%3Dx390",
+			handler:    s.SaveSearch,
+			statusCode: http.StatusConflict,
+		},
+		{
+			method:     http.MethodPost,
+			path:       "/s",
+			body:       "name=Think+Pads&query=query%3Dx390",
+			handler:    s.SaveSearch,
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			method:     http.MethodPost,
+			path:       "/s",
+			body:       "name=x390&query=query%3Dx",
+			handler:    s.SaveSearch,
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			method:     http.MethodGet,
+			path:       "/s/thinkpads",
+			name:       "thinkpads",
+			handler:    s.SavedSearch,
+			statusCode: http.StatusFound,
+			location:   "/results?strict=false&query=thinkpad&grade=B&sort=price",
+		},
+		{
+			method:     http.MethodGet,
+			path:       "/s/t480",
+			name:       "t480",
+			handler:    s.SavedSearch,
+			statusCode: http.StatusNotFound,
+		},
+		{
+			method:     http.MethodPost,
+			path:       "/s/thinkpads/settings",
+			name:       "thinkpads",
+			body:       "new=true&under=200&channel=log",
+			handler:    s.SavedSettings,
+			statusCode: http.StatusSeeOther,
+			location:   "/s/thinkpads/settings",
+		},
+		{
+			method:     http.MethodPost,
+			path:       "/s/thinkpads/settings",
+			name:       "thinkpads",
+			body:       "under=free",
+			handler:    s.SavedSettings,
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			method:     http.MethodGet,
+			path:       "/s/thinkpads/settings",
+			name:       "thinkpads",
+			handler:    s.SavedSettings,
+			statusCode: http.StatusOK,
+			contains:   []string{`<base href="../../">`, "<h2>thinkpads</h2>", `name="new" value="true" checked`, `value="200"`, `value="log" checked`},
+		},
+		{
+			method:     http.MethodGet,
+			path:       "/s",
+			handler:    s.SavedSearches,
+			statusCode: http.StatusOK,
+			contains:   []string{`<a href="./s/thinkpads">thinkpads</a>`, "<td>thinkpad</td>", "new items under &pound;200"},
+		},
+		{
+			method:     http.MethodPost,
+			path:       "/s/thinkpads/delete",
+			name:       "thinkpads",
+			handler:    s.DeleteSaved,
+			statusCode: http.StatusSeeOther,
+			location:   "/s",
+		},
+		{
+			method:     http.MethodGet,
+			path:       "/s",
+			handler:    s.SavedSearches,
+			statusCode: http.StatusOK,
+			contains:   []string{"no searches have been saved"},
+		},
+		{
+			method:     http.MethodPost,
+			path:       "/s/thinkpads/delete",
+			name:       "thinkpads",
+			handler:    s.DeleteSaved,
+			statusCode: http.StatusNotFound,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "http://example.com"+tt.path, strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.name != "" {
+				r = mux.SetURLVars(r, map[string]string{"name": tt.name})
+			}
+			w := httptest.NewRecorder()
+			tt.handler(w, r)
+
+			if got, want := w.Code, tt.statusCode; got != want {
+				t.Fatalf("got status %d want %d: %s", got, want, w.Body.String())
+			}
+			if got, want := w.Header().Get("Location"), tt.location; got != want {
+				t.Errorf("got location %q want %q", got, want)
+			}
+			for _, c := range tt.contains {
+				if !strings.Contains(w.Body.String(), c) {
+					t.Errorf("expected body to contain %q, got\n%s", c, w.Body.String())
+				}
+			}
+		})
+	}
+}
+
+// TestSavedOperator tests only the operator, signed in with the token,
+// may change saved searches, and not from other sites
+func TestSavedOperator(t *testing.T) {
+
+	s := newSavedServer(t)
+	saveBody := "name=x390&query=query%3Dx390"
+
+	tests := []struct {
+		method     string
+		path       string
+		body       string
+		handler    http.HandlerFunc
+		signedIn   bool
+		header     [2]string
+		statusCode int
+		location   string
+	}{
+		{http.MethodGet, "/s", "", s.operatorOnly(s.SavedSearches), false, [2]string{}, http.StatusSeeOther, "/s/login"},
+		{http.MethodGet, "/s", "", s.operatorOnly(s.SavedSearches), true, [2]string{}, http.StatusOK, ""},
+		{http.MethodPost, "/s", saveBody, s.operatorOnly(s.SaveSearch), false, [2]string{}, http.StatusForbidden, ""},
+		{http.MethodPost, "/s", saveBody, s.operatorOnly(s.SaveSearch), false, [2]string{"Authorization", "Bearer guess"}, http.StatusForbidden, ""},
+		{http.MethodPost, "/s", saveBody, s.operatorOnly(s.SaveSearch), true, [2]string{"Origin", "https://evil.example.net"}, http.StatusForbidden, ""},
+		{http.MethodPost, "/s", saveBody, s.operatorOnly(s.SaveSearch), true, [2]string{"Origin", "http://example.com"}, http.StatusSeeOther, "/s/x390/settings"},
+		{http.MethodPost, "/s", "name=login&query=query%3Dx390", s.operatorOnly(s.SaveSearch), false, [2]string{"Authorization", "Bearer secret"}, http.StatusBadRequest, ""},
+		{http.MethodGet, "/s/login", "", s.SavedLogin, false, [2]string{}, http.StatusOK, ""},
+		{http.MethodPost, "/s/login", "token=guess", s.SavedLogin, false, [2]string{}, http.StatusForbidden, ""},
+		{http.MethodPost, "/s/login", "token=secret", s.SavedLogin, false, [2]string{"Origin", "https://evil.example.net"}, http.StatusForbidden, ""},
+		{http.MethodPost, "/s/login", "token=secret", s.SavedLogin, false, [2]string{}, http.StatusSeeOther, "/s"},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "http://example.com"+tt.path, strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.header[0] != "" {
+				r.Header.Set(tt.header[0], tt.header[1])
+			}
+			if tt.signedIn {
+				r = signIn(s, r)
+			}
+			w := httptest.NewRecorder()
+			tt.handler(w, r)
+			if got, want := w.Code, tt.statusCode; got != want {
+				t.Fatalf("got status %d want %d: %s", got, want, w.Body.String())
+			}
+			if got, want := w.Header().Get("Location"), tt.location; got != want {
+				t.Errorf("got location %q want %q", got, want)
+			}
+		})
+	}
+
+	// signing in sets a strict, http only cookie for the operator
+	r := httptest.NewRequest(http.MethodPost, "http://example.com/s/login", strings.NewReader("token=secret"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	s.SavedLogin(w, r)
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteStrictMode || cookies[0].Value == "secret" {
+		t.Fatalf("unexpected cookies %v", cookies)
+	}
+	r = httptest.NewRequest(http.MethodGet, "http://example.com/view", nil)
+	if s.canSave(r) {
+		t.Error("expected others to not be able to save")
+	}
+	r.AddCookie(cookies[0])
+	if !s.canSave(r) {
+		t.Error("expected the operator to be able to save")
+	}
+}
+
+// TestSavedDisabled tests saved searches are not found when disabled
+func TestSavedDisabled(t *testing.T) {
+	s := newServer()
+	for i, h := range []http.HandlerFunc{s.SaveSearch, s.SavedSearch, s.SavedSearches, s.SavedSettings, s.DeleteSaved} {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h(w, httptest.NewRequest(http.MethodGet, "http://example.com/s", nil))
+			if got, want := w.Code, http.StatusNotFound; got != want {
+				t.Errorf("got status %d want %d", got, want)
+			}
+		})
+	}
+}
+
+// TestSaveForm tests the results offer to save the search and view
+// only if saved searches are enabled
+func TestSaveForm(t *testing.T) {
+	for i, enabled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			var s *server
+			if enabled {
+				s = newSavedServer(t)
+			} else {
+				s = newServer()
+				s.DirFS = &fileSystem{}
+				s.DirFS.TplFS = os.DirFS("templates")
+			}
+			s.results.put("strict=false&query=thinkpad", viewBoxes)
+			r := signIn(s, httptest.NewRequest(http.MethodGet, "http://example.com/view?query=thinkpad&grade=B", nil))
+			w := httptest.NewRecorder()
+			s.View(w, r)
+			want := `<input type="hidden" name="query" value="grade=B&amp;query=thinkpad&amp;strict=false" />`
+			if got := strings.Contains(w.Body.String(), want); got != enabled {
+				t.Errorf("got save form %t want %t:\n%s", got, enabled, w.Body.String())
+			}
+		})
+	}
+}
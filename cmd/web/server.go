@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"html/template"
 	"io"
 	"log"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
 	"time"
@@ -17,12 +19,14 @@ import (
 
 	"github.com/rorycl/cexfind"
 	"github.com/rorycl/cexfind/cmd"
+	"github.com/rorycl/cexfind/location"
 )
 
 // listenAndServe is an indirect of http/net.Server.ListenAndServe
 var listenAndServe = (*http.Server).ListenAndServe
 
-// production is default; set inDevelopment to true with build tag
+// production is default; set inDevelopment to true with the development
+// (or older inDevelopment) build tag
 var inDevelopment bool = false
 
 // the server struct holds development flags and static and template
@@ -32,12 +36,60 @@ type server struct {
 	ServerAddress     string
 	ServerPort        string
 	BaseURL           string
+	DefaultPostcode   string
+
+	// timeouts
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	StreamTimeout     time.Duration
+	ShutdownTimeout   time.Duration
+
+	// draining is set when the server is shutting down
+	draining *draining
+
+	// request limits; see limits.go
+	MaxBodyBytes       int64
+	MaxQueries         int
+	RateLimit          float64
+	RateBurst          int
+	MaxUpstream        int
+	TrustedProxyHeader string
+	TrustedProxies     []netip.Prefix
+	limiter            *rateLimiter
+	upstream           *upstreamLimiter
+
+	// results are kept for sorting and filtering; see view.go
+	results *resultsCache
+
+	// the prices of boxes found are recorded; see history.go
+	history *priceHistory
+
+	// searches may be saved and checked for alerts; see saved.go and
+	// alerts.go. saved is nil if saved searches are disabled
+	savedSearchFile  string
+	savedToken       string
+	saved            *savedStore
+	MaxSavedSearches int
+	AlertInterval    time.Duration
+	MaxAlertChecks   int
+	notifiers        map[string]notifier
 
 	// cex is the main cexfind plug point
 	cex *cexfind.CexFind
 
-	// searcher is an indirect of cex.Search to allow testing
-	searcher func(cex *cexfind.CexFind, queries []string, strict bool, postcode string) ([]cexfind.Box, error)
+	// metrics are recorded from requests and cex events
+	metrics *metrics
+
+	// searcher is an indirect of cex.SearchFrom to allow testing
+	searcher func(cex *cexfind.CexFind, queries []string, strict bool, origins ...location.Origin) ([]cexfind.Box, error)
+
+	// streamer is an indirect of cex.SearchStream to allow testing
+	streamer func(cex *cexfind.CexFind, ctx context.Context, queries []string, strict bool, origins ...location.Origin) (<-chan cexfind.QueryResult, error)
+
+	// storeLister is an indirect of cex.Stores to allow testing
+	storeLister func(cex *cexfind.CexFind, origins ...location.Origin) ([]location.StoreWithDistance, error)
 
 	staticDirDev string
 	tplDirDev    string
@@ -45,38 +97,104 @@ type server struct {
 	tplDir       string
 	DirFS        *fileSystem
 
+	// templates are parsed once, or on each use in development
+	templates *templateCache
+
 	// serveFunc is an indirect for the main server functionality,
 	// provided for testing
 	serveFunc func()
 }
 
+// newServer returns a server with the default config.
 func newServer() *server {
+	return newServerWithConfig(defaultConfig())
+}
+
+// newServerWithConfig returns a server configured by cfg, which should
+// have been validated.
+func newServerWithConfig(cfg config) *server {
+	m := newMetrics()
 	s := server{
-		// paths
-		staticDirDev: "static",
-		tplDirDev:    "templates",
+		// paths; the development paths are used with the inDevelopment
+		// build tag
+		staticDirDev: cfg.StaticDir,
+		tplDirDev:    cfg.TemplateDir,
 		staticDir:    "static",
 		tplDir:       "templates",
+		templates:    &templateCache{reload: inDevelopment},
+
+		// initialise the search apparatus; store and postcode
+		// locations are cached for use at a cold start
+		cex:     cexfind.NewCexFind(append(cfg.cexOptions(), cexfind.WithObserver(m.observer()))...),
+		metrics: m,
+
+		// searcher is an indirect of cex.SearchFrom to allow testing
+		searcher: (*cexfind.CexFind).SearchFrom,
 
-		// initialise the search apparatus
-		cex: cexfind.NewCexFind(),
+		// streamer is an indirect of cex.SearchStream to allow testing
+		streamer: (*cexfind.CexFind).SearchStream,
 
-		// searcher is an indirect of cex.Search to allow testing
-		searcher: (*cexfind.CexFind).Search,
+		// storeLister is an indirect of cex.Stores to allow testing
+		storeLister: (*cexfind.CexFind).Stores,
 
 		// WebMaxHeaderBytes is the largest number of header bytes accepted by
 		// the webserver
-		WebMaxHeaderBytes: 1 << 17, // ~125k
+		WebMaxHeaderBytes: cfg.MaxHeaderBytes,
 
-		// ServerAddress is the default Server network address
-		ServerAddress: "127.0.0.1",
-
-		// ServerPort is the default Server network port
-		ServerPort: "8000",
+		// ServerAddress and ServerPort are the Server network address
+		// and port
+		ServerAddress: cfg.Address,
+		ServerPort:    cfg.Port,
 
 		// BaseURL is the base url for redirects, etc.
-		BaseURL: "",
+		BaseURL: cfg.BaseURL,
+
+		// DefaultPostcode is filled in the search form if none is given
+		DefaultPostcode: cfg.DefaultPostcode,
+
+		// the write timeout is overridden by StreamTimeout for streamed
+		// searches
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		StreamTimeout:     cfg.StreamTimeout,
+
+		// ShutdownTimeout is the longest time to wait for requests to
+		// complete on shutdown
+		ShutdownTimeout: cfg.ShutdownTimeout,
+		draining:        newDraining(),
+
+		// MaxBodyBytes is the largest request body accepted
+		MaxBodyBytes: cfg.MaxBodyBytes,
+
+		// MaxQueries is the largest number of queries in a search
+		MaxQueries: cfg.MaxQueries,
+
+		// RateLimit is the number of searches per second replenished
+		// for each client, allowing bursts of RateBurst searches
+		RateLimit: cfg.RateLimit,
+		RateBurst: cfg.RateBurst,
+
+		// MaxUpstream is the largest number of queries in flight to
+		// Cex across all clients
+		MaxUpstream: cfg.MaxUpstream,
+
+		// TrustedProxyHeader, such as X-Forwarded-For, provides the
+		// client address for requests from the TrustedProxies
+		TrustedProxyHeader: cfg.TrustedProxyHeader,
+		TrustedProxies:     cfg.TrustedProxies,
 	}
+	s.limiter = newRateLimiter(s.RateLimit, s.RateBurst)
+	s.upstream = &upstreamLimiter{max: s.MaxUpstream}
+	s.results = newResultsCache(cfg.ResultsCacheSize, cfg.ResultsCacheTTL)
+	s.history = newPriceHistory(cfg.PriceHistorySize)
+	s.savedSearchFile = cfg.SavedSearchFile
+	s.savedToken = cfg.SavedSearchToken
+	s.MaxSavedSearches = cfg.MaxSavedSearches
+	s.AlertInterval = cfg.AlertInterval
+	s.MaxAlertChecks = cfg.MaxAlertChecks
+	s.notifiers = newNotifiers(cfg)
 	// serveFunc is an indirect for testing
 	s.serveFunc = s.serve
 	return &s
@@ -106,6 +224,26 @@ func (s *server) Serve(addr, port string) {
 	if err := s.setupFS(); err != nil {
 		log.Fatal(err)
 	}
+	// parse the templates at startup to report any errors early
+	if _, err := s.templates.get(s.tplFS()); err != nil {
+		log.Fatal(err)
+	}
+	// and likewise the api specification
+	if _, err := openAPIJSON(); err != nil {
+		log.Fatal(err)
+	}
+	// saved searches are opt-in, with a token for the operator, and are
+	// disabled if their database can't be opened
+	if s.savedSearchFile != "" && s.savedToken == "" {
+		log.Print("no saved-search-token: saved searches disabled")
+	} else if s.savedSearchFile != "" {
+		saved, err := openSavedStore(s.savedSearchFile)
+		if err != nil {
+			log.Printf("%v: saved searches disabled", err)
+		} else {
+			s.saved = saved
+		}
+	}
 	s.serveFunc()
 }
 
@@ -122,8 +260,36 @@ func (s *server) serve() {
 	)
 
 	// routes
-	r.HandleFunc("/results", s.Results)
+	r.HandleFunc("/results", s.rateLimit(s.Results))
+	// a streamed search is only charged to the client's rate limit by
+	// /events, which queries Cex, rather than also by /stream
+	r.HandleFunc("/stream", s.Stream)
+	r.HandleFunc("/events", s.rateLimit(s.Events)).Methods(http.MethodGet)
+	r.HandleFunc("/view", s.View).Methods(http.MethodGet)
+	r.HandleFunc("/box/{id:"+boxIDPattern+"}", s.Box).Methods(http.MethodGet)
+	r.HandleFunc("/compare", s.Compare).Methods(http.MethodGet)
+	r.HandleFunc("/stores", s.rateLimit(s.StoreDirectory)).Methods(http.MethodGet)
+	r.HandleFunc("/stores/{id:[0-9]+}", s.rateLimit(s.StorePage)).Methods(http.MethodGet)
+	// saved searches may only be changed by the operator, but may be
+	// shared; /s/login is routed before the names it would match
+	r.HandleFunc("/s", s.operatorOnly(s.SavedSearches)).Methods(http.MethodGet)
+	r.HandleFunc("/s", s.rateLimit(s.operatorOnly(s.SaveSearch))).Methods(http.MethodPost)
+	r.HandleFunc("/s/login", s.SavedLogin).Methods(http.MethodGet)
+	r.HandleFunc("/s/login", s.rateLimit(s.SavedLogin)).Methods(http.MethodPost)
+	r.HandleFunc("/s/{name:"+savedNamePattern+"}", s.SavedSearch).Methods(http.MethodGet)
+	r.HandleFunc("/s/{name:"+savedNamePattern+"}/settings", s.operatorOnly(s.SavedSettings)).Methods(http.MethodGet)
+	r.HandleFunc("/s/{name:"+savedNamePattern+"}/settings", s.rateLimit(s.operatorOnly(s.SavedSettings))).Methods(http.MethodPost)
+	r.HandleFunc("/s/{name:"+savedNamePattern+"}/delete", s.rateLimit(s.operatorOnly(s.DeleteSaved))).Methods(http.MethodPost)
+	r.HandleFunc("/feed.atom", s.rateLimit(s.FeedAtom)).Methods(http.MethodGet)
+	r.HandleFunc("/feed.rss", s.rateLimit(s.FeedRSS)).Methods(http.MethodGet)
 	r.HandleFunc("/health", s.Health)
+	r.HandleFunc("/livez", s.Livez).Methods(http.MethodGet)
+	r.HandleFunc("/readyz", s.Readyz).Methods(http.MethodGet)
+	r.HandleFunc("/metrics", s.Metrics).Methods(http.MethodGet)
+	r.HandleFunc("/api/"+apiVersion+"/search", s.rateLimit(s.APISearch)).Methods(http.MethodGet, http.MethodPost)
+	r.HandleFunc("/api/"+apiVersion+"/stores", s.rateLimit(s.APIStores)).Methods(http.MethodGet)
+	r.HandleFunc("/api/"+apiVersion+"/health", s.APIHealth).Methods(http.MethodGet)
+	r.HandleFunc("/api/"+apiVersion+"/openapi.json", s.OpenAPI).Methods(http.MethodGet)
 	r.HandleFunc("/favicon.ico", s.Favicon)
 	r.HandleFunc("/", s.Home)
 
@@ -145,7 +311,8 @@ func (s *server) serve() {
 	}
 
 	// attach middleware
-	// r.Use(bodyLimitMiddleware)
+	r.Use(s.metrics.middleware)
+	r.Use(s.bodyLimitMiddleware)
 	r.Use(logging)
 	r.Use(compressor)
 	r.Use(recovery)
@@ -156,22 +323,62 @@ func (s *server) serve() {
 		Handler: r,
 		// timeouts and limits
 		MaxHeaderBytes:    s.WebMaxHeaderBytes,
-		ReadTimeout:       1 * time.Second,
-		WriteTimeout:      2 * time.Second,
-		IdleTimeout:       30 * time.Second,
-		ReadHeaderTimeout: 2 * time.Second,
+		ReadTimeout:       s.ReadTimeout,
+		WriteTimeout:      s.WriteTimeout,
+		IdleTimeout:       s.IdleTimeout,
+		ReadHeaderTimeout: s.ReadHeaderTimeout,
 	}
 	log.Printf("serving on %s:%s", s.ServerAddress, s.ServerPort)
 
-	err := listenAndServe(server)
-	if err != nil {
+	// serve until a shutdown signal, then stop accepting connections
+	// and wait up to ShutdownTimeout for requests to complete; event
+	// streams are ended early
+	ctx, stop := notifyContext(context.Background(), shutdownSignals...)
+	defer stop()
+	alertsDone := make(chan struct{})
+	go func() {
+		s.runAlerts(ctx)
+		close(alertsDone)
+	}()
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- listenAndServe(server)
+	}()
+	select {
+	case err := <-serverErr:
 		log.Printf("fatal server error: %v", err)
+	case <-ctx.Done():
+		log.Printf("shutting down, waiting up to %s for requests to complete", s.ShutdownTimeout)
+		s.draining.start()
+		drainCtx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(drainCtx); err != nil {
+			log.Printf("shutdown error: %v", err)
+		}
+		if err := <-serverErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("server error: %v", err)
+		}
+	}
+	if err := s.cex.SaveLocationCache(); err != nil {
+		log.Printf("location cache error: %v", err)
+	}
+	stop()
+	<-alertsDone
+	if s.saved != nil {
+		if err := s.saved.close(); err != nil {
+			log.Printf("saved search database error: %v", err)
+		}
 	}
 }
 
-// Results shows the results of a "search" form submission in an htmx partial
+// Results shows the results of a "search" form submission in an htmx
+// partial. GET requests are shown by ResultsPage.
 func (s *server) Results(w http.ResponseWriter, r *http.Request) {
 
+	if r.Method == http.MethodGet {
+		s.ResultsPage(w, r)
+		return
+	}
 	if r.Method != "POST" {
 		w.WriteHeader(http.StatusBadRequest)
 		log.Print("endpoint only accepts POST requests, got", r.Method)
@@ -182,8 +389,8 @@ func (s *server) Results(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
 	defer r.Body.Close()
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
 		log.Print("results endpoint body reading error", err)
+		s.writeError(w, r, limitStatus(err, http.StatusBadRequest), err)
 		return
 	}
 	if inDevelopment {
@@ -196,59 +403,156 @@ func (s *server) Results(w http.ResponseWriter, r *http.Request) {
 		log.Printf("url parsequery error: %v", err)
 		return
 	}
-	var postResults QueriesType
-	var decoder = schema.NewDecoder() // best as package decoder
-	err = decoder.Decode(&postResults, urlVals)
-	if err != nil || len(postResults.Query) == 0 {
-		log.Printf("cex POST : %+v %v", postResults, err)
+	search, err := s.parseSearch(urlVals)
+	switch {
+	case errors.Is(err, errNoQuery):
+		log.Printf("cex POST : %+v %v", search.Form, err)
 		w.WriteHeader(http.StatusNoContent)
 		fmt.Fprint(w, "no query found")
 		return
+	case errors.Is(err, errTooManyQueries):
+		log.Printf("cex POST : %+v %v", search.Form, err)
+		s.writeError(w, r, http.StatusRequestEntityTooLarge, err)
+		return
+	case err != nil:
+		log.Printf("cex POST : %+v %v", search.Form, err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err)
+		return
+	}
+	view, err := parseView(urlVals)
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	release, ok := s.acquireUpstream(w, r, len(search.Queries))
+	if !ok {
+		return
+	}
+	defer release()
+
+	// push the search terms and view to the url
+	w.Header().Set("HX-Push-Url", s.BaseURL+"/?"+search.urlQuery()+view.urlQuery())
+
+	// search; note that searcher is an indirect to search/cex.SearchFrom
+	sr := searchResults{}
+	sr.Results, err = s.searcher(s.cex, search.Queries, search.Form.Strict, search.Origins...)
+	setDistanceUnit(sr.Results, search.Unit)
+
+	// results from the stale cache are shown with a notice rather than
+	// an error
+	age, stale, err := splitStale(err)
+	sr.Err = err
+	s.history.recordLive(sr.Results, stale)
+
+	// keep the results for sorting and filtering
+	if len(sr.Results) > 0 {
+		s.results.putStale(search.urlQuery(), sr.Results, age)
+		sr.View = newViewData(search, view, sr.Results, "#result-listing")
+		sr.View.Map = s.newResultsMap(sr.Results, search.Origins, search.Unit)
+		sr.View.Saving = s.canSave(r)
+		if stale {
+			sr.View.Stale = staleBanner(age)
+		}
+	}
+
+	s.render(w, "partial-results.html", sr)
+}
+
+// searchResults are the results of a search shown with the
+// partial-results template.
+type searchResults struct {
+	Results []cexfind.Box
+	Err     error
+	View    *viewData
+}
+
+// errNoQuery reports a search without a query.
+var errNoQuery = errors.New("no query found")
+
+// searchRequest is a parsed and checked search form.
+type searchRequest struct {
+	Form    QueriesType
+	Queries []string
+	Origins []location.Origin
+	Unit    location.Unit
+}
+
+// parseSearch decodes and checks the search parameters in vals, which
+// may be from a form or url query string. The queries are split and
+// checked, and the postcode parsed as one or more origins, which may
+// also be place names or "latitude,longitude" pairs. Distances are
+// shown in the server's default unit unless another is chosen.
+func (s *server) parseSearch(vals url.Values) (searchRequest, error) {
+	var sr searchRequest
+	var decoder = schema.NewDecoder() // best as package decoder
+	decoder.IgnoreUnknownKeys(true)
+	err := decoder.Decode(&sr.Form, vals)
+	if err != nil {
+		return sr, fmt.Errorf("%w: %v", errNoQuery, err)
+	}
+	if len(sr.Form.Query) == 0 {
+		return sr, errNoQuery
 	}
 
 	// split the comma delimited query into queries
-	queries, err := cmd.QueryInputChecker(postResults.Query...)
+	sr.Queries, err = cmd.QueryInputChecker(sr.Form.Query...)
 	if err != nil {
-		log.Printf("cex queries error: %v %v", postResults.Query, err)
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "query error: %v", err)
-		return
+		return sr, fmt.Errorf("query error: %w", err)
+	}
+	if s.MaxQueries > 0 && len(sr.Queries) > s.MaxQueries {
+		return sr, fmt.Errorf("query error: %w: %d given, the maximum is %d", errTooManyQueries, len(sr.Queries), s.MaxQueries)
+	}
+
+	sr.Origins, err = location.ParseOrigins(sr.Form.Postcode)
+	if err != nil {
+		return sr, fmt.Errorf("location error: %w", err)
 	}
 
+	sr.Unit = s.cex.DistanceUnit()
+	if sr.Form.Units != "" {
+		sr.Unit, err = location.ParseUnit(sr.Form.Units)
+		if err != nil {
+			return sr, fmt.Errorf("units error: %w", err)
+		}
+	}
+	return sr, nil
+}
+
+// urlQuery returns the search as a url query string.
+func (sr searchRequest) urlQuery() string {
 	base := fmt.Sprintf("strict=%s", func() string {
-		if postResults.Strict {
+		if sr.Form.Strict {
 			return "true"
 		}
 		return "false"
 	}())
-	if postResults.Postcode != "" {
-		base += fmt.Sprintf("&postcode=%s", url.PathEscape(postResults.Postcode))
+	if sr.Form.Postcode != "" {
+		base += fmt.Sprintf("&postcode=%s", url.QueryEscape(sr.Form.Postcode))
 	}
-	for _, q := range queries {
-		base += fmt.Sprintf("&query=%s", url.PathEscape(q))
+	if sr.Form.Units != "" {
+		base += fmt.Sprintf("&units=%s", sr.Unit)
 	}
-	// push the postResults terms to the url
-	w.Header().Set("HX-Push-Url", s.BaseURL+"/?"+base)
-
-	// search; note that searcher is an indirect to search/cex.Search
-	type SearchResults struct {
-		Results []cexfind.Box
-		Err     error
+	for _, q := range sr.Queries {
+		base += fmt.Sprintf("&query=%s", url.QueryEscape(q))
 	}
-	sr := SearchResults{}
-	sr.Results, sr.Err = s.searcher(s.cex, queries, postResults.Strict, postResults.Postcode)
+	return base
+}
 
-	t := template.Must(template.ParseFS(s.DirFS.TplFS, "partial-results.html"))
-	err = t.Execute(w, sr)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "template writing problem : %s", err.Error())
+// setDistanceUnit sets the unit used to format the store distances of
+// each box; both miles and kilometres are always calculated.
+func setDistanceUnit(boxes []cexfind.Box, unit location.Unit) {
+	for i := range boxes {
+		for j := range boxes[i].Stores {
+			boxes[i].Stores[j].Unit = unit
+		}
 	}
 }
 
 type QueriesType struct {
 	Postcode string   `schema:"postcode"`
 	Strict   bool     `schema:"strict"`
+	Units    string   `schema:"units"`
 	Query    []string `schema:"query"`
 }
 
@@ -265,39 +569,73 @@ func (q QueriesType) String() string {
 	return output
 }
 
+// homeData is the template data for the home page, which also shows
+// the results of a search made without javascript.
+type homeData struct {
+	Title               string
+	Address             string
+	Port                string
+	Search              QueriesType
+	FeedQuery           string
+	View                url.Values
+	Unit                string
+	LocationDistancesOK bool
+	LocationStoresStale bool
+	LocationStoresDays  int
+	Results             *searchResults
+}
+
 // Home is the home page
 func (s *server) Home(w http.ResponseWriter, r *http.Request) {
+	s.render(w, "home.html", s.newHomeData(r))
+}
 
-	t, err := template.ParseFS(s.DirFS.TplFS, "home.html")
-	if err != nil {
-		log.Fatal(err)
-	}
+// newHomeData returns the home page data for the search form filled
+// from the url query of r.
+func (s *server) newHomeData(r *http.Request) homeData {
 
 	var search QueriesType
 	var decoder = schema.NewDecoder() // best as package decoder
-	err = decoder.Decode(&search, r.URL.Query())
+	decoder.IgnoreUnknownKeys(true)
+	err := decoder.Decode(&search, r.URL.Query())
+
+	// keep any sorting and filtering of the results in the form
+	view, viewErr := parseView(r.URL.Query())
+	if viewErr != nil {
+		view = resultView{}
+	}
 
 	if inDevelopment {
 		log.Printf("cex url GET : %+v %+v (%d items) err %v", r.URL.Query(), search, len(search.Query), err)
 	}
 
-	data := struct {
-		Title               string
-		Address             string
-		Port                string
-		Search              QueriesType
-		LocationDistancesOK bool
-	}{
+	if search.Postcode == "" {
+		search.Postcode = s.DefaultPostcode
+	}
+
+	unit, err := location.ParseUnit(search.Units)
+	if search.Units == "" || err != nil {
+		unit = s.cex.DistanceUnit()
+	}
+
+	storesStale, storesAge := s.cex.LocationStoresStale()
+	return homeData{
 		"search cex",
 		s.ServerAddress,
 		s.ServerPort,
 		search,
+		func() string {
+			if len(search.Query) == 0 {
+				return ""
+			}
+			return r.URL.RawQuery
+		}(),
+		view.values(),
+		unit.String(),
 		s.cex.LocationDistancesOK(),
-	}
-	err = t.Execute(w, data)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "template writing problem : %s", err.Error())
+		storesStale,
+		int(storesAge.Hours() / 24),
+		nil,
 	}
 }
 
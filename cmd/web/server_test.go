@@ -9,10 +9,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/rorycl/cexfind"
+	"github.com/rorycl/cexfind/location"
 	"github.com/shopspring/decimal"
 )
 
@@ -32,7 +34,19 @@ func TestServe(t *testing.T) {
 	s.serveFunc = func() {}
 	s.staticDirDev = "static"
 	s.tplDirDev = "templates"
+	s.savedSearchFile = filepath.Join(t.TempDir(), "saved.db")
 	s.Serve("127.0.0.1", "8123")
+	if s.saved != nil {
+		t.Fatal("expected saved searches to be disabled without a token")
+	}
+	s.savedToken = "secret"
+	s.Serve("127.0.0.1", "8123")
+	if s.saved == nil {
+		t.Fatal("expected saved searches to be enabled")
+	}
+	if err := s.saved.close(); err != nil {
+		t.Error(err)
+	}
 }
 
 // Test Home page returns a 200
@@ -120,7 +134,7 @@ func TestResults(t *testing.T) {
 	s.DirFS.TplFS = os.DirFS("templates")
 
 	// override package global searcher which indirects Search
-	s.searcher = func(cf *cexfind.CexFind, queries []string, strict bool, postcode string) ([]cexfind.Box, error) {
+	s.searcher = func(cf *cexfind.CexFind, queries []string, strict bool, origins ...location.Origin) ([]cexfind.Box, error) {
 		return []cexfind.Box{
 			cexfind.Box{Model: "2a", Name: "2a name", ID: "id3", Price: decimal.NewFromInt(3)},
 			cexfind.Box{Model: "1a", Name: "1a name", ID: "id1", Price: decimal.NewFromInt(1)},
@@ -153,8 +167,38 @@ func TestResults(t *testing.T) {
 			statusCode: http.StatusBadRequest,
 		},
 		{
-			name:       "fail get",
-			method:     http.MethodGet,
+			name:       "succeed post with place",
+			method:     http.MethodPost,
+			input:      "query=abc&postcode=Marlborough&strict=false",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "succeed post with several locations",
+			method:     http.MethodPost,
+			input:      "query=abc&postcode=home%3DS10+1LT%3Bwork%3DNW1+6LG&strict=false",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "succeed post with kilometres",
+			method:     http.MethodPost,
+			input:      "query=abc&postcode=S10+1LT&units=km&strict=false",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "fail post invalid units",
+			method:     http.MethodPost,
+			input:      "query=abc&postcode=S10+1LT&units=furlongs&strict=false",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "fail post invalid postcode",
+			method:     http.MethodPost,
+			input:      "query=abc&postcode=S10+1L&strict=false",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "fail put",
+			method:     http.MethodPut,
 			input:      "query=abc&query=def&strict=false",
 			statusCode: http.StatusBadRequest,
 		},
@@ -190,3 +234,42 @@ func TestResults(t *testing.T) {
 		})
 	}
 }
+
+// TestSetDistanceUnit checks the store distance unit is overridden
+func TestSetDistanceUnit(t *testing.T) {
+	boxes := []cexfind.Box{
+		cexfind.Box{Stores: []location.StoreWithDistance{
+			{StoreID: 1, StoreName: "a", DistanceMiles: 5.6, DistanceKm: 9.01, Resolution: location.ResolutionPostcode},
+		}},
+	}
+	setDistanceUnit(boxes, location.Kilometres)
+	if got, want := boxes[0].StoresString(-1), "a (9.0km)"; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+}
+
+// TestHomeDefaultPostcode checks the configured default postcode is
+// filled in the search form unless one is given
+func TestHomeDefaultPostcode(t *testing.T) {
+
+	cfg := defaultConfig()
+	cfg.DefaultPostcode = "S10 1LT"
+	s := newServerWithConfig(cfg)
+	s.DirFS = &fileSystem{}
+	s.DirFS.TplFS = os.DirFS("templates")
+
+	for _, tt := range []struct {
+		url  string
+		want string
+	}{
+		{"http://example.com/", `value="S10 1LT"`},
+		{"http://example.com/?query=abc&postcode=W1", `value="W1"`},
+	} {
+		r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		w := httptest.NewRecorder()
+		s.Home(w, r)
+		if !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("expected home page for %s to contain %s", tt.url, tt.want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rorycl/cexfind"
+)
+
+// splitStale returns the age of the results of a search, any of which
+// are from the stale results cache because the live search failed, and
+// the error to report, which is nil if every failed query was answered
+// from the cache.
+func splitStale(err error) (time.Duration, bool, error) {
+	var staleErr *cexfind.StaleError
+	if !errors.As(err, &staleErr) {
+		return 0, false, err
+	}
+	if err == error(staleErr) {
+		return staleErr.Age, true, nil
+	}
+	return staleErr.Age, true, err
+}
+
+// formatAge returns a short description of an age, such as "3h".
+func formatAge(age time.Duration) string {
+	switch {
+	case age < time.Hour:
+		return fmt.Sprintf("%dm", max(int(age.Minutes()), 1))
+	case age < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(age.Hours()))
+	}
+	return fmt.Sprintf("%dd", int(age.Hours()/24))
+}
+
+// staleBanner returns the notice shown with results of age from the
+// stale results cache.
+func staleBanner(age time.Duration) string {
+	return fmt.Sprintf("results from %s ago; live search unavailable", formatAge(age))
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rorycl/cexfind"
+	"github.com/rorycl/cexfind/location"
+)
+
+// TestFormatAge tests the short descriptions of ages
+func TestFormatAge(t *testing.T) {
+	tests := []struct {
+		age  time.Duration
+		want string
+	}{
+		{10 * time.Second, "1m"},
+		{45 * time.Minute, "45m"},
+		{3*time.Hour + 20*time.Minute, "3h"},
+		{47 * time.Hour, "47h"},
+		{72 * time.Hour, "3d"},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			if got := formatAge(tt.age); got != tt.want {
+				t.Errorf("got %s want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSplitStale tests separating stale results errors from others
+func TestSplitStale(t *testing.T) {
+	staleErr := &cexfind.StaleError{Query: "x390", Age: time.Hour, Err: cexfind.ErrBlocked}
+	tests := []struct {
+		err       error
+		wantStale bool
+		wantErr   bool
+	}{
+		{nil, false, false},
+		{cexfind.ErrBlocked, false, true},
+		{staleErr, true, false},
+		{fmt.Errorf("%w\n%w", staleErr, errors.New(`"t480": search blocked`)), true, true},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			age, stale, err := splitStale(tt.err)
+			if stale != tt.wantStale || (err != nil) != tt.wantErr {
+				t.Fatalf("got stale %t error %v", stale, err)
+			}
+			if stale && age != time.Hour {
+				t.Errorf("got age %s want 1h", age)
+			}
+		})
+	}
+}
+
+// TestStaleResults tests results from the stale cache are shown with a
+// notice, and kept as such for sorting and filtering
+func TestStaleResults(t *testing.T) {
+
+	s := newServer()
+	s.DirFS = &fileSystem{}
+	s.DirFS.TplFS = os.DirFS("templates")
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	s.results.now = func() time.Time { return now }
+
+	s.searcher = func(cex *cexfind.CexFind, queries []string, strict bool, origins ...location.Origin) ([]cexfind.Box, error) {
+		staleErr := &cexfind.StaleError{Query: queries[0], Age: 3 * time.Hour, Err: cexfind.ErrBlocked}
+		if len(queries) > 1 {
+			return viewBoxes, fmt.Errorf("%w\n\"%s\": %w", staleErr, queries[1], cexfind.ErrBlocked)
+		}
+		return viewBoxes, staleErr
+	}
+	s.streamer = func(cf *cexfind.CexFind, ctx context.Context, queries []string, strict bool, origins ...location.Origin) (<-chan cexfind.QueryResult, error) {
+		c := make(chan cexfind.QueryResult, 1)
+		c <- cexfind.QueryResult{Query: "thinkpad", Boxes: viewBoxes, Err: &cexfind.StaleError{Query: "thinkpad", Age: 2 * time.Hour, Err: cexfind.ErrBlocked}}
+		close(c)
+		return c, nil
+	}
+
+	banner := "results from 3h ago; live search unavailable"
+	tests := []struct {
+		method, path string
+		handler      http.HandlerFunc
+		contains     []string
+		excludes     []string
+	}{
+		{"POST", "/results", s.Results, []string{banner, "showing 4 of 4 items"}, []string{"Error:"}},
+		{"GET", "/view?query=thinkpad&sort=price", s.View, []string{banner}, nil},
+		{"GET", "/results?query=thinkpad", s.Results, []string{banner}, []string{"Error:"}},
+		{"GET", "/results?query=thinkpad&format=json", s.Results, []string{`"stale":true`, `"staleAgeSeconds":10800`}, []string{`"error"`}},
+		{"GET", "/results?query=thinkpad&format=text", s.Results, []string{banner + "\n\nt480\n"}, []string{"error:"}},
+		{"GET", "/results?query=thinkpad%3Bt480", s.Results, []string{banner, "search blocked"}, nil},
+		{"GET", "/api/v1/search?query=x390", s.APISearch, []string{`"stale":true`, `"staleAgeSeconds":10800`}, []string{`"error"`}},
+		{"GET", "/events?query=thinkpad", s.Events, []string{"results from 2h ago; live search unavailable"}, []string{"Error:"}},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			var r *http.Request
+			if tt.method == "POST" {
+				r = httptest.NewRequest(tt.method, "http://example.com"+tt.path, strings.NewReader("query=thinkpad"))
+			} else {
+				r = httptest.NewRequest(tt.method, "http://example.com"+tt.path, nil)
+			}
+			w := httptest.NewRecorder()
+			tt.handler(w, r)
+			if got, want := w.Code, http.StatusOK; got != want {
+				t.Fatalf("got status %d want %d: %s", got, want, w.Body.String())
+			}
+			for _, c := range tt.contains {
+				if !strings.Contains(w.Body.String(), c) {
+					t.Errorf("expected body to contain %q, got\n%s", c, w.Body.String())
+				}
+			}
+			for _, c := range tt.excludes {
+				if strings.Contains(w.Body.String(), c) {
+					t.Errorf("expected body not to contain %q, got\n%s", c, w.Body.String())
+				}
+			}
+		})
+	}
+
+	// stale results, perhaps older than those already seen, are not
+	// recorded in the price history
+	for _, b := range viewBoxes {
+		if s.history.get(b.ID) != nil {
+			t.Errorf("stale box %s recorded in the price history", b.ID)
+		}
+	}
+
+	// the notice ages with the cached results, last stored by the
+	// stream, and is dropped once the search succeeds
+	now = now.Add(time.Hour)
+	if age, ok := s.results.staleAge("strict=false&query=thinkpad"); !ok || age != 3*time.Hour {
+		t.Errorf("got stale age %s %t want 3h", age, ok)
+	}
+	s.results.put("strict=false&query=thinkpad", viewBoxes)
+	if _, ok := s.results.staleAge("strict=false&query=thinkpad"); ok {
+		t.Error("expected live results to not be stale")
+	}
+}
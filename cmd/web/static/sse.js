@@ -0,0 +1,101 @@
+/*
+Server Sent Events extension for htmx 1.9.
+
+A compact implementation of the attributes of the htmx "sse" extension
+(https://htmx.org/extensions/server-sent-events/):
+
+    hx-ext="sse"            enable the extension
+    sse-connect="<url>"     open an EventSource to url
+    sse-swap="<name>,..."   swap the data of the named events into this
+                            element, according to its hx-swap and
+                            hx-target attributes
+    sse-close="<name>"      close the EventSource on the named event
+
+The EventSource is closed when the connecting element is removed from
+the page. Reconnection after errors is left to the browser's
+EventSource.
+*/
+(function() {
+    var api;
+
+    htmx.defineExtension("sse", {
+        init: function(apiRef) {
+            api = apiRef;
+        },
+        onEvent: function(name, evt) {
+            var elt = evt.target || evt.detail.elt;
+            switch (name) {
+            case "htmx:beforeCleanupElement":
+                var data = api.getInternalData(elt);
+                if (data.sseEventSource) {
+                    data.sseEventSource.close();
+                }
+                return;
+            case "htmx:afterProcessNode":
+                connect(elt);
+            }
+        }
+    });
+
+    // connect opens an EventSource for an element with sse-connect and
+    // registers its sse-swap listeners, including those of descendants
+    function connect(elt) {
+        var url = api.getAttributeValue(elt, "sse-connect");
+        if (!url || api.getInternalData(elt).sseEventSource) {
+            return;
+        }
+        var source = new EventSource(url);
+        api.getInternalData(elt).sseEventSource = source;
+
+        source.onerror = function(err) {
+            api.triggerEvent(elt, "htmx:sseError", {error: err, source: source});
+            if (!api.bodyContains(elt)) {
+                source.close();
+            }
+        };
+        source.onopen = function() {
+            api.triggerEvent(elt, "htmx:sseOpen", {source: source});
+        };
+
+        var closeOn = api.getAttributeValue(elt, "sse-close");
+        if (closeOn) {
+            source.addEventListener(closeOn, function() {
+                source.close();
+                api.triggerEvent(elt, "htmx:sseClose", {source: source});
+            });
+        }
+
+        var swappers = Array.prototype.slice.call(elt.querySelectorAll("[sse-swap]"));
+        if (elt.hasAttribute("sse-swap")) {
+            swappers.unshift(elt);
+        }
+        swappers.forEach(function(child) {
+            api.getAttributeValue(child, "sse-swap").split(",").forEach(function(eventName) {
+                var listener = function(event) {
+                    if (!api.bodyContains(child)) {
+                        source.removeEventListener(eventName.trim(), listener);
+                        return;
+                    }
+                    if (!api.triggerEvent(child, "htmx:sseBeforeMessage", event)) {
+                        return;
+                    }
+                    swap(child, event.data);
+                    api.triggerEvent(child, "htmx:sseMessage", event);
+                };
+                source.addEventListener(eventName.trim(), listener);
+            });
+        });
+    }
+
+    // swap swaps content into the target of elt
+    function swap(elt, content) {
+        api.withExtensions(elt, function(extension) {
+            content = extension.transformResponse(content, null, elt);
+        });
+        var swapSpec = api.getSwapSpecification(elt);
+        var target = api.getTarget(elt);
+        var settleInfo = api.makeSettleInfo(elt);
+        api.selectAndSwap(swapSpec.swapStyle, target, elt, content, settleInfo);
+        api.settleImmediately(settleInfo.tasks);
+    }
+})();
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	_ "embed"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/rorycl/cexfind"
+	"github.com/rorycl/cexfind/location"
+)
+
+// outlineCSV is an embedded low resolution outline of Great Britain
+// and Northern Ireland, drawn as the background of store maps.
+//
+//go:embed data/outline.csv
+var outlineCSV string
+
+// the bounds of store maps, which exclude the northern isles, and the
+// map width in pixels
+const (
+	mapNorth = 58.8
+	mapSouth = 49.8
+	mapWest  = -8.3
+	mapEast  = 1.9
+	mapWidth = 280
+)
+
+// mapScale is the number of pixels per degree of latitude, with
+// longitude scaled for the middle of the map.
+var (
+	mapLonScale = math.Cos((mapNorth + mapSouth) / 2 * math.Pi / 180)
+	mapScale    = mapWidth / ((mapEast - mapWest) * mapLonScale)
+	mapHeight   = int(math.Round((mapNorth - mapSouth) * mapScale))
+)
+
+// mapOutline is the outline as svg polygon points.
+var mapOutline = mustParseOutline(outlineCSV)
+
+// project returns the map position of a latitude and longitude, and
+// whether it is within the map.
+func project(latitude, longitude float64) (x, y int, ok bool) {
+	if latitude > mapNorth || latitude < mapSouth || longitude < mapWest || longitude > mapEast {
+		return 0, 0, false
+	}
+	x = int(math.Round((longitude - mapWest) * mapLonScale * mapScale))
+	y = int(math.Round((mapNorth - latitude) * mapScale))
+	return x, y, true
+}
+
+// parseOutline parses the outline csv of latitude,longitude lines, in
+// which shapes are separated by blank lines, to svg polygon points.
+func parseOutline(s string) ([]string, error) {
+	shapes := []string{}
+	var shape []string
+	endShape := func() {
+		if len(shape) > 2 {
+			shapes = append(shapes, strings.Join(shape, " "))
+		}
+		shape = nil
+	}
+	scanner := bufio.NewScanner(strings.NewReader(s))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			endShape()
+			continue
+		case strings.HasPrefix(line, "#"):
+			continue
+		}
+		lat, lon, found := strings.Cut(line, ",")
+		if !found {
+			return nil, fmt.Errorf("outline line %d: expected latitude,longitude", n)
+		}
+		latitude, err := strconv.ParseFloat(lat, 64)
+		if err != nil {
+			return nil, fmt.Errorf("outline line %d: %w", n, err)
+		}
+		longitude, err := strconv.ParseFloat(lon, 64)
+		if err != nil {
+			return nil, fmt.Errorf("outline line %d: %w", n, err)
+		}
+		x, y, ok := project(latitude, longitude)
+		if !ok {
+			return nil, fmt.Errorf("outline line %d: %s outside the map", n, line)
+		}
+		shape = append(shape, fmt.Sprintf("%d,%d", x, y))
+	}
+	endShape()
+	return shapes, scanner.Err()
+}
+
+func mustParseOutline(s string) []string {
+	shapes, err := parseOutline(s)
+	if err != nil {
+		panic(fmt.Sprintf("embedded map outline error: %v", err))
+	}
+	return shapes
+}
+
+// mapMarker is a store or origin plotted on a map.
+type mapMarker struct {
+	X, Y, R int
+	Label   string
+	Href    string
+}
+
+// storeMap is a map of stores and origins drawn as inline svg. Omitted
+// is the number of stores which could not be plotted, as they could
+// not be located or are outside the map.
+type storeMap struct {
+	Width, Height int
+	Outline       []string
+	Stores        []mapMarker
+	Origins       []mapMarker
+	Omitted       int
+}
+
+// markerRadius returns the radius of a store marker for count items.
+func markerRadius(count int) int {
+	return min(3+int(math.Round(2*math.Sqrt(float64(count)))), 12)
+}
+
+// storeAnchor returns the id of the element listing a store, which
+// store markers link to.
+func storeAnchor(st location.StoreWithDistance) string {
+	return fmt.Sprintf("store-%d", st.StoreID)
+}
+
+// mapStore is a store to plot, holding count matching items, if
+// counted.
+type mapStore struct {
+	Store location.StoreWithDistance
+	Count int
+}
+
+// newStoreMap returns a map of the stores, with markers sized by the
+// number of items each holds and linked to the store's listing, and of
+// the origins.
+func newStoreMap(stores []mapStore, origins []location.Location, originLabels []string) *storeMap {
+	m := &storeMap{Width: mapWidth, Height: mapHeight, Outline: mapOutline}
+	for _, ms := range stores {
+		st := ms.Store
+		x, y, ok := project(st.Latitude, st.Longitude)
+		if st.StoreID == 0 || !ok {
+			m.Omitted++
+			continue
+		}
+		label := st.StoreName
+		if ms.Count > 0 {
+			label += fmt.Sprintf(": %d item", ms.Count)
+			if ms.Count != 1 {
+				label += "s"
+			}
+		}
+		if d := st.FormatDistance(); d != "" {
+			label += ", " + d
+		}
+		m.Stores = append(m.Stores, mapMarker{X: x, Y: y, R: markerRadius(ms.Count), Label: label, Href: "#" + storeAnchor(st)})
+	}
+	// draw the largest markers first so that smaller ones are not
+	// hidden
+	slices.SortStableFunc(m.Stores, func(a, b mapMarker) int { return cmp.Compare(b.R, a.R) })
+	for i, l := range origins {
+		if x, y, ok := project(l.Latitude, l.Longitude); ok {
+			m.Origins = append(m.Origins, mapMarker{X: x, Y: y, R: 5, Label: originLabels[i]})
+		}
+	}
+	return m
+}
+
+// storeItems is a store with the items it holds.
+type storeItems struct {
+	Store  location.StoreWithDistance
+	Anchor string
+	Boxes  []cexfind.Box
+}
+
+// resultsMap is a map of the stores holding the items of a result set,
+// with a listing of the items held by each store.
+type resultsMap struct {
+	Map       *storeMap
+	Stores    []storeItems
+	Distances bool
+}
+
+// locatedStores returns the stores holding a box with their locations,
+// which are only found by a search with an origin.
+func (s *server) locatedStores(b cexfind.Box) []location.StoreWithDistance {
+	if slices.ContainsFunc(b.Stores, func(st location.StoreWithDistance) bool { return st.StoreID != 0 }) {
+		return b.Stores
+	}
+	stores, err := s.cex.BoxStores(b)
+	if err != nil {
+		return b.Stores
+	}
+	return stores
+}
+
+// locateOrigins returns the locations of the origins which can be
+// located, and their labels.
+func (s *server) locateOrigins(origins []location.Origin) ([]location.Location, []string) {
+	var locations []location.Location
+	var labels []string
+	for _, o := range origins {
+		l, err := s.cex.Locate(o)
+		if err != nil {
+			continue
+		}
+		locations = append(locations, l)
+		labels = append(labels, o.Label())
+	}
+	return locations, labels
+}
+
+// newResultsMap returns the map and store listing of the stores holding
+// boxes, sorted by distance if known and otherwise by the number of
+// items held, or nil if there are no stores.
+func (s *server) newResultsMap(boxes []cexfind.Box, origins []location.Origin, unit location.Unit) *resultsMap {
+	byStore := map[string]*storeItems{}
+	for _, b := range boxes {
+		for _, st := range s.locatedStores(b) {
+			si, ok := byStore[st.StoreName]
+			if !ok {
+				st.Unit = unit
+				si = &storeItems{Store: st}
+				if st.StoreID != 0 {
+					si.Anchor = storeAnchor(st)
+				}
+				byStore[st.StoreName] = si
+			}
+			si.Boxes = append(si.Boxes, b)
+		}
+	}
+	if len(byStore) == 0 {
+		return nil
+	}
+
+	rm := &resultsMap{}
+	for _, si := range byStore {
+		rm.Stores = append(rm.Stores, *si)
+		if si.Store.FormatDistance() != "" {
+			rm.Distances = true
+		}
+	}
+	slices.SortFunc(rm.Stores, func(a, b storeItems) int {
+		if rm.Distances {
+			return cmp.Or(cmp.Compare(nearestStoreDistance(a.Store), nearestStoreDistance(b.Store)), cmp.Compare(a.Store.StoreName, b.Store.StoreName))
+		}
+		return cmp.Or(cmp.Compare(len(b.Boxes), len(a.Boxes)), cmp.Compare(a.Store.StoreName, b.Store.StoreName))
+	})
+
+	stores := make([]mapStore, len(rm.Stores))
+	for i, si := range rm.Stores {
+		stores[i] = mapStore{Store: si.Store, Count: len(si.Boxes)}
+	}
+	locations, labels := s.locateOrigins(origins)
+	rm.Map = newStoreMap(stores, locations, labels)
+	return rm
+}
+
+// nearestStoreDistance returns the distance of a store from the
+// nearest origin, or +Inf if it isn't known.
+func nearestStoreDistance(st location.StoreWithDistance) float64 {
+	if st.FormatDistance() == "" {
+		return math.Inf(1)
+	}
+	return st.DistanceMiles
+}
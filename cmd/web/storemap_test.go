@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/rorycl/cexfind"
+	"github.com/rorycl/cexfind/location"
+)
+
+// TestProject tests positions are projected onto the map
+func TestProject(t *testing.T) {
+	tests := []struct {
+		lat, lon float64
+		x, y     int
+		ok       bool
+	}{
+		{mapNorth, mapWest, 0, 0, true},
+		{mapSouth, mapEast, mapWidth, mapHeight, true},
+		{51.5, -0.12, 225, 343, true}, // london
+		{60.15, -1.15, 0, 0, false},   // lerwick
+		{48.85, 2.35, 0, 0, false},    // paris
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			x, y, ok := project(tt.lat, tt.lon)
+			if got, want := fmt.Sprintf("%d,%d %t", x, y, ok), fmt.Sprintf("%d,%d %t", tt.x, tt.y, tt.ok); got != want {
+				t.Errorf("got %s want %s", got, want)
+			}
+		})
+	}
+}
+
+// TestParseOutline tests the embedded outline and outline errors
+func TestParseOutline(t *testing.T) {
+	if got, want := len(mapOutline), 3; got != want {
+		t.Errorf("got %d outline shapes want %d", got, want)
+	}
+	shapes, err := parseOutline("# comment\n50,-1\n51,-1\n51,0\n\n52,0\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(shapes), 1; got != want {
+		t.Errorf("got %d shapes want %d, ignoring shapes of too few points", got, want)
+	}
+	for _, s := range []string{"50;1", "50,x", "61,0"} {
+		if _, err := parseOutline(s); err == nil {
+			t.Errorf("expected an error parsing %q", s)
+		}
+	}
+}
+
+// TestNewStoreMap tests markers are sized and linked, and stores which
+// can't be plotted omitted
+func TestNewStoreMap(t *testing.T) {
+	stores := []mapStore{
+		{location.StoreWithDistance{StoreID: 1, StoreName: "Leeds", Latitude: 53.8, Longitude: -1.55}, 1},
+		{location.StoreWithDistance{StoreID: 2, StoreName: "Havant", Latitude: 50.85, Longitude: -0.98}, 9},
+		{location.StoreWithDistance{StoreName: "Unknown"}, 2},
+		{location.StoreWithDistance{StoreID: 3, StoreName: "Lerwick", Latitude: 60.15, Longitude: -1.15}, 1},
+	}
+	m := newStoreMap(stores, []location.Location{{Latitude: 53.38, Longitude: -1.47}}, []string{"S10 1LT"})
+
+	got := []string{}
+	for _, mk := range m.Stores {
+		got = append(got, fmt.Sprintf("%s %d %s", mk.Href, mk.R, mk.Label))
+	}
+	if got, want := strings.Join(got, "; "), "#store-2 9 Havant: 9 items; #store-1 5 Leeds: 1 item"; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+	if got, want := m.Omitted, 2; got != want {
+		t.Errorf("got %d omitted want %d", got, want)
+	}
+	if got, want := len(m.Origins), 1; got != want {
+		t.Fatalf("got %d origins want %d", got, want)
+	}
+	if got, want := m.Origins[0].Label, "S10 1LT"; got != want {
+		t.Errorf("got origin %s want %s", got, want)
+	}
+}
+
+// TestNewResultsMap tests the store listing of a result set
+func TestNewResultsMap(t *testing.T) {
+	s := newServer()
+
+	leeds := location.StoreWithDistance{StoreID: 1, StoreName: "Leeds", Latitude: 53.8, Longitude: -1.55, DistanceMiles: 30, DistanceKm: 48.3, Resolution: location.ResolutionPostcode}
+	york := location.StoreWithDistance{StoreID: 2, StoreName: "York", Latitude: 53.96, Longitude: -1.08, DistanceMiles: 20, DistanceKm: 32.2, Resolution: location.ResolutionPostcode}
+	boxes := []cexfind.Box{
+		{ID: "a1A", Price: decimal.NewFromInt(10), Stores: []location.StoreWithDistance{york, leeds}},
+		{ID: "b1B", Price: decimal.NewFromInt(20), Stores: []location.StoreWithDistance{leeds}},
+	}
+	rm := s.newResultsMap(boxes, nil, location.Kilometres)
+	if rm == nil {
+		t.Fatal("expected a results map")
+	}
+	got := []string{}
+	for _, si := range rm.Stores {
+		got = append(got, fmt.Sprintf("%s:%d %s", si.Store.StoreName, len(si.Boxes), si.Store.FormatDistance()))
+	}
+	// sorted by distance
+	if got, want := strings.Join(got, " "), "York:1 32km Leeds:2 48km"; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+	if !rm.Distances || len(rm.Map.Stores) != 2 {
+		t.Errorf("expected distances and two markers, got %+v", rm)
+	}
+
+	if s.newResultsMap([]cexfind.Box{{ID: "c1C"}}, nil, location.Miles) != nil {
+		t.Error("expected no map for boxes without stores")
+	}
+
+	// the map and listing are shown with the results
+	tpl, err := parseTemplates(os.DirFS("templates"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sb strings.Builder
+	if err := tpl.execute(&sb, "results-map", rm); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []string{`<a href="#store-2">`, `id="store-1"`, `href="./box/b1B"`} {
+		if !strings.Contains(sb.String(), c) {
+			t.Errorf("expected map to contain %q, got\n%s", c, sb.String())
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/rorycl/cexfind/location"
+)
+
+// storeEntry is a store in the store directory, with the link to its
+// page.
+type storeEntry struct {
+	Store  location.StoreWithDistance
+	Anchor string
+	Link   string
+}
+
+// storeRegion is the stores of a region in the store directory.
+type storeRegion struct {
+	Region string
+	Stores []storeEntry
+}
+
+// storesQuery returns the url query string carrying the postcode and
+// any chosen units to the store pages, or an empty string.
+func storesQuery(postcode string, vals url.Values) string {
+	q := url.Values{}
+	if postcode != "" {
+		q.Set("postcode", postcode)
+	}
+	if units := vals.Get("units"); units != "" {
+		q.Set("units", units)
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+// groupStores groups stores, which are sorted by distance or name, by
+// region. The regions are in the order of their nearest store if
+// byDistance, and otherwise by name. Stores without a region are in
+// the "other" region, which is last unless byDistance.
+func groupStores(stores []location.StoreWithDistance, query string, byDistance bool) []storeRegion {
+	regions := []storeRegion{}
+	index := map[string]int{}
+	for _, st := range stores {
+		region := st.RegionName
+		if region == "" {
+			region = "other"
+		}
+		i, ok := index[region]
+		if !ok {
+			i = len(regions)
+			index[region] = i
+			regions = append(regions, storeRegion{Region: region})
+		}
+		regions[i].Stores = append(regions[i].Stores, storeEntry{
+			Store:  st,
+			Anchor: storeAnchor(st),
+			Link:   "./stores/" + strconv.Itoa(st.StoreID) + query,
+		})
+	}
+	if !byDistance {
+		slices.SortStableFunc(regions, func(a, b storeRegion) int {
+			switch {
+			case a.Region == b.Region:
+				return 0
+			case a.Region == "other":
+				return 1
+			case b.Region == "other":
+				return -1
+			}
+			return cmp.Compare(strings.ToLower(a.Region), strings.ToLower(b.Region))
+		})
+	}
+	return regions
+}
+
+// storeList returns the stores with distances from the postcode and
+// units parameters of vals, writing an error response if they are
+// invalid.
+func (s *server) storeList(w http.ResponseWriter, vals url.Values) (stores []location.StoreWithDistance, postcode string, origins []location.Origin, unit location.Unit, ok bool) {
+	postcode, origins, unit, err := s.boxSearch(vals)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, postcode, nil, unit, false
+	}
+	stores, err = s.storeLister(s.cex, origins...)
+	if err != nil {
+		http.Error(w, err.Error(), storesErrorStatus(err))
+		return nil, postcode, nil, unit, false
+	}
+	for i := range stores {
+		stores[i].Unit = unit
+	}
+	return stores, postcode, origins, unit, true
+}
+
+// StoreDirectory lists the Cex stores grouped by region, with their
+// closing times and a map. If the "postcode" parameter is given, which
+// may be several origins, the stores and regions are sorted by distance
+// and otherwise by name. Each store links to its page.
+func (s *server) StoreDirectory(w http.ResponseWriter, r *http.Request) {
+
+	stores, postcode, origins, unit, ok := s.storeList(w, r.URL.Query())
+	if !ok {
+		return
+	}
+	query := storesQuery(postcode, r.URL.Query())
+	distances := len(origins) > 0
+
+	mapStores := make([]mapStore, len(stores))
+	for i, st := range stores {
+		mapStores[i] = mapStore{Store: st}
+	}
+	locations, labels := s.locateOrigins(origins)
+
+	s.render(w, "stores.html", struct {
+		Title               string
+		Regions             []storeRegion
+		Count               int
+		Map                 *storeMap
+		Distances           bool
+		Postcode            string
+		Unit                string
+		LocationDistancesOK bool
+	}{
+		Title:               "Cex stores",
+		Regions:             groupStores(stores, query, distances),
+		Count:               len(stores),
+		Map:                 newStoreMap(mapStores, locations, labels),
+		Distances:           distances,
+		Postcode:            postcode,
+		Unit:                unit.String(),
+		LocationDistancesOK: s.cex.LocationDistancesOK(),
+	})
+}
+
+// StorePage shows a store, with its region, closing time, distance
+// from the "postcode" parameter and location, the other stores in its
+// region and a search form scoped to the items held by the store.
+//
+// As store ids are only known for searches with a postcode, the search
+// is from the postcode or, if there is none, from the store itself.
+func (s *server) StorePage(w http.ResponseWriter, r *http.Request) {
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid store id", http.StatusBadRequest)
+		return
+	}
+	stores, postcode, origins, unit, ok := s.storeList(w, r.URL.Query())
+	if !ok {
+		return
+	}
+	i := slices.IndexFunc(stores, func(st location.StoreWithDistance) bool { return st.StoreID == id })
+	if i < 0 {
+		http.Error(w, "store not found: "+strconv.Itoa(id), http.StatusNotFound)
+		return
+	}
+	store := stores[i]
+	query := storesQuery(postcode, r.URL.Query())
+
+	var nearby []storeEntry
+	for _, region := range groupStores(stores, query, len(origins) > 0) {
+		if region.Region != store.RegionName {
+			continue
+		}
+		nearby = slices.DeleteFunc(region.Stores, func(e storeEntry) bool { return e.Store.StoreID == id })
+	}
+
+	searchPostcode := postcode
+	if searchPostcode == "" {
+		searchPostcode = strconv.FormatFloat(store.Latitude, 'f', 4, 64) + "," + strconv.FormatFloat(store.Longitude, 'f', 4, 64)
+	}
+	locations, labels := s.locateOrigins(origins)
+
+	s.render(w, "store.html", struct {
+		Title               string
+		Store               location.StoreWithDistance
+		Anchor              string
+		Map                 *storeMap
+		Nearby              []storeEntry
+		DirectoryLink       string
+		SearchPostcode      string
+		Units               string
+		Postcode            string
+		Unit                string
+		LocationDistancesOK bool
+	}{
+		Title:               store.StoreName,
+		Store:               store,
+		Anchor:              storeAnchor(store),
+		Map:                 newStoreMap([]mapStore{{Store: store}}, locations, labels),
+		Nearby:              nearby,
+		DirectoryLink:       "./stores" + query,
+		SearchPostcode:      searchPostcode,
+		Units:               r.URL.Query().Get("units"),
+		Postcode:            postcode,
+		Unit:                unit.String(),
+		LocationDistancesOK: s.cex.LocationDistancesOK(),
+	})
+}
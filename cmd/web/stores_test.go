@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/rorycl/cexfind"
+	"github.com/rorycl/cexfind/location"
+)
+
+var directoryStores = []location.StoreWithDistance{
+	{StoreID: 2, StoreName: "Brighton", RegionName: "South East", Latitude: 50.8225, Longitude: -0.1372, ClosingTime: "17:30"},
+	{StoreID: 1, StoreName: "Tottenham Court Road", RegionName: "London", Latitude: 51.5171, Longitude: -0.1312, ClosingTime: "19:00"},
+	{StoreID: 3, StoreName: "Oxford Street", RegionName: "London", Latitude: 51.5149, Longitude: -0.1443},
+	{StoreID: 4, StoreName: "Pop Up", Latitude: 51.0, Longitude: -1.0},
+}
+
+// TestGroupStores tests grouping stores by region
+func TestGroupStores(t *testing.T) {
+	tests := []struct {
+		byDistance bool
+		want       []string
+	}{
+		{false, []string{"London:1,3", "South East:2", "other:4"}},
+		{true, []string{"South East:2", "London:1,3", "other:4"}},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			got := []string{}
+			for _, r := range groupStores(directoryStores, "?units=km", tt.byDistance) {
+				ids := []string{}
+				for _, e := range r.Stores {
+					ids = append(ids, fmt.Sprint(e.Store.StoreID))
+				}
+				got = append(got, r.Region+":"+strings.Join(ids, ","))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v want %v", got, tt.want)
+			}
+		})
+	}
+	if got, want := groupStores(directoryStores, "?units=km", false)[0].Stores[0].Link, "./stores/1?units=km"; got != want {
+		t.Errorf("got link %s want %s", got, want)
+	}
+}
+
+// TestStorePages tests the store directory and store pages
+func TestStorePages(t *testing.T) {
+
+	s := newServer()
+	s.DirFS = &fileSystem{}
+	s.DirFS.TplFS = os.DirFS("templates")
+	s.storeLister = func(cex *cexfind.CexFind, origins ...location.Origin) ([]location.StoreWithDistance, error) {
+		return append([]location.StoreWithDistance{}, directoryStores...), nil
+	}
+
+	tests := []struct {
+		path       string
+		id         string
+		statusCode int
+		contains   []string
+		excludes   []string
+	}{
+		{
+			path:       "/stores",
+			statusCode: http.StatusOK,
+			contains:   []string{"4 stores;", "<h3>London</h3>", `<a href="./stores/1">Tottenham Court Road</a>`, "closes 19:00", "<h3>other</h3>"},
+			excludes:   []string{"nearest first"},
+		},
+		{
+			path:       "/stores?units=km",
+			statusCode: http.StatusOK,
+			contains:   []string{`<a href="./stores/2?units=km">Brighton</a>`},
+		},
+		{
+			path:       "/stores?units=furlongs",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			path:       "/stores/1",
+			id:         "1",
+			statusCode: http.StatusOK,
+			contains: []string{
+				`<base href="../">`,
+				"closes today at 19:00",
+				`name="store" value="1"`,
+				`name="postcode" value="51.5171,-0.1312"`,
+				`<a href="./stores/3">Oxford Street</a>`,
+			},
+			excludes: []string{`<a href="./stores/2">`},
+		},
+		{
+			path:       "/stores/3?postcode=51.5,-0.1",
+			id:         "3",
+			statusCode: http.StatusOK,
+			contains:   []string{"<th>opening times</th><td>not known</td>", `name="postcode" value="51.5,-0.1"`, `<a href="./stores/1?postcode=51.5%2C-0.1">`},
+		},
+		{
+			path:       "/stores/9",
+			id:         "9",
+			statusCode: http.StatusNotFound,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://example.com"+tt.path, nil)
+			w := httptest.NewRecorder()
+			if tt.id == "" {
+				s.StoreDirectory(w, r)
+			} else {
+				s.StorePage(w, mux.SetURLVars(r, map[string]string{"id": tt.id}))
+			}
+			if got, want := w.Code, tt.statusCode; got != want {
+				t.Fatalf("got status %d want %d: %s", got, want, w.Body.String())
+			}
+			for _, c := range tt.contains {
+				if !strings.Contains(w.Body.String(), c) {
+					t.Errorf("expected body to contain %q, got\n%s", c, w.Body.String())
+				}
+			}
+			for _, c := range tt.excludes {
+				if strings.Contains(w.Body.String(), c) {
+					t.Errorf("expected body not to contain %q, got\n%s", c, w.Body.String())
+				}
+			}
+		})
+	}
+
+	// location service failures are upstream errors
+	s.storeLister = func(cex *cexfind.CexFind, origins ...location.Origin) ([]location.StoreWithDistance, error) {
+		return nil, errors.New("location error: postcodes.io error: 500 Internal Server Error")
+	}
+	w := httptest.NewRecorder()
+	s.StoreDirectory(w, httptest.NewRequest(http.MethodGet, "http://example.com/stores?postcode=NW1+6LG", nil))
+	if got, want := w.Code, http.StatusBadGateway; got != want {
+		t.Errorf("got status %d want %d", got, want)
+	}
+}
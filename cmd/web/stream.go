@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rorycl/cexfind"
+	"github.com/rorycl/cexfind/cmd"
+)
+
+// errShuttingDown reports a streamed search ended by the server
+// shutting down.
+var errShuttingDown = errors.New("the server is restarting, please search again shortly")
+
+// Stream starts a streamed search from a "search" form submission. The
+// htmx partial returned connects to the Events endpoint with the htmx
+// sse extension, into which the results of each query are swapped as
+// they arrive.
+func (s *server) Stream(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Print("endpoint only accepts POST requests, got", r.Method)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		log.Print("stream endpoint form reading error", err)
+		s.writeError(w, r, limitStatus(err, http.StatusBadRequest), err)
+		return
+	}
+
+	search, err := s.parseSearch(r.PostForm)
+	switch {
+	case errors.Is(err, errNoQuery):
+		log.Printf("cex stream : %+v %v", search.Form, err)
+		w.WriteHeader(http.StatusNoContent)
+		fmt.Fprint(w, "no query found")
+		return
+	case errors.Is(err, errTooManyQueries):
+		log.Printf("cex stream : %+v %v", search.Form, err)
+		s.writeError(w, r, http.StatusRequestEntityTooLarge, err)
+		return
+	case err != nil:
+		log.Printf("cex stream : %+v %v", search.Form, err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err)
+		return
+	}
+
+	view, err := parseView(r.PostForm)
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	// push the search terms and view to the url
+	w.Header().Set("HX-Push-Url", s.BaseURL+"/?"+search.urlQuery()+view.urlQuery())
+
+	data := struct {
+		EventsURL string
+		Queries   string
+	}{
+		"./events?" + search.urlQuery() + view.urlQuery(),
+		strings.Join(search.Queries, cmd.QuerySplitChar+" "),
+	}
+	s.render(w, "partial-stream.html", data)
+}
+
+// Events streams the results of a search as server sent events. An
+// html fragment is sent in a "query" event as each query completes,
+// followed by a "summary" event and a "done" event. The queries are
+// cancelled if the client disconnects.
+func (s *server) Events(w http.ResponseWriter, r *http.Request) {
+
+	search, err := s.parseSearch(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(limitStatus(err, http.StatusBadRequest))
+		fmt.Fprint(w, err)
+		return
+	}
+	view, err := parseView(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err)
+		return
+	}
+
+	tpl, err := s.templates.get(s.tplFS())
+	if err != nil {
+		log.Printf("events template error: %v", err)
+		http.Error(w, "template problem", http.StatusInternalServerError)
+		return
+	}
+
+	release, ok := s.acquireUpstream(w, r, len(search.Queries))
+	if !ok {
+		return
+	}
+	defer release()
+
+	rc := http.NewResponseController(w)
+	// the stream may run for longer than the server write timeout
+	if err := rc.SetWriteDeadline(time.Now().Add(s.StreamTimeout)); err != nil {
+		log.Printf("events write deadline error: %v", err)
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		log.Printf("events flush error: %v", err)
+		return
+	}
+
+	summary := struct {
+		Count    int
+		Queries  string
+		Duration time.Duration
+		Err      error
+		View     *viewData
+	}{Queries: strings.Join(search.Queries, cmd.QuerySplitChar+" ")}
+
+	// the request context is cancelled if the client disconnects,
+	// which cancels the upstream queries, as does the server shutting
+	// down
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	stop := context.AfterFunc(s.draining.ctx, cancel)
+	defer stop()
+
+	start := time.Now()
+	found := []cexfind.Box{}
+	results, err := s.streamer(s.cex, ctx, search.Queries, search.Form.Strict, search.Origins...)
+	if err != nil {
+		summary.Err = err
+		results = closedResults()
+	}
+	var staleAge time.Duration
+	for qr := range results {
+		setDistanceUnit(qr.Boxes, search.Unit)
+		summary.Count += len(qr.Boxes)
+		found = append(found, qr.Boxes...)
+		// results from the stale cache are shown with a notice rather
+		// than an error
+		query := queryData{QueryResult: qr}
+		age, stale, err := splitStale(qr.Err)
+		if stale {
+			query.Err, query.Stale = err, staleBanner(age)
+			staleAge = max(staleAge, age)
+		}
+		s.history.recordLive(qr.Boxes, stale)
+		if query.Err != nil {
+			summary.Err = errors.Join(summary.Err, query.Err)
+		}
+		if err := writeEvent(w, tpl, "query", "partial-query.html", query); err != nil {
+			log.Printf("events write error: %v", err)
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+	if r.Context().Err() != nil {
+		log.Print("events client disconnected")
+		return
+	}
+	if ctx.Err() != nil {
+		summary.Err = errors.Join(summary.Err, errShuttingDown)
+	}
+	if summary.Count == 0 && summary.Err == nil {
+		summary.Err = cexfind.ErrNoResults
+	}
+
+	// keep the results for sorting and filtering, which replaces the
+	// streamed results, immediately if a view was chosen
+	if ctx.Err() == nil && len(found) > 0 {
+		found = mergeBoxes(found)
+		s.results.putStale(search.urlQuery(), found, staleAge)
+		summary.View = newViewData(search, view, found, "#stream-results")
+		summary.View.Apply = view.urlQuery() != ""
+		summary.View.Map = s.newResultsMap(found, search.Origins, search.Unit)
+		summary.View.Saving = s.canSave(r)
+		if staleAge > 0 {
+			summary.View.Stale = staleBanner(staleAge)
+		}
+	}
+	summary.Duration = time.Since(start).Round(time.Millisecond)
+	if err := writeEvent(w, tpl, "summary", "partial-summary.html", summary); err != nil {
+		log.Printf("events write error: %v", err)
+		return
+	}
+	fmt.Fprint(w, "event: done\ndata: done\n\n")
+	rc.Flush()
+}
+
+// queryData is the result of a streamed query shown with the
+// partial-query template, with the notice of results from the stale
+// cache.
+type queryData struct {
+	cexfind.QueryResult
+	Stale string
+}
+
+// closedResults returns a closed QueryResult channel.
+func closedResults() <-chan cexfind.QueryResult {
+	c := make(chan cexfind.QueryResult)
+	close(c)
+	return c
+}
+
+// writeEvent writes a server sent event of the named type, with the
+// output of the named template as its data.
+func writeEvent(w io.Writer, tpl *templates, event, name string, data any) error {
+	var buf bytes.Buffer
+	if err := tpl.execute(&buf, name, data); err != nil {
+		return err
+	}
+	var ev strings.Builder
+	fmt.Fprintf(&ev, "event: %s\n", event)
+	for line := range strings.Lines(buf.String()) {
+		fmt.Fprintf(&ev, "data: %s\n", strings.TrimRight(line, "\r\n"))
+	}
+	ev.WriteString("\n")
+	_, err := io.WriteString(w, ev.String())
+	return err
+}
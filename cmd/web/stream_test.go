@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rorycl/cexfind"
+	"github.com/rorycl/cexfind/location"
+	"github.com/shopspring/decimal"
+)
+
+// TestStream tests the POST which starts a streamed search
+func TestStream(t *testing.T) {
+
+	s := newServer()
+	s.DirFS = &fileSystem{}
+	s.DirFS.TplFS = os.DirFS("templates")
+
+	tt := []struct {
+		name       string
+		input      string
+		statusCode int
+		contains   string
+	}{
+		{
+			name:       "succeed post",
+			input:      "query=abc&query=def&postcode=S10+1LT&strict=false",
+			statusCode: http.StatusOK,
+			contains:   `sse-connect="./events?strict=false&amp;postcode=S10&#43;1LT&amp;query=abc&amp;query=def"`,
+		},
+		{
+			name:       "fail post query too short",
+			input:      "query=ab&strict=false",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "fail no POST body",
+			input:      "",
+			statusCode: http.StatusNoContent,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "http://example.com/stream", strings.NewReader(tc.input))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+
+			s.Stream(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := res.StatusCode, tc.statusCode; got != want {
+				t.Fatalf("expected status %d, got %d", want, got)
+			}
+			if !strings.Contains(string(body), tc.contains) {
+				t.Errorf("expected body to contain %s, got %s", tc.contains, body)
+			}
+		})
+	}
+}
+
+// TestEvents tests the server sent events stream; note that
+// cexfind.SearchStream is swapped out
+func TestEvents(t *testing.T) {
+
+	s := newServer()
+	s.DirFS = &fileSystem{}
+	s.DirFS.TplFS = os.DirFS("templates")
+
+	var streamErr error
+	s.streamer = func(cf *cexfind.CexFind, ctx context.Context, queries []string, strict bool, origins ...location.Origin) (<-chan cexfind.QueryResult, error) {
+		if streamErr != nil {
+			return nil, streamErr
+		}
+		c := make(chan cexfind.QueryResult, 2)
+		c <- cexfind.QueryResult{Query: "abc", Boxes: []cexfind.Box{
+			cexfind.Box{Model: "1a", Name: "1a name", ID: "id1", Price: decimal.NewFromInt(1)},
+			cexfind.Box{Model: "1b", Name: "1b name", ID: "id2", Price: decimal.NewFromInt(2)},
+		}}
+		c <- cexfind.QueryResult{Query: "def", Err: errors.New(`"def": http call error`)}
+		close(c)
+		return c, nil
+	}
+
+	tt := []struct {
+		name       string
+		input      string
+		streamErr  error
+		statusCode int
+		contains   []string
+	}{
+		{
+			name:       "succeed",
+			input:      "query=abc&query=def",
+			statusCode: http.StatusOK,
+			contains: []string{
+				"event: query\ndata: <div class=\"query-results\">\ndata: <p class=\"query\">abc</p>",
+				"data: <p class=\"error\">Error: &#34;def&#34;: http call error</p>",
+				"event: summary\n",
+				"data: <p class=\"summary\">2 items found for abc; def in ",
+				"event: done\ndata: done\n\n",
+				"data: <form class=\"view-controls\" action=\"./results\" method=\"get\" hx-get=\"./view\" hx-trigger=\"change, submit\" hx-target=\"#stream-results\">",
+			},
+		},
+		{
+			name:       "succeed with view",
+			input:      "query=abc&query=def&sort=price",
+			statusCode: http.StatusOK,
+			contains: []string{
+				"hx-trigger=\"change, load, submit\"",
+				"data: <option value=\"price\" selected>price</option>",
+			},
+		},
+		{
+			name:       "location error",
+			input:      "query=abc&postcode=QQ1+1AA",
+			streamErr:  errors.New("location error: location not found"),
+			statusCode: http.StatusOK,
+			contains: []string{
+				"event: summary\ndata: \ndata: <p class=\"error\">Error: location error: location not found</p>",
+				"event: done\n",
+			},
+		},
+		{
+			name:       "fail invalid query",
+			input:      "query=ab",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "fail invalid view",
+			input:      "query=abc&sort=colour",
+			statusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			streamErr = tc.streamErr
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/events?"+tc.input, nil)
+			w := httptest.NewRecorder()
+
+			s.Events(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := res.StatusCode, tc.statusCode; got != want {
+				t.Fatalf("expected status %d, got %d", want, got)
+			}
+			if tc.statusCode != http.StatusOK {
+				return
+			}
+			if got, want := res.Header.Get("Content-Type"), "text/event-stream"; got != want {
+				t.Errorf("got content type %s want %s", got, want)
+			}
+			for _, c := range tc.contains {
+				if !strings.Contains(string(body), c) {
+					t.Errorf("expected body to contain %q, got\n%s", c, body)
+				}
+			}
+		})
+	}
+}
+
+// TestEventsDisconnect checks that the search is cancelled when the
+// client disconnects
+func TestEventsDisconnect(t *testing.T) {
+
+	s := newServer()
+	s.DirFS = &fileSystem{}
+	s.DirFS.TplFS = os.DirFS("templates")
+
+	cancelled := make(chan struct{})
+	s.streamer = func(cf *cexfind.CexFind, ctx context.Context, queries []string, strict bool, origins ...location.Origin) (<-chan cexfind.QueryResult, error) {
+		c := make(chan cexfind.QueryResult)
+		go func() {
+			defer close(c)
+			<-ctx.Done()
+			close(cancelled)
+		}()
+		return c, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/events?query=abc", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		s.Events(w, r)
+		close(done)
+	}()
+	cancel()
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("search was not cancelled")
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("events handler did not return")
+	}
+	if strings.Contains(w.Body.String(), "event: summary") {
+		t.Error("expected no summary after disconnect")
+	}
+}
+
+// TestEventsShutdown checks that a stream is ended with a summary when
+// the server shuts down
+func TestEventsShutdown(t *testing.T) {
+
+	s := newServer()
+	s.DirFS = &fileSystem{}
+	s.DirFS.TplFS = os.DirFS("templates")
+
+	started := make(chan struct{})
+	s.streamer = func(cf *cexfind.CexFind, ctx context.Context, queries []string, strict bool, origins ...location.Origin) (<-chan cexfind.QueryResult, error) {
+		c := make(chan cexfind.QueryResult)
+		go func() {
+			defer close(c)
+			close(started)
+			<-ctx.Done()
+		}()
+		return c, nil
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/events?query=abc", nil)
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		s.Events(w, r)
+		close(done)
+	}()
+	<-started
+	s.draining.start()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("events handler did not return")
+	}
+	for _, c := range []string{"event: summary\n", "the server is restarting", "event: done\n"} {
+		if !strings.Contains(w.Body.String(), c) {
+			t.Errorf("expected body to contain %q, got\n%s", c, w.Body.String())
+		}
+	}
+}
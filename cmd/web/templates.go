@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"sync"
+)
+
+// the shared template set is made up of the layout and the partials;
+// every other template is a page, parsed with a copy of the shared set
+// so that it can define the layout's blocks
+const (
+	layoutTemplate  = "layout.html"
+	partialTemplate = "partial-*.html"
+	pageTemplate    = "*.html"
+)
+
+// templates are the parsed templates of the web server
+type templates struct {
+	shared *template.Template
+	pages  map[string]*template.Template
+}
+
+// parseTemplates parses the layout, partials and pages in fsys. The
+// pages are cloned from the shared set before it is executed, as
+// html/template does not allow cloning afterwards.
+func parseTemplates(fsys fs.FS) (*templates, error) {
+	if fsys == nil {
+		return nil, errors.New("no template filesystem")
+	}
+	shared, err := template.ParseFS(fsys, layoutTemplate, partialTemplate)
+	if err != nil {
+		return nil, err
+	}
+	files, err := fs.Glob(fsys, pageTemplate)
+	if err != nil {
+		return nil, err
+	}
+	t := &templates{shared: shared, pages: map[string]*template.Template{}}
+	for _, f := range files {
+		if isPartial, _ := path.Match(partialTemplate, f); isPartial || f == layoutTemplate {
+			continue
+		}
+		page, err := shared.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if page, err = page.ParseFS(fsys, f); err != nil {
+			return nil, err
+		}
+		t.pages[f] = page
+	}
+	return t, nil
+}
+
+// execute writes the named page or partial to w.
+func (t *templates) execute(w io.Writer, name string, data any) error {
+	if page, ok := t.pages[name]; ok {
+		return page.ExecuteTemplate(w, name, data)
+	}
+	return t.shared.ExecuteTemplate(w, name, data)
+}
+
+// templateCache parses the templates once and caches them, unless
+// reload is set, as it is in development, when they are parsed from
+// disk each time they are used so that edits show without a restart.
+type templateCache struct {
+	reload bool
+	mu     sync.Mutex
+	parsed *templates
+}
+
+// get returns the templates, parsing them from fsys if necessary.
+// Parsing errors are not cached.
+func (c *templateCache) get(fsys fs.FS) (*templates, error) {
+	if c.reload {
+		return parseTemplates(fsys)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.parsed != nil {
+		return c.parsed, nil
+	}
+	t, err := parseTemplates(fsys)
+	if err != nil {
+		return nil, err
+	}
+	c.parsed = t
+	return t, nil
+}
+
+// tplFS returns the template filesystem, if it has been set up.
+func (s *server) tplFS() fs.FS {
+	if s.DirFS == nil {
+		return nil
+	}
+	return s.DirFS.TplFS
+}
+
+// executeTemplate executes the named page or partial template to a
+// buffer, so that a template error does not leave a partly written
+// response.
+func (s *server) executeTemplate(name string, data any) (*bytes.Buffer, error) {
+	t, err := s.templates.get(s.tplFS())
+	if err != nil {
+		return nil, fmt.Errorf("template parsing problem: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := t.execute(&buf, name, data); err != nil {
+		return nil, fmt.Errorf("template writing problem: %w", err)
+	}
+	return &buf, nil
+}
+
+// render writes the named page or partial template as an html
+// response, or a 500 Internal Server Error if the template fails.
+func (s *server) render(w http.ResponseWriter, name string, data any) {
+	s.renderStatus(w, http.StatusOK, name, data)
+}
+
+// renderStatus is the same as render but with the http status.
+func (s *server) renderStatus(w http.ResponseWriter, status int, name string, data any) {
+	buf, err := s.executeTemplate(name, data)
+	if err != nil {
+		log.Printf("%s: %v", name, err)
+		http.Error(w, "template problem", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if _, err := buf.WriteTo(w); err != nil {
+		log.Printf("%s: write error: %v", name, err)
+	}
+}
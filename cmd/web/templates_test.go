@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// TestTemplates checks the embedded templates parse, with each page
+// rendered in the layout
+func TestTemplates(t *testing.T) {
+
+	fsys, err := NewFileSystem(false, "templates", "static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl, err := parseTemplates(fsys.TplFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tpl.pages["home.html"]; !ok {
+		t.Errorf("expected home.html page, got %v", tpl.pages)
+	}
+	for _, name := range []string{"layout.html", "partial-results.html", "partial-error.html"} {
+		if _, ok := tpl.pages[name]; ok {
+			t.Errorf("%s should not be a page", name)
+		}
+	}
+}
+
+// TestTemplateCache tests templates are parsed once in production and
+// on each use in development
+func TestTemplateCache(t *testing.T) {
+
+	files := func(title string) fstest.MapFS {
+		return fstest.MapFS{
+			"layout.html":       {Data: []byte(`{{ define "layout" }}<title>` + title + `</title>{{ block "content" . }}{{ end }}{{ end }}`)},
+			"partial-item.html": {Data: []byte(`<p>{{ . }}</p>`)},
+			"page.html":         {Data: []byte(`{{ template "layout" . }}{{ define "content" }}{{ template "partial-item.html" . }}{{ end }}`)},
+		}
+	}
+
+	tests := []struct {
+		reload bool
+		want   string
+	}{
+		{false, "<title>one</title><p>item</p>"},
+		{true, "<title>two</title><p>item</p>"},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			c := &templateCache{reload: tt.reload}
+			if _, err := c.get(files("one")); err != nil {
+				t.Fatal(err)
+			}
+			tpl, err := c.get(files("two"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var b strings.Builder
+			if err := tpl.execute(&b, "page.html", "item"); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := b.String(), tt.want; got != want {
+				t.Errorf("got %q want %q", got, want)
+			}
+		})
+	}
+}
+
+// TestTemplateErrors checks template errors give 500 responses
+func TestTemplateErrors(t *testing.T) {
+
+	tests := []struct {
+		files fstest.MapFS
+	}{
+		{ // parse error
+			fstest.MapFS{
+				"layout.html": {Data: []byte(`{{ define "layout" }}{{ end }}`)},
+				"home.html":   {Data: []byte(`{{ .Unclosed `)},
+			},
+		},
+		{ // execution error
+			fstest.MapFS{
+				"layout.html": {Data: []byte(`{{ define "layout" }}{{ .NoSuchField }}{{ end }}`)},
+				"home.html":   {Data: []byte(`{{ template "layout" . }}`)},
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			s := newServer()
+			s.DirFS = &fileSystem{}
+			s.DirFS.TplFS = tt.files
+
+			w := httptest.NewRecorder()
+			s.Home(w, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+			if got, want := w.Code, http.StatusInternalServerError; got != want {
+				t.Errorf("got status %d want %d", got, want)
+			}
+			if strings.Contains(w.Body.String(), "<") {
+				t.Errorf("expected no partial page output, got %q", w.Body.String())
+			}
+		})
+	}
+
+	// the templates are usable once fixed
+	s := newServer()
+	s.DirFS = &fileSystem{}
+	s.DirFS.TplFS = tests[0].files
+	s.Home(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	s.DirFS.TplFS = os.DirFS("templates")
+	w := httptest.NewRecorder()
+	s.Home(w, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("got status %d want %d after fixing templates", got, want)
+	}
+}
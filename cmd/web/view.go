@@ -0,0 +1,485 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/schema"
+	"github.com/shopspring/decimal"
+
+	"github.com/rorycl/cexfind"
+	"github.com/rorycl/cexfind/location"
+)
+
+// errView reports invalid sorting or filtering parameters.
+var errView = errors.New("view error")
+
+// viewSorts are the orders in which results may be sorted. Results are
+// always grouped by model, with the models ordered by their first item.
+var viewSorts = []string{"model", "price", "price-desc", "distance", "grade"}
+
+// resultView is the sorting and filtering of a result set chosen with
+// the controls on the results page. The maximum distance is in the
+// search's distance unit. Store is the id of a store holding the items
+// shown, which is only known for searches with a postcode.
+type resultView struct {
+	Sort     string   `schema:"sort"`
+	Category []string `schema:"category"`
+	Grade    []string `schema:"grade"`
+	MinPrice string   `schema:"min"`
+	MaxPrice string   `schema:"max"`
+	Distance float64  `schema:"distance"`
+	Collapse bool     `schema:"collapse"`
+	Store    int      `schema:"store"`
+
+	minPrice, maxPrice decimal.Decimal
+}
+
+// parseView decodes and checks the view parameters in vals, which may
+// also hold search parameters.
+func parseView(vals url.Values) (resultView, error) {
+	var v resultView
+	var decoder = schema.NewDecoder()
+	decoder.IgnoreUnknownKeys(true)
+	if err := decoder.Decode(&v, vals); err != nil {
+		return v, fmt.Errorf("%w: %v", errView, err)
+	}
+	if v.Sort == "" {
+		v.Sort = viewSorts[0]
+	}
+	if !slices.Contains(viewSorts, v.Sort) {
+		return v, fmt.Errorf("%w: unknown sort %q", errView, v.Sort)
+	}
+	var err error
+	if v.MinPrice != "" {
+		if v.minPrice, err = decimal.NewFromString(v.MinPrice); err != nil {
+			return v, fmt.Errorf("%w: minimum price %q invalid", errView, v.MinPrice)
+		}
+	}
+	if v.MaxPrice != "" {
+		if v.maxPrice, err = decimal.NewFromString(v.MaxPrice); err != nil {
+			return v, fmt.Errorf("%w: maximum price %q invalid", errView, v.MaxPrice)
+		}
+	}
+	if v.Distance < 0 || math.IsNaN(v.Distance) {
+		return v, fmt.Errorf("%w: distance must be positive", errView)
+	}
+	return v, nil
+}
+
+// values returns the parameters of the view which are not the
+// defaults.
+func (v resultView) values() url.Values {
+	vals := url.Values{}
+	if v.Sort != "" && v.Sort != viewSorts[0] {
+		vals.Set("sort", v.Sort)
+	}
+	for _, c := range v.Category {
+		vals.Add("category", c)
+	}
+	for _, g := range v.Grade {
+		vals.Add("grade", g)
+	}
+	if v.MinPrice != "" {
+		vals.Set("min", v.MinPrice)
+	}
+	if v.MaxPrice != "" {
+		vals.Set("max", v.MaxPrice)
+	}
+	if v.Distance > 0 {
+		vals.Set("distance", fmt.Sprint(v.Distance))
+	}
+	if v.Collapse {
+		vals.Set("collapse", "true")
+	}
+	if v.Store > 0 {
+		vals.Set("store", strconv.Itoa(v.Store))
+	}
+	return vals
+}
+
+// urlQuery returns the view as a url query string to be appended to a
+// search url query, or an empty string for the default view.
+func (v resultView) urlQuery() string {
+	vals := v.values()
+	if len(vals) == 0 {
+		return ""
+	}
+	return "&" + vals.Encode()
+}
+
+// keep reports if the box is shown in the view.
+func (v resultView) keep(b cexfind.Box, unit location.Unit) bool {
+	switch {
+	case len(v.Category) > 0 && !slices.Contains(v.Category, b.Category):
+		return false
+	case len(v.Grade) > 0 && !slices.Contains(v.Grade, b.Grade()):
+		return false
+	case v.MinPrice != "" && b.Price.LessThan(v.minPrice):
+		return false
+	case v.MaxPrice != "" && b.Price.GreaterThan(v.maxPrice):
+		return false
+	case v.Distance > 0 && nearestDistance(b, unit) > v.Distance:
+		return false
+	case v.Store > 0 && !slices.ContainsFunc(b.Stores, func(st location.StoreWithDistance) bool { return st.StoreID == v.Store }):
+		return false
+	}
+	return true
+}
+
+// compare orders boxes for the view's sort.
+func (v resultView) compare(unit location.Unit) func(a, b cexfind.Box) int {
+	byPrice := func(a, b cexfind.Box) int { return a.Price.Compare(b.Price) }
+	byGrade := func(a, b cexfind.Box) int {
+		// ungraded boxes sort last
+		return cmp.Compare(a.Grade()+"~", b.Grade()+"~")
+	}
+	return func(a, b cexfind.Box) int {
+		switch v.Sort {
+		case "price":
+			return cmp.Or(byPrice(a, b), cmp.Compare(a.Model, b.Model), byGrade(a, b))
+		case "price-desc":
+			return cmp.Or(byPrice(b, a), cmp.Compare(a.Model, b.Model), byGrade(a, b))
+		case "distance":
+			return cmp.Or(cmp.Compare(nearestDistance(a, unit), nearestDistance(b, unit)), byPrice(a, b))
+		case "grade":
+			return cmp.Or(byGrade(a, b), byPrice(a, b), cmp.Compare(a.Model, b.Model))
+		}
+		return cmp.Or(cmp.Compare(a.Model, b.Model), byPrice(a, b), byGrade(a, b))
+	}
+}
+
+// nearestDistance returns the distance in unit of the nearest store
+// holding the box, or +Inf if it isn't known.
+func nearestDistance(b cexfind.Box, unit location.Unit) float64 {
+	s, ok := b.NearestStore()
+	if !ok {
+		return math.Inf(1)
+	}
+	if unit == location.Kilometres {
+		return s.DistanceKm
+	}
+	return s.DistanceMiles
+}
+
+// modelGroup is the boxes of a model shown in a view.
+type modelGroup struct {
+	Model string
+	Boxes []cexfind.Box
+	From  decimal.Decimal // the lowest price
+}
+
+// apply filters and sorts the boxes, grouping them by model in the
+// order of each model's first box.
+func (v resultView) apply(boxes []cexfind.Box, unit location.Unit) []modelGroup {
+	kept := slices.DeleteFunc(slices.Clone(boxes), func(b cexfind.Box) bool {
+		return !v.keep(b, unit)
+	})
+	slices.SortStableFunc(kept, v.compare(unit))
+
+	groups := []modelGroup{}
+	index := map[string]int{}
+	for _, b := range kept {
+		i, ok := index[b.Model]
+		if !ok {
+			i = len(groups)
+			index[b.Model] = i
+			groups = append(groups, modelGroup{Model: b.Model, From: b.Price})
+		}
+		groups[i].Boxes = append(groups[i].Boxes, b)
+		if b.Price.LessThan(groups[i].From) {
+			groups[i].From = b.Price
+		}
+	}
+	return groups
+}
+
+// facet is a filter option with the number of boxes matching it.
+type facet struct {
+	Value   string
+	Count   int
+	Checked bool
+}
+
+// facets counts the boxes by the value returned by key, sorted by
+// value, marking those chosen.
+func facets(boxes []cexfind.Box, key func(cexfind.Box) string, chosen []string) []facet {
+	counts := map[string]int{}
+	for _, b := range boxes {
+		if k := key(b); k != "" {
+			counts[k]++
+		}
+	}
+	fs := []facet{}
+	for k, n := range counts {
+		fs = append(fs, facet{Value: k, Count: n, Checked: slices.Contains(chosen, k)})
+	}
+	slices.SortFunc(fs, func(a, b facet) int { return cmp.Compare(a.Value, b.Value) })
+	return fs
+}
+
+// viewData is the template data for the view controls and listing.
+type viewData struct {
+	Search     url.Values // the search, as hidden form fields
+	View       resultView
+	Sorts      []string
+	Categories []facet
+	Grades     []facet
+	Distances  bool   // if store distances are known
+	Unit       string // the distance unit
+	Target     string // the css selector of the element showing the view
+	Apply      bool   // apply the view when the controls load
+	Groups     []modelGroup
+	Shown      int
+	Total      int
+	Map        *resultsMap // the stores holding the results, if shown
+	Saving     bool        // if the search and view can be saved
+	Stale      string      // the notice of results from the stale cache
+	StoreName  string      // the name of the store of the view, if any
+}
+
+// newViewData returns the view of boxes, a search result set, shown in
+// the element selected by target.
+func newViewData(search searchRequest, v resultView, boxes []cexfind.Box, target string) *viewData {
+	searchVals, _ := url.ParseQuery(search.urlQuery())
+	d := &viewData{
+		Search:     searchVals,
+		View:       v,
+		Sorts:      viewSorts,
+		Categories: facets(boxes, func(b cexfind.Box) string { return b.Category }, v.Category),
+		Grades:     facets(boxes, cexfind.Box.Grade, v.Grade),
+		Distances: slices.ContainsFunc(boxes, func(b cexfind.Box) bool {
+			_, ok := b.NearestStore()
+			return ok
+		}),
+		Unit:   search.Unit.String(),
+		Target: target,
+		Groups: v.apply(boxes, search.Unit),
+		Total:  len(boxes),
+	}
+	for _, g := range d.Groups {
+		d.Shown += len(g.Boxes)
+	}
+	if v.Store > 0 {
+		d.StoreName = fmt.Sprintf("store %d", v.Store)
+		for _, b := range boxes {
+			if i := slices.IndexFunc(b.Stores, func(st location.StoreWithDistance) bool { return st.StoreID == v.Store }); i >= 0 && b.Stores[i].StoreName != "" {
+				d.StoreName = b.Stores[i].StoreName
+				break
+			}
+		}
+	}
+	return d
+}
+
+// values returns the parameters of the search and view.
+func (d *viewData) values() url.Values {
+	vals := url.Values{}
+	for k, v := range d.Search {
+		vals[k] = v
+	}
+	for k, v := range d.View.values() {
+		vals[k] = v
+	}
+	return vals
+}
+
+// DownloadLink returns the link to download the view of the search in
+// format, such as "csv".
+func (d *viewData) DownloadLink(format string) string {
+	vals := d.values()
+	vals.Set("format", format)
+	return "./results?" + vals.Encode()
+}
+
+// SaveQuery returns the url query of the search and view, to be saved.
+func (d *viewData) SaveQuery() string {
+	return d.values().Encode()
+}
+
+// mergeBoxes returns boxes without duplicates, such as those found by
+// more than one query.
+func mergeBoxes(boxes []cexfind.Box) []cexfind.Box {
+	seen := map[string]bool{}
+	return slices.DeleteFunc(slices.Clone(boxes), func(b cexfind.Box) bool {
+		if seen[b.ID] {
+			return true
+		}
+		seen[b.ID] = true
+		return false
+	})
+}
+
+// resultsCache keeps recent result sets by search so that they can be
+// sorted and filtered without searching again.
+type resultsCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]cachedResults
+	now     func() time.Time
+}
+
+type cachedResults struct {
+	boxes   []cexfind.Box
+	stored  time.Time
+	fetched time.Time // when stale results were fetched from Cex
+}
+
+func newResultsCache(size int, ttl time.Duration) *resultsCache {
+	return &resultsCache{
+		size:    size,
+		ttl:     ttl,
+		entries: map[string]cachedResults{},
+		now:     time.Now,
+	}
+}
+
+// put stores the result set of the search key, which should not be
+// changed afterwards as it is shared between requests, evicting expired
+// entries and then the oldest if the cache is full.
+func (c *resultsCache) put(key string, boxes []cexfind.Box) {
+	c.putStale(key, boxes, 0)
+}
+
+// putStale stores a result set as put, which if age is positive is from
+// the stale results cache of the library and is that old.
+func (c *resultsCache) putStale(key string, boxes []cexfind.Box, age time.Duration) {
+	if c.size < 1 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for k, e := range c.entries {
+		if now.Sub(e.stored) > c.ttl {
+			delete(c.entries, k)
+		}
+	}
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.size {
+		oldest := ""
+		for k, e := range c.entries {
+			if oldest == "" || e.stored.Before(c.entries[oldest].stored) {
+				oldest = k
+			}
+		}
+		delete(c.entries, oldest)
+	}
+	e := cachedResults{boxes: boxes, stored: now}
+	if age > 0 {
+		e.fetched = now.Add(-age)
+	}
+	c.entries[key] = e
+}
+
+// staleAge returns the age of the result set of the search key if it
+// is from the stale results cache.
+func (c *resultsCache) staleAge(key string) (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || e.fetched.IsZero() {
+		return 0, false
+	}
+	return c.now().Sub(e.fetched), true
+}
+
+// get returns the result set of the search key, if it has not
+// expired.
+func (c *resultsCache) get(key string) ([]cexfind.Box, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || c.now().Sub(e.stored) > c.ttl {
+		return nil, false
+	}
+	return e.boxes, true
+}
+
+// find returns the box with id from the most recently stored result
+// set holding it, together with the search key of that set and the
+// other grades of the box found in any result set.
+func (c *resultsCache) find(id string) (box cexfind.Box, key string, grades []cexfind.Box, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	var stored time.Time
+	for k, e := range c.entries {
+		if now.Sub(e.stored) > c.ttl {
+			continue
+		}
+		for _, b := range e.boxes {
+			if b.ID == id && (!ok || e.stored.After(stored)) {
+				box, key, stored, ok = b, k, e.stored, true
+			}
+		}
+	}
+	if !ok {
+		return box, key, nil, false
+	}
+	for _, e := range c.entries {
+		if now.Sub(e.stored) > c.ttl {
+			continue
+		}
+		grades = append(grades, otherGrades(box, e.boxes)...)
+	}
+	return box, key, mergeBoxes(grades), true
+}
+
+// View shows the last results of a search sorted and filtered by the
+// view parameters, in an htmx partial. The search is only made again,
+// counting towards the client's rate limit, if its results are no
+// longer cached.
+func (s *server) View(w http.ResponseWriter, r *http.Request) {
+
+	search, err := s.parseSearch(r.URL.Query())
+	if err != nil {
+		log.Printf("cex view : %+v %v", search.Form, err)
+		s.writeError(w, r, limitStatus(err, http.StatusBadRequest), err)
+		return
+	}
+	view, err := parseView(r.URL.Query())
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	key := search.urlQuery()
+	boxes, ok := s.results.get(key)
+	if !ok {
+		if !s.allowClient(w, r) {
+			return
+		}
+		release, ok := s.acquireUpstream(w, r, len(search.Queries))
+		if !ok {
+			return
+		}
+		boxes, err = s.searcher(s.cex, search.Queries, search.Form.Strict, search.Origins...)
+		release()
+		if err != nil && len(boxes) == 0 {
+			s.writeError(w, r, http.StatusBadGateway, err)
+			return
+		}
+		setDistanceUnit(boxes, search.Unit)
+		age, stale, _ := splitStale(err)
+		s.history.recordLive(boxes, stale)
+		s.results.putStale(key, boxes, age)
+	}
+
+	w.Header().Set("HX-Push-Url", s.BaseURL+"/?"+key+view.urlQuery())
+	data := newViewData(search, view, boxes, "")
+	data.Saving = s.canSave(r)
+	if age, ok := s.results.staleAge(key); ok {
+		data.Stale = staleBanner(age)
+	}
+	s.render(w, "partial-view.html", data)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/rorycl/cexfind"
+	"github.com/rorycl/cexfind/location"
+)
+
+// viewBoxes are boxes of two models for view tests; x1 is nearest
+var viewBoxes = []cexfind.Box{
+	{Model: "x390", Category: "Laptops", ID: "x1C", Price: decimal.NewFromInt(150),
+		Stores: []location.StoreWithDistance{{StoreID: 1, DistanceMiles: 2, DistanceKm: 3.2, Resolution: location.ResolutionPostcode}}},
+	{Model: "x390", Category: "Laptops", ID: "x2B", Price: decimal.NewFromInt(175),
+		Stores: []location.StoreWithDistance{{StoreID: 2, DistanceMiles: 20, DistanceKm: 32.2, Resolution: location.ResolutionPostcode}}},
+	{Model: "t480", Category: "Laptops", ID: "t1A", Price: decimal.NewFromInt(200)},
+	{Model: "t480", Category: "Spares", ID: "t2B", Price: decimal.NewFromInt(90),
+		Stores: []location.StoreWithDistance{{StoreID: 3, DistanceMiles: 8, DistanceKm: 12.9, Resolution: location.ResolutionPostcode}}},
+}
+
+// groupIDs summarises view groups as "model:id,id model:id"
+func groupIDs(groups []modelGroup) string {
+	var parts []string
+	for _, g := range groups {
+		ids := []string{}
+		for _, b := range g.Boxes {
+			ids = append(ids, b.ID)
+		}
+		parts = append(parts, g.Model+":"+strings.Join(ids, ","))
+	}
+	return strings.Join(parts, " ")
+}
+
+// TestResultViewApply tests sorting and filtering results
+func TestResultViewApply(t *testing.T) {
+
+	tests := []struct {
+		query string
+		unit  location.Unit
+		want  string
+	}{
+		{"", location.Miles, "t480:t2B,t1A x390:x1C,x2B"},
+		{"sort=price", location.Miles, "t480:t2B,t1A x390:x1C,x2B"},
+		{"sort=price-desc", location.Miles, "t480:t1A,t2B x390:x2B,x1C"},
+		{"sort=distance", location.Miles, "x390:x1C,x2B t480:t2B,t1A"},
+		{"sort=grade", location.Miles, "t480:t1A,t2B x390:x2B,x1C"},
+		{"grade=B", location.Miles, "t480:t2B x390:x2B"},
+		{"category=Laptops&category=Spares&grade=A&grade=C", location.Miles, "t480:t1A x390:x1C"},
+		{"category=Spares", location.Miles, "t480:t2B"},
+		{"min=100&max=175", location.Miles, "x390:x1C,x2B"},
+		{"distance=10", location.Miles, "t480:t2B x390:x1C"},
+		{"distance=10", location.Kilometres, "x390:x1C"},
+		{"category=Games", location.Miles, ""},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			vals, _ := url.ParseQuery(tt.query)
+			v, err := parseView(vals)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := groupIDs(v.apply(viewBoxes, tt.unit)), tt.want; got != want {
+				t.Errorf("got %q want %q", got, want)
+			}
+
+			// the view survives a round trip through its url query
+			vals, _ = url.ParseQuery(strings.TrimPrefix(v.urlQuery(), "&"))
+			v, err = parseView(vals)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := groupIDs(v.apply(viewBoxes, tt.unit)), tt.want; got != want {
+				t.Errorf("got %q want %q after url round trip", got, want)
+			}
+		})
+	}
+}
+
+// TestParseViewErrors tests invalid view parameters
+func TestParseViewErrors(t *testing.T) {
+	for i, query := range []string{"sort=colour", "min=cheap", "max=1e", "distance=-1", "distance=far"} {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			vals, _ := url.ParseQuery(query)
+			if _, err := parseView(vals); err == nil {
+				t.Errorf("expected error for %s", query)
+			}
+		})
+	}
+}
+
+// TestResultsCache tests result sets expire and the oldest are evicted
+func TestResultsCache(t *testing.T) {
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := newResultsCache(2, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.put("a", viewBoxes[:1])
+	now = now.Add(time.Second)
+	c.put("b", viewBoxes[:2])
+	now = now.Add(time.Second)
+	c.put("c", viewBoxes[:3])
+
+	if _, ok := c.get("a"); ok {
+		t.Error("expected oldest entry to be evicted")
+	}
+	if boxes, ok := c.get("c"); !ok || len(boxes) != 3 {
+		t.Errorf("got %d boxes (%t) want 3", len(boxes), ok)
+	}
+	now = now.Add(2 * time.Minute)
+	if _, ok := c.get("c"); ok {
+		t.Error("expected entry to expire")
+	}
+
+	// a zero size cache keeps nothing
+	c = newResultsCache(0, time.Minute)
+	c.put("a", viewBoxes)
+	if _, ok := c.get("a"); ok {
+		t.Error("expected nothing to be cached")
+	}
+}
+
+// TestView tests viewing cached results, and searching again if the
+// results are not cached
+func TestView(t *testing.T) {
+
+	s := newServer()
+	s.DirFS = &fileSystem{}
+	s.DirFS.TplFS = os.DirFS("templates")
+
+	searches := 0
+	s.searcher = func(cex *cexfind.CexFind, queries []string, strict bool, origins ...location.Origin) ([]cexfind.Box, error) {
+		searches++
+		return viewBoxes, nil
+	}
+
+	tests := []struct {
+		query      string
+		statusCode int
+		contains   []string
+		searches   int
+	}{
+		{
+			query:      "query=thinkpad&sort=price-desc&collapse=true",
+			statusCode: http.StatusOK,
+			contains:   []string{"showing 4 of 4 items", `<details class="model">`, "x1C"},
+			searches:   1,
+		},
+		{
+			query:      "query=thinkpad&grade=B",
+			statusCode: http.StatusOK,
+			contains:   []string{"showing 2 of 4 items", `<details class="model" open>`, `href="./results?format=csv&amp;grade=B&amp;query=thinkpad&amp;strict=false"`},
+			searches:   1, // cached
+		},
+		{
+			query:      "query=thinkpad&category=Games",
+			statusCode: http.StatusOK,
+			contains:   []string{"no items match"},
+			searches:   1,
+		},
+		{
+			query:      "query=thinkpad&store=1",
+			statusCode: http.StatusOK,
+			contains:   []string{"showing 1 of 4 items", "x1C"},
+			searches:   1,
+		},
+		{
+			query:      "query=thinkpad&sort=colour",
+			statusCode: http.StatusBadRequest,
+			searches:   1,
+		},
+		{
+			query:      "query=th",
+			statusCode: http.StatusBadRequest,
+			searches:   1,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/view?"+tt.query, nil)
+			w := httptest.NewRecorder()
+			s.View(w, r)
+
+			if got, want := w.Code, tt.statusCode; got != want {
+				t.Fatalf("got status %d want %d: %s", got, want, w.Body.String())
+			}
+			for _, c := range tt.contains {
+				if !strings.Contains(w.Body.String(), c) {
+					t.Errorf("expected body to contain %q, got\n%s", c, w.Body.String())
+				}
+			}
+			if got, want := searches, tt.searches; got != want {
+				t.Errorf("got %d searches want %d", got, want)
+			}
+		})
+	}
+
+	// the view is reflected in the pushed url
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/view?query=thinkpad&grade=B&sort=grade", nil)
+	w := httptest.NewRecorder()
+	s.View(w, r)
+	if got, want := w.Header().Get("HX-Push-Url"), "/?strict=false&query=thinkpad&grade=B&sort=grade"; got != want {
+		t.Errorf("got push url %s want %s", got, want)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// TestXLSXColumn tests column letters
+func TestXLSXColumn(t *testing.T) {
+	for i, tt := range []struct {
+		i    int
+		want string
+	}{
+		{0, "A"}, {11, "L"}, {25, "Z"}, {26, "AA"}, {51, "AZ"}, {52, "BA"}, {701, "ZZ"}, {702, "AAA"},
+	} {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			if got := xlsxColumn(tt.i); got != tt.want {
+				t.Errorf("got %s want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestWriteXLSX tests the workbook parts are well formed xml, and that
+// the cells are strings or numbers
+func TestWriteXLSX(t *testing.T) {
+	rows := [][]string{
+		{"name", "price"},
+		{"a <b> & c", "150.00"},
+		{" spaced", "n/a"},
+		{"=HYPERLINK(\"http://example.com\")", "-1"},
+	}
+	var buf bytes.Buffer
+	if err := writeXLSX(&buf, rows, []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(zr.File), len(xlsxParts)+1; got != want {
+		t.Fatalf("got %d parts want %d", got, want)
+	}
+
+	type cell struct {
+		Ref    string `xml:"r,attr"`
+		Type   string `xml:"t,attr"`
+		Value  string `xml:"v"`
+		Inline string `xml:"is>t"`
+	}
+	var sheet struct {
+		Rows []struct {
+			Cells []cell `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v any = &struct{}{}
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			v = &sheet
+			if bytes.Contains(b, []byte("<f>")) {
+				t.Error("expected no formulas")
+			}
+		}
+		if err := xml.Unmarshal(b, v); err != nil {
+			t.Fatalf("%s: %v", f.Name, err)
+		}
+	}
+
+	want := [][]cell{
+		{{"A1", "inlineStr", "", "name"}, {"B1", "inlineStr", "", "price"}},
+		{{"A2", "inlineStr", "", "a <b> & c"}, {"B2", "", "150.00", ""}},
+		{{"A3", "inlineStr", "", " spaced"}, {"B3", "inlineStr", "", "n/a"}},
+		{{"A4", "inlineStr", "", `=HYPERLINK("http://example.com")`}, {"B4", "", "-1", ""}},
+	}
+	if got, want := len(sheet.Rows), len(want); got != want {
+		t.Fatalf("got %d rows want %d", got, want)
+	}
+	for i, row := range sheet.Rows {
+		if got, want := fmt.Sprint(row.Cells), fmt.Sprint(want[i]); got != want {
+			t.Errorf("row %d got %s want %s", i, got, want)
+		}
+	}
+}
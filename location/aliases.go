@@ -0,0 +1,175 @@
+package location
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Alias relates a store name reported by the search API to a store in
+// the stores API, with a shorter display name.
+type Alias struct {
+	// Match is a substring of the store name reported by the search
+	// API, eg "Tottenham Crt Rd".
+	Match string `json:"match"`
+	// Name is the display name for the store, eg "London W1 TCR".
+	Name string `json:"name"`
+	// Store is the stores API name of the store, if it can't be found
+	// by matching.
+	Store string `json:"store,omitempty"`
+}
+
+// AliasRegistry is a collection of Alias used to resolve store names
+// from the search API to those from the stores API.
+type AliasRegistry struct {
+	aliases []Alias
+}
+
+// aliasesJSON is the default alias registry.
+//
+//go:embed data/aliases.json
+var aliasesJSON []byte
+
+// DefaultAliasRegistry returns the alias registry embedded in the
+// module.
+func DefaultAliasRegistry() *AliasRegistry {
+	a, err := NewAliasRegistry(strings.NewReader(string(aliasesJSON)))
+	if err != nil {
+		panic(fmt.Sprintf("embedded alias data error: %v", err))
+	}
+	return a
+}
+
+// LoadAliasFile loads an alias registry from a json file in the same
+// format as the embedded registry in data/aliases.json.
+func LoadAliasFile(path string) (*AliasRegistry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return NewAliasRegistry(f)
+}
+
+// NewAliasRegistry reads an alias registry from a json list of Alias.
+func NewAliasRegistry(r io.Reader) (*AliasRegistry, error) {
+	var aliases []Alias
+	if err := json.NewDecoder(r).Decode(&aliases); err != nil {
+		return nil, fmt.Errorf("alias decoding error: %w", err)
+	}
+	for i, a := range aliases {
+		if a.Match == "" || a.Name == "" {
+			return nil, fmt.Errorf("alias %d requires both match and name", i)
+		}
+	}
+	return &AliasRegistry{aliases: aliases}, nil
+}
+
+// lookup finds the alias for a search API store name or display name.
+func (ar *AliasRegistry) lookup(name string) (Alias, bool) {
+	for _, a := range ar.aliases {
+		if strings.Contains(name, a.Match) || name == a.Name {
+			return a, true
+		}
+	}
+	return Alias{}, false
+}
+
+// DisplayName returns the display name for a search API store name,
+// which is the name itself if there is no alias.
+func (ar *AliasRegistry) DisplayName(name string) string {
+	if a, ok := ar.lookup(name); ok {
+		return a.Name
+	}
+	return name
+}
+
+// abbreviations are expanded when normalising store names.
+var abbreviations = map[string]string{
+	"crt":    "court",
+	"ct":     "court",
+	"rd":     "road",
+	"st":     "street",
+	"ave":    "avenue",
+	"sq":     "square",
+	"ctr":    "centre",
+	"center": "centre",
+	"pl":     "place",
+	"&":      "and",
+}
+
+// normaliseStoreName lower cases a store name, removes punctuation and
+// expands common abbreviations so that, for example, "London - W1
+// Tottenham Crt Rd" and "London W1 Tottenham Court Road" are equal.
+func normaliseStoreName(name string) string {
+	name = strings.ReplaceAll(strings.ToLower(name), "&", " & ")
+	fields := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '&'
+	})
+	for i, f := range fields {
+		if e, ok := abbreviations[f]; ok {
+			fields[i] = e
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// storeNameSimilarity returns a similarity score between 0 and 1 for
+// two normalised store names. Names where all the words of one are
+// found in the other score 0.9; otherwise the score is based on the
+// edit distance between the names.
+func storeNameSimilarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	if a == "" || b == "" {
+		return 0
+	}
+	contains := func(x, y string) bool {
+		words := strings.Fields(y)
+		for _, w := range strings.Fields(x) {
+			found := false
+			for _, v := range words {
+				if w == v {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+	if contains(a, b) || contains(b, a) {
+		return 0.9
+	}
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+// levenshtein returns the edit distance between two rune slices.
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
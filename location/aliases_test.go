@@ -0,0 +1,137 @@
+package location
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// TestAliasDisplayName tests shortening of some store names
+func TestAliasDisplayName(t *testing.T) {
+
+	aliases := DefaultAliasRegistry()
+	for i, tt := range []struct {
+		provided string
+		want     string
+	}{
+		{"Havant", "Havant"},
+		{"London - W1 Tottenham Crt Rd", "London W1 TCR"},
+		{"London - W1 Rathbone Place", "London W1 Rathbone"},
+		{"London W1 Rathbone", "London W1 Rathbone"},
+	} {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			if got, want := aliases.DisplayName(tt.provided), tt.want; got != want {
+				t.Errorf("got %s want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestNormaliseStoreName(t *testing.T) {
+
+	for i, tt := range []struct {
+		provided string
+		want     string
+	}{
+		{"London - W1 Tottenham Crt Rd", "london w1 tottenham court road"},
+		{"London W1 Tottenham Court Road", "london w1 tottenham court road"},
+		{"Bristol Broadmead Ctr", "bristol broadmead centre"},
+		{"Brighton (Western Rd)", "brighton western road"},
+		{"Marks&Spencer", "marks and spencer"},
+	} {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			if got, want := normaliseStoreName(tt.provided), tt.want; got != want {
+				t.Errorf("got %s want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestStoreMatching(t *testing.T) {
+
+	sd := NewStoreDistances(false)
+	sd.stores.Lock()
+	for i, name := range []string{
+		"London - W1 Tottenham Crt Rd",
+		"London - W1 Rathbone Place",
+		"Walthamstow",
+		"Manchester Arndale",
+		"Manchester Piccadilly",
+		"Sheffield Fargate",
+	} {
+		sd.stores.storeMap[name] = store{StoreID: i + 1, StoreName: name}
+	}
+	sd.stores.initialised = true
+	sd.stores.generation++
+	sd.stores.Unlock()
+
+	for i, tt := range []struct {
+		searchName string
+		storeID    int // 0 is unmatched
+	}{
+		{"Walthamstow", 3},                    // exact
+		{"London W1 TCR", 1},                  // alias display name
+		{"W1 Rathbone Place", 2},              // alias match
+		{"London W1 Tottenham Court Road", 1}, // alias and normalised
+		{"WALTHAMSTOW", 3},                    // normalised
+		{"Sheffield - Fargate", 6},            // normalised
+		{"Manchester Arndal", 4},              // fuzzy
+		{"Manchester", 0},                     // ambiguous
+		{"Truro", 0},                          // missing
+	} {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			st, ok := sd.lookup(tt.searchName)
+			if got, want := ok, tt.storeID != 0; got != want {
+				t.Fatalf("got match %t want %t (%v)", got, want, st)
+			}
+			if got, want := st.StoreID, tt.storeID; got != want {
+				t.Errorf("got store id %d want %d", got, want)
+			}
+		})
+	}
+
+	if diff := cmp.Diff([]string{"Manchester", "Truro"}, sd.UnmatchedStores()); diff != "" {
+		t.Errorf("unmatched stores mismatch (-want +got):\n%s", diff)
+	}
+
+	// a new generation of stores resets the matches
+	sd.stores.Lock()
+	sd.stores.storeMap["Truro"] = store{StoreID: 7, StoreName: "Truro"}
+	sd.stores.generation++
+	sd.stores.Unlock()
+	if _, ok := sd.lookup("Manchester"); ok {
+		t.Error("expected Manchester to remain unmatched")
+	}
+	if diff := cmp.Diff([]string{"Manchester"}, sd.UnmatchedStores()); diff != "" {
+		t.Errorf("unmatched stores mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestLoadAliasFile(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "aliases.json")
+	err := os.WriteFile(path, []byte(`[{"match": "Fargate", "name": "Sheffield", "store": "Sheffield Fargate"}]`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliases, err := LoadAliasFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := aliases.DisplayName("Sheffield - Fargate"), "Sheffield"; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+
+	_, err = LoadAliasFile(path + ".missing")
+	if err == nil {
+		t.Error("expected missing file error")
+	}
+	_, err = NewAliasRegistry(strings.NewReader(`[{"match": "Fargate"}]`))
+	if err == nil {
+		t.Error("expected error for alias without name")
+	}
+}
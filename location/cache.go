@@ -0,0 +1,218 @@
+package location
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Default location cache settings. Locations are cached for a long
+// time since postcodes seldom move, while postcodes which could not be
+// found are cached for a shorter time in case they are new.
+const (
+	DefaultLocationCacheSize        = 10000
+	DefaultLocationCacheTTL         = 30 * 24 * time.Hour
+	DefaultLocationNotFoundCacheTTL = time.Hour
+)
+
+// LocationCacheStats reports the size and use of the location cache.
+// NotFoundHits counts hits for locations which could not be found.
+type LocationCacheStats struct {
+	Size         int
+	Capacity     int
+	Hits         uint64
+	NotFoundHits uint64
+	Misses       uint64
+	Evictions    uint64
+}
+
+// cacheEntry is a location cache entry. An entry with NotFound set
+// records a lookup which returned ErrLocationNotFound.
+type cacheEntry struct {
+	Key      string    `json:"key"`
+	Location Location  `json:"location"`
+	NotFound bool      `json:"notFound,omitempty"`
+	Expires  time.Time `json:"expires"`
+}
+
+// locationCache is a bounded, least recently used cache of locations
+// with expiry, which may be saved to and loaded from a file.
+type locationCache struct {
+	capacity    int
+	ttl         time.Duration
+	notFoundTTL time.Duration
+	file        string
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // most recently used at the front
+	stats   LocationCacheStats
+
+	// now is an indirect of time.Now for testing
+	now func() time.Time
+}
+
+// newLocationCache returns a locationCache holding up to capacity
+// entries.
+func newLocationCache(capacity int, ttl, notFoundTTL time.Duration) *locationCache {
+	return &locationCache{
+		capacity:    capacity,
+		ttl:         ttl,
+		notFoundTTL: notFoundTTL,
+		entries:     map[string]*list.Element{},
+		order:       list.New(),
+		now:         time.Now,
+	}
+}
+
+// get returns the entry for key if it exists and has not expired.
+func (c *locationCache) get(key string) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		c.stats.Misses++
+		return cacheEntry{}, false
+	}
+	e := el.Value.(*cacheEntry)
+	if c.now().After(e.Expires) {
+		c.remove(el)
+		c.stats.Misses++
+		return cacheEntry{}, false
+	}
+	c.order.MoveToFront(el)
+	if e.NotFound {
+		c.stats.NotFoundHits++
+	} else {
+		c.stats.Hits++
+	}
+	return *e, true
+}
+
+// has reports if an unexpired entry for key exists, without affecting
+// the recency of the entry or the counters.
+func (c *locationCache) has(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	return ok && !c.now().After(el.Value.(*cacheEntry).Expires)
+}
+
+// put caches a location for key.
+func (c *locationCache) put(key string, l Location) {
+	c.add(cacheEntry{Key: key, Location: l, Expires: c.now().Add(c.ttl)})
+}
+
+// putNotFound records that the location for key could not be found.
+func (c *locationCache) putNotFound(key string) {
+	if c.notFoundTTL <= 0 {
+		return
+	}
+	c.add(cacheEntry{Key: key, NotFound: true, Expires: c.now().Add(c.notFoundTTL)})
+}
+
+// add adds or replaces an entry, evicting the least recently used
+// entry if the cache is full.
+func (c *locationCache) add(e cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.capacity <= 0 {
+		return
+	}
+	if el, ok := c.entries[e.Key]; ok {
+		el.Value = &e
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[e.Key] = c.order.PushFront(&e)
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+// remove removes an element; the lock must be held.
+func (c *locationCache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).Key)
+}
+
+// length returns the number of entries, including any which have
+// expired but not yet been removed.
+func (c *locationCache) length() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// statistics returns a copy of the cache statistics.
+func (c *locationCache) statistics() LocationCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Size = c.order.Len()
+	s.Capacity = c.capacity
+	return s
+}
+
+// save writes the unexpired entries to the cache file, if set, from
+// most to least recently used. The file is written to a temporary file
+// first and then renamed.
+func (c *locationCache) save() error {
+	if c.file == "" {
+		return nil
+	}
+	c.mu.Lock()
+	now := c.now()
+	entries := []cacheEntry{}
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		if e := el.Value.(*cacheEntry); !now.After(e.Expires) {
+			entries = append(entries, *e)
+		}
+	}
+	c.mu.Unlock()
+
+	j, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("could not encode location cache: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.file), 0o755); err != nil {
+		return fmt.Errorf("could not make location cache directory: %w", err)
+	}
+	tmp := c.file + ".tmp"
+	if err := os.WriteFile(tmp, j, 0o644); err != nil {
+		return fmt.Errorf("could not write location cache: %w", err)
+	}
+	return os.Rename(tmp, c.file)
+}
+
+// load reads entries from the cache file, if set and present, skipping
+// those which have expired. A missing file is not an error.
+func (c *locationCache) load() error {
+	if c.file == "" {
+		return nil
+	}
+	j, err := os.ReadFile(c.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read location cache: %w", err)
+	}
+	var entries []cacheEntry
+	if err := json.Unmarshal(j, &entries); err != nil {
+		return fmt.Errorf("could not decode location cache %s: %w", c.file, err)
+	}
+	now := c.now()
+	// add the least recently used entries first to retain the order
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !now.After(entries[i].Expires) {
+			c.add(entries[i])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package location
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLocationCacheLRU(t *testing.T) {
+	c := newLocationCache(2, time.Hour, time.Minute)
+	c.put("a", Location{Postcode: "A"})
+	c.put("b", Location{Postcode: "B"})
+	if _, ok := c.get("a"); !ok { // a is now most recently used
+		t.Fatal("expected a to be cached")
+	}
+	c.put("c", Location{Postcode: "C"})
+
+	for i, tt := range []struct {
+		key string
+		ok  bool
+	}{
+		{"a", true},
+		{"b", false},
+		{"c", true},
+		{"d", false},
+	} {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			if got, want := c.has(tt.key), tt.ok; got != want {
+				t.Errorf("key %s got %t want %t", tt.key, got, want)
+			}
+		})
+	}
+
+	want := LocationCacheStats{Size: 2, Capacity: 2, Hits: 1, Evictions: 1}
+	if diff := cmp.Diff(want, c.statistics()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestLocationCacheExpiry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := newLocationCache(10, time.Hour, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.put("a", Location{Postcode: "A"})
+	c.putNotFound("b")
+	if _, ok := c.get("b"); !ok {
+		t.Fatal("expected b to be cached as not found")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := c.get("b"); ok {
+		t.Error("expected b to have expired")
+	}
+	if _, ok := c.get("a"); !ok {
+		t.Error("expected a to be cached")
+	}
+
+	now = now.Add(time.Hour)
+	if _, ok := c.get("a"); ok {
+		t.Error("expected a to have expired")
+	}
+
+	want := LocationCacheStats{Size: 0, Capacity: 10, Hits: 1, NotFoundHits: 1, Misses: 2}
+	if diff := cmp.Diff(want, c.statistics()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestLocationCachePersistence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cache", "locations.json")
+
+	c := newLocationCache(10, time.Hour, time.Minute)
+	c.file = file
+	if err := c.load(); err != nil {
+		t.Fatalf("missing file should not be an error: %v", err)
+	}
+	c.put("a", Location{Postcode: "A", Latitude: 51.5, Resolution: ResolutionPostcode})
+	c.put("b", Location{Postcode: "B"})
+	c.putNotFound("c")
+	if err := c.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// load into a smaller cache; the most recently used entries are kept
+	d := newLocationCache(2, time.Hour, time.Minute)
+	d.file = file
+	if err := d.load(); err != nil {
+		t.Fatal(err)
+	}
+	if d.has("a") || !d.has("b") || !d.has("c") {
+		t.Errorf("unexpected cache contents after load %v", d.entries)
+	}
+	e, _ := d.get("c")
+	if !e.NotFound {
+		t.Error("expected c to be not found")
+	}
+
+	d = newLocationCache(2, time.Hour, time.Minute)
+	d.file = file
+	d.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if err := d.load(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := d.length(), 0; got != want {
+		t.Errorf("expected expired entries to be skipped, got %d want %d", got, want)
+	}
+}
+
+func TestLocationNotFoundCached(t *testing.T) {
+	lookups := 0
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookups++
+		fmt.Fprint(w, `{"status":200,"result":[]}`)
+	}))
+	defer svr.Close()
+	findLocationURL = svr.URL
+	defer func() { findLocationURL = originalURL }()
+
+	lf := newLocationFinder()
+	lf.geocoder = NewPostcodesIOGeocoder()
+	for range 3 {
+		if _, err := lf.getLocationFromPostcode("ZZ1 1ZZ"); !errors.Is(err, ErrLocationNotFound) {
+			t.Fatalf("got error %v want %v", err, ErrLocationNotFound)
+		}
+	}
+	if got, want := lookups, 1; got != want {
+		t.Errorf("got %d lookups want %d", got, want)
+	}
+	if got, want := lf.cache.statistics().NotFoundHits, uint64(2); got != want {
+		t.Errorf("got %d not found hits want %d", got, want)
+	}
+}
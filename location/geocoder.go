@@ -0,0 +1,282 @@
+package location
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Resolution describes how precisely a location has been resolved.
+// The zero value, ResolutionNone, indicates no location was resolved.
+type Resolution int
+
+const (
+	ResolutionNone       Resolution = iota
+	ResolutionArea                  // postcode area centroid, eg "S"
+	ResolutionOutcode               // outward code centroid, eg "S10"
+	ResolutionPostcode              // full postcode, eg "S10 1LT"
+	ResolutionCoordinate            // latitude and longitude
+	ResolutionPlace                 // named place, eg "Marlborough"
+)
+
+func (r Resolution) String() string {
+	switch r {
+	case ResolutionNone:
+		return "none"
+	case ResolutionArea:
+		return "area"
+	case ResolutionOutcode:
+		return "outcode"
+	case ResolutionPostcode:
+		return "postcode"
+	case ResolutionCoordinate:
+		return "coordinate"
+	case ResolutionPlace:
+		return "place"
+	}
+	return fmt.Sprintf("Resolution(%d)", int(r))
+}
+
+// Approximate reports if the resolution is coarser than a full
+// postcode.
+func (r Resolution) Approximate() bool {
+	return r == ResolutionArea || r == ResolutionOutcode || r == ResolutionPlace
+}
+
+// Geocoder resolves a UK postcode to a Location.
+type Geocoder interface {
+	Geocode(postcode string) (*Location, error)
+}
+
+// PlaceFinder resolves a named place, such as a town, to a Location.
+// Geocoders may optionally implement PlaceFinder.
+type PlaceFinder interface {
+	FindPlace(name string) (*Location, error)
+}
+
+// findPlaceURL is the url for looking up places by name. Note that the
+// full url is findPlaceURL + ?q=
+var findPlaceURL string = "https://api.postcodes.io/places"
+
+// defaultGeocoder is the postcodes.io web service falling back to the
+// embedded outward code table.
+func defaultGeocoder() Geocoder {
+	return NewGeocoderChain(NewPostcodesIOGeocoder(), NewOutcodeGeocoder())
+}
+
+// jsonLocation is the raw data from a json geolocation call
+type jsonLocation struct {
+	Result []struct {
+		Postcode  string  `json:"postcode"`
+		Quality   int     `json:"quality"`
+		Longitude float64 `json:"longitude"`
+		Latitude  float64 `json:"latitude"`
+		District  string  `json:"admin_district"`
+	} `json:"result"`
+}
+
+// postcodesIO is a Geocoder using the api.postcodes.io web service.
+type postcodesIO struct {
+	client *http.Client
+}
+
+// NewPostcodesIOGeocoder returns a Geocoder which looks up postcodes
+// using the https://postcodes.io web service.
+func NewPostcodesIOGeocoder() Geocoder {
+	return &postcodesIO{
+		client: &http.Client{
+			Timeout: 2 * time.Second,
+		},
+	}
+}
+
+// Geocode looks up a postcode from the postcodes.io web service. A
+// query for only an outward code is reported as having outcode
+// resolution since the service returns the first matching postcode.
+func (p *postcodesIO) Geocode(postcode string) (*Location, error) {
+
+	var jloc jsonLocation
+
+	response, err := p.client.Get(findLocationURL + "?q=" + url.QueryEscape(postcode))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if err := checkStatus(response); err != nil {
+		return nil, err
+	}
+
+	responseBytes, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("http response read error: %w", err)
+	}
+
+	err = json.Unmarshal(responseBytes, &jloc)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal error: %w", err)
+	}
+	if len(jloc.Result) < 1 {
+		return nil, ErrLocationNotFound
+	}
+
+	result := jloc.Result[0]
+
+	l := Location{
+		Postcode:   result.Postcode,
+		District:   result.District,
+		Latitude:   result.Latitude,
+		Longitude:  result.Longitude,
+		Resolution: ResolutionPostcode,
+	}
+	if _, incode := splitPostcode(postcode); incode == "" {
+		l.Resolution = ResolutionOutcode
+	}
+	return &l, nil
+}
+
+// checkStatus returns an error for an unsuccessful postcodes.io
+// response, which is ErrLocationNotFound only if the service reports
+// the location doesn't exist.
+func checkStatus(response *http.Response) error {
+	switch {
+	case response.StatusCode == http.StatusNotFound:
+		return ErrLocationNotFound
+	case response.StatusCode < 200 || response.StatusCode > 299:
+		return fmt.Errorf("postcodes.io error: %s", response.Status)
+	}
+	return nil
+}
+
+// jsonPlace is the raw data from a json place lookup
+type jsonPlace struct {
+	Result []struct {
+		Name      string  `json:"name_1"`
+		District  string  `json:"district_borough"`
+		County    string  `json:"county_unitary"`
+		Longitude float64 `json:"longitude"`
+		Latitude  float64 `json:"latitude"`
+	} `json:"result"`
+}
+
+// FindPlace looks up a named place from the postcodes.io web service,
+// returning the first match.
+func (p *postcodesIO) FindPlace(name string) (*Location, error) {
+
+	var jplace jsonPlace
+
+	response, err := p.client.Get(findPlaceURL + "?limit=1&q=" + url.QueryEscape(name))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if err := checkStatus(response); err != nil {
+		return nil, err
+	}
+
+	responseBytes, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("http response read error: %w", err)
+	}
+
+	err = json.Unmarshal(responseBytes, &jplace)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal error: %w", err)
+	}
+	if len(jplace.Result) < 1 {
+		return nil, ErrLocationNotFound
+	}
+
+	result := jplace.Result[0]
+	district := result.District
+	if district == "" {
+		district = result.County
+	}
+	return &Location{
+		Postcode:   result.Name,
+		District:   district,
+		Latitude:   result.Latitude,
+		Longitude:  result.Longitude,
+		Resolution: ResolutionPlace,
+	}, nil
+}
+
+// geocoderChain tries each of its geocoders in turn.
+type geocoderChain []Geocoder
+
+// NewGeocoderChain returns a Geocoder which tries each of the provided
+// geocoders in order, returning the first successful result. If all
+// fail the errors are joined. A full postcode reported as not found is
+// not looked up further, so that a mistyped postcode is not given the
+// approximate location of its outward code; only an outward code, or a
+// geocoder failing for another reason, falls through to the next.
+func NewGeocoderChain(geocoders ...Geocoder) Geocoder {
+	return geocoderChain(geocoders)
+}
+
+// Geocode calls each geocoder in the chain in turn.
+func (gc geocoderChain) Geocode(postcode string) (*Location, error) {
+	var errs []error
+	for _, g := range gc {
+		l, err := g.Geocode(postcode)
+		if err == nil {
+			return l, nil
+		}
+		if _, incode := splitPostcode(postcode); incode != "" && errors.Is(err, ErrLocationNotFound) {
+			return nil, ErrLocationNotFound
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return nil, ErrLocationNotFound
+	}
+	// report not found only if every geocoder reported it
+	for _, e := range errs {
+		if !errors.Is(e, ErrLocationNotFound) {
+			return nil, errors.Join(errs...)
+		}
+	}
+	return nil, ErrLocationNotFound
+}
+
+// FindPlace calls each geocoder in the chain which is also a
+// PlaceFinder in turn.
+func (gc geocoderChain) FindPlace(name string) (*Location, error) {
+	var errs []error
+	for _, g := range gc {
+		pf, ok := g.(PlaceFinder)
+		if !ok {
+			continue
+		}
+		l, err := pf.FindPlace(name)
+		if err == nil {
+			return l, nil
+		}
+		errs = append(errs, err)
+	}
+	for _, e := range errs {
+		if !errors.Is(e, ErrLocationNotFound) {
+			return nil, errors.Join(errs...)
+		}
+	}
+	return nil, ErrLocationNotFound
+}
+
+// splitPostcode splits a postcode into its upper case outward and
+// inward codes. The inward code is always a digit followed by two
+// letters; if it is missing the whole input is treated as the outward
+// code.
+func splitPostcode(postcode string) (outcode, incode string) {
+	p := strings.ToUpper(strings.Join(strings.Fields(postcode), ""))
+	if len(p) >= 5 {
+		in := p[len(p)-3:]
+		if in[0] >= '0' && in[0] <= '9' {
+			return p[:len(p)-3], in
+		}
+	}
+	return p, ""
+}
@@ -0,0 +1,174 @@
+package location
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSplitPostcode(t *testing.T) {
+
+	tests := []struct {
+		postcode string
+		outcode  string
+		incode   string
+	}{
+		{"S10 1LT", "S10", "1LT"},
+		{"s101lt", "S10", "1LT"},
+		{" w1t  1aa ", "W1T", "1AA"},
+		{"SW1A 0AA", "SW1A", "0AA"},
+		{"S10", "S10", ""},
+		{"W1T", "W1T", ""},
+		{"", "", ""},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			outcode, incode := splitPostcode(tt.postcode)
+			if got, want := outcode+"|"+incode, tt.outcode+"|"+tt.incode; got != want {
+				t.Errorf("got %s want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestOutcodeGeocoder(t *testing.T) {
+
+	tests := []struct {
+		postcode   string
+		outcode    string
+		resolution Resolution
+		err        error
+	}{
+		{"S10 1LT", "S10", ResolutionOutcode, nil},
+		{"w1t 1aa", "W1T", ResolutionOutcode, nil},
+		{"W1A 1AA", "W1", ResolutionOutcode, nil}, // sub-district to district
+		{"SN8 1PA", "SN", ResolutionArea, nil},    // area only
+		{"EH1", "EH", ResolutionArea, nil},        // outward code only
+		{"QQ1 1AA", "", ResolutionNone, ErrLocationNotFound},
+		{"", "", ResolutionNone, ErrLocationNotFound},
+	}
+
+	g := NewOutcodeGeocoder()
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			l, err := g.Geocode(tt.postcode)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if got, want := l.Postcode, tt.outcode; got != want {
+				t.Errorf("got outcode %s want %s", got, want)
+			}
+			if got, want := l.Resolution, tt.resolution; got != want {
+				t.Errorf("got resolution %s want %s", got, want)
+			}
+			if l.Latitude < 49 || l.Latitude > 61 {
+				t.Errorf("latitude %f out of range", l.Latitude)
+			}
+		})
+	}
+}
+
+func TestOutcodeGeocoderFromReader(t *testing.T) {
+	_, err := NewOutcodeGeocoderFromReader(strings.NewReader("# comment only\n"))
+	if err == nil {
+		t.Error("expected error for empty table")
+	}
+	_, err = NewOutcodeGeocoderFromReader(strings.NewReader("S10,abc,-1.5\n"))
+	if err == nil {
+		t.Error("expected error for invalid latitude")
+	}
+	g, err := NewOutcodeGeocoderFromReader(strings.NewReader("ZZ9,50.0,-1.0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := g.Geocode("ZZ9 9ZZ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := l.Latitude, 50.0; got != want {
+		t.Errorf("got latitude %f want %f", got, want)
+	}
+}
+
+func TestGeocoderChain(t *testing.T) {
+
+	testdata, err := os.ReadFile("testdata/findlocation.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	okSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, string(testdata))
+	}))
+	defer okSvr.Close()
+	failSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		fmt.Fprint(w, "<h1>bad gateway</h1>")
+	}))
+	defer failSvr.Close()
+	missingSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":200,"result":null}`)
+	}))
+	defer missingSvr.Close()
+	defer func() { findLocationURL = originalURL }()
+
+	chain := NewGeocoderChain(NewPostcodesIOGeocoder(), NewOutcodeGeocoder())
+
+	// web service ok
+	findLocationURL = okSvr.URL
+	l, err := chain.Geocode("NW1 6LG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := l.Resolution, ResolutionPostcode; got != want {
+		t.Errorf("got resolution %s want %s", got, want)
+	}
+	if got, want := l.District, "Westminster"; got != want {
+		t.Errorf("got district %s want %s", got, want)
+	}
+
+	// web service failing, fall back to outcode
+	findLocationURL = failSvr.URL
+	l, err = chain.Geocode("NW1 6LG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := l.Resolution, ResolutionOutcode; got != want {
+		t.Errorf("got resolution %s want %s", got, want)
+	}
+	if got, want := l.Postcode, "NW1"; got != want {
+		t.Errorf("got outcode %s want %s", got, want)
+	}
+
+	// a full postcode which doesn't exist is not located by its outcode
+	findLocationURL = missingSvr.URL
+	if _, err := chain.Geocode("NW1 6XX"); !errors.Is(err, ErrLocationNotFound) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+
+	// but an outcode falls back to the outcode table
+	l, err = chain.Geocode("NW1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := l.Resolution, ResolutionOutcode; got != want {
+		t.Errorf("got resolution %s want %s", got, want)
+	}
+
+	// approximate results are not cached by the locationFinder
+	findLocationURL = failSvr.URL
+	lf := newLocationFinder()
+	lf.geocoder = chain
+	if _, err := lf.getLocationFromPostcode("NW1 6LG"); err != nil {
+		t.Fatal(err)
+	}
+	if lf.has("NW1 6LG") {
+		t.Error("approximate location should not be cached")
+	}
+}
@@ -0,0 +1,84 @@
+// snapshotgen refreshes the store snapshot embedded in the location
+// package from the Cex stores endpoint. It is run by "go generate" in
+// the location directory and, like other queries to Cex, needs to be
+// run from the UK.
+//
+// A response previously saved from the stores endpoint may be given
+// instead, in which case the snapshot is marked as fetched at the
+// modification time of that file.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/rorycl/cexfind/location"
+)
+
+// fileTransport answers every request with a saved stores response.
+type fileTransport string
+
+func (f fileTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	file, err := os.Open(string(f))
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       file,
+		Request:    r,
+	}, nil
+}
+
+func main() {
+	if len(os.Args) < 2 || len(os.Args) > 3 {
+		fmt.Println("usage: snapshotgen <output file> [saved stores response]")
+		os.Exit(1)
+	}
+	output := os.Args[1]
+	options := []location.Option{location.WithStoreCacheFile(output)}
+	if len(os.Args) == 3 {
+		options = append(options, location.WithTransport(fileTransport(os.Args[2])))
+	}
+	sd := location.NewStoreDistances(true, options...)
+	if _, source := sd.StoresAge(); source != location.StoresLive {
+		fmt.Println("could not retrieve stores; snapshot not updated")
+		os.Exit(1)
+	}
+	if len(os.Args) == 3 {
+		if err := setFetched(output, os.Args[2]); err != nil {
+			fmt.Println("could not set snapshot time:", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// setFetched marks the snapshot as fetched when the saved response was
+// last modified, rather than now.
+func setFetched(output, response string) error {
+	info, err := os.Stat(response)
+	if err != nil {
+		return err
+	}
+	contents, err := os.ReadFile(output)
+	if err != nil {
+		return err
+	}
+	var snap map[string]json.RawMessage
+	if err := json.Unmarshal(contents, &snap); err != nil {
+		return err
+	}
+	snap["fetched"], err = json.Marshal(info.ModTime().UTC().Truncate(time.Second))
+	if err != nil {
+		return err
+	}
+	contents, err = json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(output, append(contents, '\n'), 0o644)
+}
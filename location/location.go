@@ -1,12 +1,7 @@
 package location
 
 import (
-	"encoding/json"
 	"errors"
-	"fmt"
-	"io"
-	"net/http"
-	"net/url"
 	"strings"
 	"sync"
 	"time"
@@ -18,37 +13,37 @@ var findLocationURL string = "https://api.postcodes.io/postcodes"
 
 var ErrLocationNotFound error = errors.New("location not found")
 
-// Location represents the location derived from a web call
-type location struct {
-	Postcode  string
-	District  string
-	Latitude  float64
-	Longitude float64
-}
-
-// jsonLocation is the raw data from a json geolocation call
-type jsonLocation struct {
-	Result []struct {
-		Postcode  string  `json:"postcode"`
-		Quality   int     `json:"quality"`
-		Longitude float64 `json:"longitude"`
-		Latitude  float64 `json:"latitude"`
-		District  string  `json:"admin_district"`
-	} `json:"result"`
+// Location represents the location derived from a geocoder lookup.
+// Resolution reports how precisely the location was resolved.
+type Location struct {
+	Postcode   string
+	District   string
+	Latitude   float64
+	Longitude  float64
+	Resolution Resolution
 }
 
 // locationFinder holds information about postcodes, avoiding lookups
-// for the same postcode
+// for the same postcode. Locations which could not be found are also
+// cached for a time to avoid repeated lookups.
 type locationFinder struct {
-	locationMap map[string]location
+	cache    *locationCache
+	geocoder Geocoder
+	observer Observer
 	sync.RWMutex
 }
 
-// newLocationFinder returns a new locationFinder. This should only be
-// initialised once
+// newLocationFinder returns a new locationFinder using the default
+// geocoder chain and cache settings. This should only be initialised
+// once
 func newLocationFinder() *locationFinder {
 	l := locationFinder{
-		locationMap: map[string]location{},
+		cache: newLocationCache(
+			DefaultLocationCacheSize,
+			DefaultLocationCacheTTL,
+			DefaultLocationNotFoundCacheTTL,
+		),
+		geocoder: defaultGeocoder(),
 	}
 	return &l
 }
@@ -58,79 +53,118 @@ func (lf *locationFinder) clean(postcode string) string {
 }
 
 func (lf *locationFinder) has(postcode string) bool {
-	lf.RLock()
-	defer lf.RUnlock()
-	if _, ok := lf.locationMap[lf.clean(postcode)]; ok {
-		return true
+	return lf.cache.has(lf.clean(postcode))
+}
+
+// get returns a cached location, or ErrLocationNotFound if the
+// location is cached as not found.
+func (lf *locationFinder) get(postcode string) (Location, bool, error) {
+	e, ok := lf.cache.get(lf.clean(postcode))
+	if ok && e.NotFound {
+		return Location{}, true, ErrLocationNotFound
 	}
-	return false
+	return e.Location, ok, nil
 }
 
-func (lf *locationFinder) get(postcode string) (location, bool) {
-	lf.RLock()
-	defer lf.RUnlock()
-	l, ok := lf.locationMap[lf.clean(postcode)]
-	return l, ok
+func (lf *locationFinder) put(postcode string, l Location) {
+	lf.cache.put(lf.clean(postcode), l)
 }
 
-func (lf *locationFinder) put(postcode string, l location) {
-	lf.Lock()
-	defer lf.Unlock()
-	lf.locationMap[lf.clean(postcode)] = l
+func (lf *locationFinder) putNotFound(postcode string) {
+	lf.cache.putNotFound(lf.clean(postcode))
 }
 
 func (lf *locationFinder) length() int {
-	lf.RLock()
-	defer lf.RUnlock()
-	return len(lf.locationMap)
+	return lf.cache.length()
 }
 
-// getLocationFromPostcode tries to extract the location data from the
-// locationMap. If that fails it tries to extract the necessary
-// information from a web service.
-func (lf *locationFinder) getLocationFromPostcode(postcode string) (*location, error) {
-
-	if postcode == "" {
-		return nil, errors.New("no postcode provided")
+// getLocation resolves an Origin to a Location, validating the Origin
+// before making any lookups.
+func (lf *locationFinder) getLocation(o Origin) (*Location, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	switch o.Kind {
+	case OriginPostcode:
+		return lf.getLocationFromPostcode(o.Postcode)
+	case OriginPlace:
+		return lf.getLocationFromPlace(o.Place)
+	case OriginCoord:
+		return &Location{
+			Latitude:   o.Latitude,
+			Longitude:  o.Longitude,
+			Resolution: ResolutionCoordinate,
+		}, nil
 	}
+	return nil, errors.New("no origin provided")
+}
 
-	// check postcode in cache
-	if l, ok := lf.get(postcode); ok {
+// getLocationFromPlace resolves a place name using the geocoder, if it
+// is also a PlaceFinder. Places are cached separately from postcodes.
+func (lf *locationFinder) getLocationFromPlace(name string) (*Location, error) {
+
+	key := "place:" + name
+	if l, ok, err := lf.get(key); ok {
+		if err != nil {
+			return nil, err
+		}
 		return &l, nil
 	}
 
-	var jloc jsonLocation
+	lf.RLock()
+	placeFinder, ok := lf.geocoder.(PlaceFinder)
+	lf.RUnlock()
+	if !ok {
+		return nil, errors.New("the geocoder cannot find places")
+	}
 
-	client := &http.Client{
-		Timeout: 2 * time.Second,
+	start := time.Now()
+	l, err := placeFinder.FindPlace(name)
+	lf.observer.locationLookup(OriginPlace, start, err)
+	if errors.Is(err, ErrLocationNotFound) {
+		lf.putNotFound(key)
 	}
-	response, err := client.Get(findLocationURL + "?q=" + url.QueryEscape(postcode))
 	if err != nil {
 		return nil, err
 	}
-	defer response.Body.Close()
+	lf.put(key, *l)
+	return l, nil
+}
 
-	responseBytes, err := io.ReadAll(response.Body)
-	if err != nil {
-		return nil, fmt.Errorf("http response read error: %w", err)
-	}
+// getLocationFromPostcode tries to extract the location data from the
+// cache. If that fails it asks the geocoder. Only full resolution
+// results are cached so that approximate results from a fallback
+// geocoder are retried when the primary geocoder recovers. Postcodes
+// which could not be found are cached as such.
+func (lf *locationFinder) getLocationFromPostcode(postcode string) (*Location, error) {
 
-	err = json.Unmarshal(responseBytes, &jloc)
-	if err != nil {
-		return nil, fmt.Errorf("unmarshal error: %w", err)
-	}
-	if len(jloc.Result) < 1 {
-		return nil, ErrLocationNotFound
+	if postcode == "" {
+		return nil, errors.New("no postcode provided")
 	}
 
-	result := jloc.Result[0]
+	// check postcode in cache
+	if l, ok, err := lf.get(postcode); ok {
+		if err != nil {
+			return nil, err
+		}
+		return &l, nil
+	}
 
-	l := location{
-		Postcode:  result.Postcode,
-		District:  result.District,
-		Latitude:  result.Latitude,
-		Longitude: result.Longitude,
+	lf.RLock()
+	geocoder := lf.geocoder
+	lf.RUnlock()
+
+	start := time.Now()
+	l, err := geocoder.Geocode(postcode)
+	lf.observer.locationLookup(OriginPostcode, start, err)
+	if errors.Is(err, ErrLocationNotFound) {
+		lf.putNotFound(postcode)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if l.Resolution == ResolutionPostcode {
+		lf.put(postcode, *l)
 	}
-	lf.put(postcode, l)
-	return &l, nil
+	return l, nil
 }
@@ -56,6 +56,7 @@ func TestGetLocationReal(t *testing.T) {
 	// off)
 	postcode := "SN8 1PA"
 	lFinder := newLocationFinder()
+	lFinder.geocoder = NewPostcodesIOGeocoder() // no fallback
 	location, err := lFinder.getLocationFromPostcode(postcode)
 	if err != nil {
 		t.Fatal(err)
@@ -0,0 +1,49 @@
+package location
+
+import "time"
+
+// Observer receives events from StoreDistances for instrumentation,
+// such as metrics. Any of its funcs may be nil. The funcs may be called
+// concurrently and should return quickly.
+type Observer struct {
+	// LocationLookup is called after each geocoder lookup of a
+	// postcode or place not found in the location cache, with the
+	// duration of the lookup and any error.
+	LocationLookup func(kind OriginKind, duration time.Duration, err error)
+
+	// StoresRefresh is called after each attempt to retrieve the store
+	// locations, with the duration of the attempt and any error.
+	StoresRefresh func(duration time.Duration, err error)
+}
+
+func (o Observer) locationLookup(kind OriginKind, start time.Time, err error) {
+	if o.LocationLookup != nil {
+		o.LocationLookup(kind, time.Since(start), err)
+	}
+}
+
+func (o Observer) storesRefresh(start time.Time, err error) {
+	if o.StoresRefresh != nil {
+		o.StoresRefresh(time.Since(start), err)
+	}
+}
+
+// WithObserver sets an Observer to receive location lookup and store
+// refresh events.
+func WithObserver(o Observer) Option {
+	return func(sd *StoreDistances) {
+		sd.observer = o
+		sd.locationFinder.observer = o
+	}
+}
+
+// StoresStatus reports the state of the store location data.
+type StoresStatus struct {
+	Initialised bool          // distances can be calculated
+	Source      StoresSource  // where the store data was loaded from
+	Age         time.Duration // the age of the store data
+	Count       int           // the number of stores
+	LastAttempt time.Time     // the last attempt to retrieve the stores
+	LastError   error         // the error from the last attempt, if any
+	Failures    int           // the number of consecutive failed attempts
+}
@@ -0,0 +1,93 @@
+package location
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestObserverLocationLookup(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":200,"result":[]}`)
+	}))
+	defer svr.Close()
+	findLocationURL = svr.URL
+	defer func() { findLocationURL = originalURL }()
+
+	var kinds []OriginKind
+	var errs []error
+	sd := NewStoreDistances(false, WithObserver(Observer{
+		LocationLookup: func(kind OriginKind, duration time.Duration, err error) {
+			kinds = append(kinds, kind)
+			errs = append(errs, err)
+		},
+	}))
+	sd.locationFinder.geocoder = NewPostcodesIOGeocoder()
+
+	// the second lookup is a cache hit, which is not observed
+	for range 2 {
+		if _, err := sd.locationFinder.getLocationFromPostcode("ZZ1 1ZZ"); !errors.Is(err, ErrLocationNotFound) {
+			t.Fatalf("got error %v want %v", err, ErrLocationNotFound)
+		}
+	}
+	if got, want := len(kinds), 1; got != want {
+		t.Fatalf("got %d lookups observed want %d", got, want)
+	}
+	if got, want := kinds[0].String(), "postcode"; got != want {
+		t.Errorf("got kind %s want %s", got, want)
+	}
+	if !errors.Is(errs[0], ErrLocationNotFound) {
+		t.Errorf("got error %v want %v", errs[0], ErrLocationNotFound)
+	}
+}
+
+func TestObserverStoresRefresh(t *testing.T) {
+	testdata, err := os.ReadFile("testdata/stores.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fail := true
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, string(testdata))
+	}))
+	defer svr.Close()
+	storeURL = svr.URL
+
+	var refreshErrs []error
+	observer := Observer{
+		StoresRefresh: func(duration time.Duration, err error) {
+			refreshErrs = append(refreshErrs, err)
+		},
+	}
+	s := newStores(true, storesSnapshot{}, observer, nil)
+	status := s.status()
+	if status.Initialised || status.LastError == nil || status.Failures != 1 {
+		t.Errorf("unexpected status after failure %+v", status)
+	}
+
+	fail = false
+	if err := s.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	status = s.status()
+	if status.Source != StoresLive {
+		t.Errorf("unexpected source %s", status.Source)
+	}
+	if status.LastError != nil || status.Failures != 0 || status.Count != 2 {
+		t.Errorf("unexpected status after refresh %+v", status)
+	}
+	if got, want := len(refreshErrs), 2; got != want {
+		t.Fatalf("got %d refreshes observed want %d", got, want)
+	}
+	if refreshErrs[0] == nil || refreshErrs[1] != nil {
+		t.Errorf("unexpected refresh errors %v", refreshErrs)
+	}
+}
@@ -0,0 +1,191 @@
+package location
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	ErrInvalidPostcode   = errors.New("invalid postcode")
+	ErrInvalidCoordinate = errors.New("invalid coordinate")
+)
+
+// OriginKind is the kind of location described by an Origin.
+type OriginKind int
+
+const (
+	OriginNone     OriginKind = iota
+	OriginPostcode            // a UK postcode or outward code
+	OriginCoord               // a latitude and longitude
+	OriginPlace               // a named place, resolved by a PlaceFinder
+)
+
+func (k OriginKind) String() string {
+	switch k {
+	case OriginNone:
+		return "none"
+	case OriginPostcode:
+		return "postcode"
+	case OriginCoord:
+		return "coordinate"
+	case OriginPlace:
+		return "place"
+	}
+	return fmt.Sprintf("OriginKind(%d)", int(k))
+}
+
+// Origin is the location from which distances to stores are
+// calculated. Use PostcodeOrigin, CoordOrigin, PlaceOrigin or
+// ParseOrigin to make an Origin. The zero value is no origin. The
+// optional Name, such as "home" or "work", labels distances when more
+// than one origin is used.
+type Origin struct {
+	Name      string
+	Kind      OriginKind
+	Postcode  string
+	Place     string
+	Latitude  float64
+	Longitude float64
+}
+
+// PostcodeOrigin returns an Origin for a UK postcode.
+func PostcodeOrigin(postcode string) Origin {
+	if strings.TrimSpace(postcode) == "" {
+		return Origin{}
+	}
+	return Origin{Kind: OriginPostcode, Postcode: postcode}
+}
+
+// CoordOrigin returns an Origin for a latitude and longitude.
+func CoordOrigin(latitude, longitude float64) Origin {
+	return Origin{Kind: OriginCoord, Latitude: latitude, Longitude: longitude}
+}
+
+// PlaceOrigin returns an Origin for a named place, such as a town.
+func PlaceOrigin(name string) Origin {
+	if strings.TrimSpace(name) == "" {
+		return Origin{}
+	}
+	return Origin{Kind: OriginPlace, Place: name}
+}
+
+// IsZero reports if the Origin is empty.
+func (o Origin) IsZero() bool {
+	return o.Kind == OriginNone
+}
+
+func (o Origin) String() string {
+	switch o.Kind {
+	case OriginPostcode:
+		return strings.ToUpper(strings.TrimSpace(o.Postcode))
+	case OriginCoord:
+		return fmt.Sprintf("%.5f,%.5f", o.Latitude, o.Longitude)
+	case OriginPlace:
+		return strings.TrimSpace(o.Place)
+	}
+	return ""
+}
+
+// Label returns the Origin's Name or, if it has none, its string
+// representation.
+func (o Origin) Label() string {
+	if o.Name != "" {
+		return o.Name
+	}
+	return o.String()
+}
+
+// Validate checks the Origin without making any network calls.
+func (o Origin) Validate() error {
+	switch o.Kind {
+	case OriginPostcode:
+		return ValidatePostcode(o.Postcode)
+	case OriginCoord:
+		if o.Latitude < -90 || o.Latitude > 90 || o.Longitude < -180 || o.Longitude > 180 {
+			return fmt.Errorf("%w: %s", ErrInvalidCoordinate, o)
+		}
+	}
+	return nil
+}
+
+var (
+	// rePostcode matches a full UK postcode or outward code, in upper
+	// case with spaces removed
+	rePostcode = regexp.MustCompile(`^([A-Z]{1,2}[0-9][A-Z0-9]?([0-9][A-Z]{2})?|GIR0AA)$`)
+	// reCoord matches a "latitude,longitude" pair
+	reCoord = regexp.MustCompile(`^\s*(-?[0-9]+(?:\.[0-9]+)?)\s*,\s*(-?[0-9]+(?:\.[0-9]+)?)\s*$`)
+)
+
+// ValidatePostcode checks that postcode is in the format of a UK
+// postcode, such as "S10 1LT", or outward code, such as "S10".
+func ValidatePostcode(postcode string) error {
+	p := strings.ToUpper(strings.Join(strings.Fields(postcode), ""))
+	if !rePostcode.MatchString(p) {
+		return fmt.Errorf("%w: %q", ErrInvalidPostcode, strings.TrimSpace(postcode))
+	}
+	return nil
+}
+
+// ParseOrigin parses a string into an Origin. A "latitude,longitude"
+// pair is parsed as a coordinate, input containing digits must be a
+// valid UK postcode or outward code, and anything else is treated as a
+// place name. The origin may be named with a "name=" prefix, such as
+// "home=S10 1LT". An empty string returns an empty Origin.
+func ParseOrigin(s string) (Origin, error) {
+	var name string
+	if n, value, ok := strings.Cut(s, "="); ok {
+		name, s = strings.TrimSpace(n), value
+	}
+	o, err := parseOrigin(strings.TrimSpace(s))
+	if !o.IsZero() {
+		o.Name = name
+	}
+	return o, err
+}
+
+func parseOrigin(s string) (Origin, error) {
+	if s == "" {
+		return Origin{}, nil
+	}
+	if m := reCoord.FindStringSubmatch(s); m != nil {
+		lat, _ := strconv.ParseFloat(m[1], 64)
+		lon, _ := strconv.ParseFloat(m[2], 64)
+		o := CoordOrigin(lat, lon)
+		return o, o.Validate()
+	}
+	if strings.ContainsFunc(s, unicode.IsDigit) {
+		o := PostcodeOrigin(s)
+		return o, o.Validate()
+	}
+	return PlaceOrigin(s), nil
+}
+
+// OriginSplitChar separates origins parsed by ParseOrigins.
+var OriginSplitChar = ";"
+
+// ParseOrigins parses a list of origins separated by OriginSplitChar,
+// each parsed by ParseOrigin, such as "home=S10 1LT; work=NW1 6LG".
+// Empty entries are skipped and origin labels must be unique.
+func ParseOrigins(s string) ([]Origin, error) {
+	origins := []Origin{}
+	labels := map[string]bool{}
+	for _, part := range strings.Split(s, OriginSplitChar) {
+		o, err := ParseOrigin(part)
+		if err != nil {
+			return nil, err
+		}
+		if o.IsZero() {
+			continue
+		}
+		if labels[o.Label()] {
+			return nil, fmt.Errorf("duplicate origin %q", o.Label())
+		}
+		labels[o.Label()] = true
+		origins = append(origins, o)
+	}
+	return origins, nil
+}
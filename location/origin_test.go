@@ -0,0 +1,234 @@
+package location
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestValidatePostcode(t *testing.T) {
+
+	for i, tt := range []struct {
+		postcode string
+		valid    bool
+	}{
+		{"S10 1LT", true},
+		{"s101lt", true},
+		{"SW1A 0AA", true},
+		{"W1T 1AA", true},
+		{"EC1A 1BB", true},
+		{"S10", true},
+		{"GIR 0AA", true},
+		{"S10 1L", false},
+		{"S10 LT1", false},
+		{"1S0 1LT", false},
+		{"SWW1 1AA", false},
+		{"", false},
+	} {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			err := ValidatePostcode(tt.postcode)
+			if got, want := err == nil, tt.valid; got != want {
+				t.Errorf("%q got valid %t want %t (%v)", tt.postcode, got, want, err)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidPostcode) {
+				t.Errorf("unexpected error type %T", err)
+			}
+		})
+	}
+}
+
+func TestParseOrigin(t *testing.T) {
+
+	for i, tt := range []struct {
+		input  string
+		origin Origin
+		err    error
+	}{
+		{"", Origin{}, nil},
+		{"  ", Origin{}, nil},
+		{"S10 1LT", Origin{Kind: OriginPostcode, Postcode: "S10 1LT"}, nil},
+		{"S10", Origin{Kind: OriginPostcode, Postcode: "S10"}, nil},
+		{"S10 1L", Origin{Kind: OriginPostcode, Postcode: "S10 1L"}, ErrInvalidPostcode},
+		{"51.5, -0.12", Origin{Kind: OriginCoord, Latitude: 51.5, Longitude: -0.12}, nil},
+		{"95,-0.12", Origin{Kind: OriginCoord, Latitude: 95, Longitude: -0.12}, ErrInvalidCoordinate},
+		{"Marlborough", Origin{Kind: OriginPlace, Place: "Marlborough"}, nil},
+		{" Newcastle upon Tyne ", Origin{Kind: OriginPlace, Place: "Newcastle upon Tyne"}, nil},
+	} {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			o, err := ParseOrigin(tt.input)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v want %v", err, tt.err)
+			}
+			if got, want := o, tt.origin; got != want {
+				t.Errorf("got %#v want %#v", got, want)
+			}
+		})
+	}
+}
+
+func TestDistancesFromOrigins(t *testing.T) {
+
+	testdata, err := os.ReadFile("testdata/findplace.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, string(testdata))
+	}))
+	defer svr.Close()
+	originalPlaceURL := findPlaceURL
+	findPlaceURL = svr.URL
+	defer func() { findPlaceURL = originalPlaceURL }()
+
+	sd := NewStoreDistances(false)
+	sd.stores.Lock()
+	sd.stores.storeMap["Havant"] = store{StoreID: 3058, StoreName: "Havant", Latitude: 50.852325, Longitude: -0.982041}
+	sd.stores.initialised = true
+	sd.stores.Unlock()
+
+	for i, tt := range []struct {
+		origin     Origin
+		miles      int
+		resolution Resolution
+		err        error
+	}{
+		{CoordOrigin(51.523969, -0.166312), 58, ResolutionCoordinate, nil},
+		{PlaceOrigin("Marlborough"), 51, ResolutionPlace, nil},
+		{PostcodeOrigin("NW1 6LX"), 58, ResolutionPostcode, nil}, // cached below
+		{PostcodeOrigin("NW1 6L"), 0, ResolutionNone, ErrInvalidPostcode},
+		{CoordOrigin(-95, 0), 0, ResolutionNone, ErrInvalidCoordinate},
+	} {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			sd.locationFinder.put("NW1 6LX", Location{Latitude: 51.523969, Longitude: -0.166312, Resolution: ResolutionPostcode})
+			swd, err := sd.DistancesFrom([]string{"Havant"}, tt.origin)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if got, want := int(math.Round(swd[0].DistanceMiles)), tt.miles; got != want {
+				t.Errorf("got %d want %d miles", got, want)
+			}
+			if got, want := swd[0].DistanceKm, Kilometres.FromMiles(swd[0].DistanceMiles); math.Abs(got-want) > 0.5 {
+				t.Errorf("got %f want %f km", got, want)
+			}
+			if got, want := swd[0].Resolution, tt.resolution; got != want {
+				t.Errorf("got resolution %s want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestParseOrigins(t *testing.T) {
+
+	for i, tt := range []struct {
+		input  string
+		labels []string
+		err    bool
+	}{
+		{"", []string{}, false},
+		{"S10 1LT", []string{"S10 1LT"}, false},
+		{"home=S10 1LT; work = NW1 6LG", []string{"home", "work"}, false},
+		{"S10 1LT;;Marlborough", []string{"S10 1LT", "Marlborough"}, false},
+		{"home=S10 1LT; home=NW1 6LG", nil, true},
+		{"home=S10 1LT; work=NW1 6L", nil, true},
+	} {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			origins, err := ParseOrigins(tt.input)
+			if got, want := err != nil, tt.err; got != want {
+				t.Fatalf("got error %v want error %t", err, want)
+			}
+			labels := []string{}
+			for _, o := range origins {
+				labels = append(labels, o.Label())
+			}
+			if err == nil && fmt.Sprint(labels) != fmt.Sprint(tt.labels) {
+				t.Errorf("got labels %v want %v", labels, tt.labels)
+			}
+		})
+	}
+}
+
+func TestDistancesFromMultipleOrigins(t *testing.T) {
+
+	sd := NewStoreDistances(false)
+	sd.stores.Lock()
+	sd.stores.storeMap["Havant"] = store{StoreID: 3058, StoreName: "Havant", Latitude: 50.852325, Longitude: -0.982041}
+	sd.stores.storeMap["Walthamstow"] = store{StoreID: 145, StoreName: "Walthamstow", Latitude: 51.583371, Longitude: -0.023809}
+	sd.stores.initialised = true
+	sd.stores.Unlock()
+
+	home := CoordOrigin(50.85, -1.0) // near Havant
+	home.Name = "home"
+	work := CoordOrigin(51.58, -0.02) // near Walthamstow
+	work.Name = "work"
+
+	swd, err := sd.DistancesFrom([]string{"Havant", "Walthamstow", "Truro"}, home, Origin{}, work)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(swd), 3; got != want {
+		t.Fatalf("got %d want %d stores", got, want)
+	}
+	// the unmatched store has no distance and sorts first, followed by
+	// the store nearest to any origin
+	for i, want := range []struct {
+		name    string
+		nearest string
+	}{
+		{"Truro", ""},
+		{"Walthamstow", "work"},
+		{"Havant", "home"},
+	} {
+		if got := swd[i].StoreName; got != want.name {
+			t.Errorf("store %d got %s want %s", i, got, want.name)
+		}
+		if got := swd[i].Nearest; got != want.nearest {
+			t.Errorf("store %d nearest got %s want %s", i, got, want.nearest)
+		}
+	}
+	if got, want := len(swd[2].Distances), 2; got != want {
+		t.Fatalf("got %d want %d origin distances", got, want)
+	}
+	if swd[2].Distances[1].DistanceMiles < 50 {
+		t.Errorf("expected Havant to be over 50 miles from work, got %f", swd[2].Distances[1].DistanceMiles)
+	}
+	if !swd[1].Within(5) || !swd[2].Within(5) {
+		t.Error("expected stores to be within 5 miles of an origin")
+	}
+	if swd[0].Within(5) {
+		t.Error("unmatched store should not be within any distance")
+	}
+}
+
+// TestKindStrings tests the names of enumerated values, including
+// those out of range
+func TestKindStrings(t *testing.T) {
+	tests := []struct {
+		value fmt.Stringer
+		want  string
+	}{
+		{ResolutionPostcode, "postcode"},
+		{ResolutionPlace, "place"},
+		{Resolution(99), "Resolution(99)"},
+		{OriginCoord, "coordinate"},
+		{OriginKind(-1), "OriginKind(-1)"},
+		{StoresEmbedded, "embedded"},
+		{StoresSource(7), "StoresSource(7)"},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			if got := tt.value.String(); got != tt.want {
+				t.Errorf("got %s want %s", got, tt.want)
+			}
+		})
+	}
+	if got, want := int(ResolutionPostcode), 3; got != want {
+		t.Errorf("got ResolutionPostcode %d want %d", got, want)
+	}
+}
@@ -0,0 +1,106 @@
+package location
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// outcodesCSV is an embedded table of approximate outward code and
+// postcode area centroids.
+//
+//go:embed data/outcodes.csv
+var outcodesCSV string
+
+// outcodeGeocoder is a Geocoder resolving postcodes to the centroid of
+// their outward code or postcode area without network access.
+type outcodeGeocoder struct {
+	centroids map[string]coord
+}
+
+// NewOutcodeGeocoder returns a Geocoder using the embedded table of
+// outward code centroids.
+func NewOutcodeGeocoder() Geocoder {
+	g, err := NewOutcodeGeocoderFromReader(strings.NewReader(outcodesCSV))
+	if err != nil {
+		panic(fmt.Sprintf("embedded outcode data error: %v", err))
+	}
+	return g
+}
+
+// NewOutcodeGeocoderFromReader returns a Geocoder using a table of
+// outward code centroids read from r in "outcode,latitude,longitude"
+// csv format. Lines starting with "#" are ignored.
+func NewOutcodeGeocoderFromReader(r io.Reader) (Geocoder, error) {
+	c := csv.NewReader(r)
+	c.Comment = '#'
+	c.FieldsPerRecord = 3
+	g := outcodeGeocoder{centroids: map[string]coord{}}
+	for {
+		record, err := c.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("outcode read error: %w", err)
+		}
+		lat, err := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("outcode %s latitude error: %w", record[0], err)
+		}
+		lon, err := strconv.ParseFloat(strings.TrimSpace(record[2]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("outcode %s longitude error: %w", record[0], err)
+		}
+		g.centroids[strings.ToUpper(strings.TrimSpace(record[0]))] = coord{Lat: lat, Lon: lon}
+	}
+	if len(g.centroids) == 0 {
+		return nil, errors.New("no outcodes found")
+	}
+	return &g, nil
+}
+
+// Geocode resolves the outward code of postcode, then the district
+// without any trailing sub-district letter (eg "W1A" to "W1") and
+// finally the postcode area (eg "W").
+func (g *outcodeGeocoder) Geocode(postcode string) (*Location, error) {
+	outcode, _ := splitPostcode(postcode)
+	if outcode == "" {
+		return nil, ErrLocationNotFound
+	}
+
+	candidates := []string{outcode}
+	if last := rune(outcode[len(outcode)-1]); unicode.IsLetter(last) && strings.ContainsAny(outcode, "0123456789") {
+		candidates = append(candidates, outcode[:len(outcode)-1])
+	}
+	for _, c := range candidates {
+		if centroid, ok := g.centroids[c]; ok {
+			return &Location{
+				Postcode:   c,
+				Latitude:   centroid.Lat,
+				Longitude:  centroid.Lon,
+				Resolution: ResolutionOutcode,
+			}, nil
+		}
+	}
+
+	// the area is the leading letters of the outward code
+	area := outcode
+	if i := strings.IndexFunc(outcode, func(r rune) bool { return !unicode.IsLetter(r) }); i >= 0 {
+		area = outcode[:i]
+	}
+	if centroid, ok := g.centroids[area]; ok && area != "" {
+		return &Location{
+			Postcode:   area,
+			Latitude:   centroid.Lat,
+			Longitude:  centroid.Lon,
+			Resolution: ResolutionArea,
+		}, nil
+	}
+	return nil, ErrLocationNotFound
+}
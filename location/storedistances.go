@@ -2,31 +2,90 @@ package location
 
 import (
 	"fmt"
+	"log"
+	"net/http"
+	"slices"
 	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
-// StoreWithDistance represents a store with a distance DistanceMiles
-// from the provided postcode.
+// StoreWithDistance represents a store with a distance DistanceMiles,
+// or DistanceKm, from the provided origin. Resolution reports how
+// precisely the origin was located, and Unit the unit used when
+// formatting the distance. ClosingTime is the store's closing time
+// today, such as "18:00", if known.
+//
+// Where distances are calculated from several origins, the distances
+// and Resolution are for the Nearest origin, while Distances holds the
+// distance from each origin.
 type StoreWithDistance struct {
 	StoreID       int
 	StoreName     string
 	RegionName    string
 	Latitude      float64
 	Longitude     float64
+	ClosingTime   string
 	DistanceMiles float64
+	DistanceKm    float64
+	Unit          Unit
+	Resolution    Resolution
+	Nearest       string
+	Distances     []OriginDistance
 }
 
+// OriginDistance is the distance of a store from a labelled origin.
+type OriginDistance struct {
+	Origin        string
+	DistanceMiles float64
+	DistanceKm    float64
+	Resolution    Resolution
+}
+
+// Distance returns the distance from the nearest origin in Unit.
+func (s StoreWithDistance) Distance() float64 {
+	if s.Unit == Kilometres {
+		return s.DistanceKm
+	}
+	return s.DistanceMiles
+}
+
+// FormatDistance returns the distance from the nearest origin in Unit,
+// prefixed with "~" if the origin was approximately located, or an
+// empty string if there is no distance.
+func (s StoreWithDistance) FormatDistance() string {
+	if s.StoreID == 0 || s.Resolution == ResolutionNone {
+		return ""
+	}
+	approx := ""
+	if s.Resolution.Approximate() {
+		approx = "~"
+	}
+	return approx + s.Unit.Format(s.Distance())
+}
+
+// String returns the store name and distance, if any, labelled with
+// the nearest origin if there are several. Distances from
+// approximately located origins are prefixed with "~".
 func (s StoreWithDistance) String() string {
-	tplEmpty := "%s"
-	tplShort := "%s (%.1fmi)"
-	tplLong := "%s (%.fmi)"
-	if s.StoreID == 0 {
-		return fmt.Sprintf(tplEmpty, s.StoreName)
+	if s.StoreID == 0 || s.Resolution == ResolutionNone {
+		return s.StoreName
 	}
-	if s.DistanceMiles <= 10 {
-		return fmt.Sprintf(tplShort, s.StoreName, s.DistanceMiles)
+	approx := ""
+	if s.Resolution.Approximate() {
+		approx = "~"
 	}
-	return fmt.Sprintf(tplLong, s.StoreName, s.DistanceMiles)
+	nearest := ""
+	if s.Nearest != "" {
+		nearest = " " + s.Nearest
+	}
+	return fmt.Sprintf("%s (%s%s%s)", s.StoreName, approx, s.Unit.Format(s.Distance()), nearest)
+}
+
+// Within reports if the store is located within miles of any origin.
+func (s StoreWithDistance) Within(miles float64) bool {
+	return s.StoreID != 0 && s.Resolution != ResolutionNone && s.DistanceMiles <= miles
 }
 
 // storeSorter sorts a slice of StoreWithDistance, pulled out for
@@ -45,56 +104,182 @@ func storeSorter(fss []StoreWithDistance) {
 type StoreDistances struct {
 	stores         *stores
 	locationFinder *locationFinder
+	snapshot       storesSnapshot
+	aliases        *AliasRegistry
+	unit           Unit
+	observer       Observer
+	transport      http.RoundTripper
+
+	// matches caches the stores API names of search API store names,
+	// with unmatched names recorded as an empty string. The cache is
+	// reset when the stores generation changes.
+	matchMu  sync.Mutex
+	matches  map[string]string
+	matchGen int
+}
+
+// Option is a functional option for configuring StoreDistances.
+type Option func(*StoreDistances)
+
+// WithStoreCacheFile saves the store data to path each time it is
+// retrieved, and loads it from path if the stores can't be retrieved at
+// startup.
+func WithStoreCacheFile(path string) Option {
+	return func(sd *StoreDistances) {
+		sd.snapshot.cacheFile = path
+	}
+}
+
+// WithTransport retrieves the store locations from Cex with transport,
+// such as one using a proxy. Postcode and place lookups are not
+// affected.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(sd *StoreDistances) {
+		sd.transport = transport
+	}
+}
+
+// WithEmbeddedStores loads the snapshot of store data embedded in the
+// module if the stores can't be retrieved at startup, or loaded from
+// the cache file.
+func WithEmbeddedStores() Option {
+	return func(sd *StoreDistances) {
+		sd.snapshot.embedded = true
+	}
+}
+
+// WithAliases replaces the default store name alias registry.
+func WithAliases(aliases *AliasRegistry) Option {
+	return func(sd *StoreDistances) {
+		sd.aliases = aliases
+	}
+}
+
+// WithUnit sets the unit used to format distances. The default is
+// Miles.
+func WithUnit(unit Unit) Option {
+	return func(sd *StoreDistances) {
+		sd.unit = unit
+	}
+}
+
+// WithLocationCache sets the maximum number of locations cached and
+// the time for which they are cached. The least recently used
+// locations are evicted when the cache is full. A size of 0 disables
+// the cache.
+func WithLocationCache(size int, ttl time.Duration) Option {
+	return func(sd *StoreDistances) {
+		sd.locationFinder.cache.capacity = size
+		sd.locationFinder.cache.ttl = ttl
+	}
+}
+
+// WithLocationNotFoundTTL sets the time for which postcodes and places
+// which could not be found are cached, to avoid repeated lookups. A
+// ttl of 0 disables caching of locations not found.
+func WithLocationNotFoundTTL(ttl time.Duration) Option {
+	return func(sd *StoreDistances) {
+		sd.locationFinder.cache.notFoundTTL = ttl
+	}
+}
+
+// WithLocationCacheFile loads the location cache from path, if it
+// exists, at startup. SaveLocationCache saves the cache to path.
+func WithLocationCacheFile(path string) Option {
+	return func(sd *StoreDistances) {
+		sd.locationFinder.cache.file = path
+	}
 }
 
 // NewStoreDistances initialises a StoresDistance instance, and passes a
 // testing flag to the stores initaliser. In production,
 // initialiseStores should be true
-func NewStoreDistances(initialiseStores bool) *StoreDistances {
+func NewStoreDistances(initialiseStores bool, options ...Option) *StoreDistances {
 	s := StoreDistances{
-		stores:         newStores(initialiseStores),
 		locationFinder: newLocationFinder(),
+		aliases:        DefaultAliasRegistry(),
+		matches:        map[string]string{},
+	}
+	for _, o := range options {
+		o(&s)
 	}
+	if err := s.locationFinder.cache.load(); err != nil {
+		log.Printf("location cache error: %v", err)
+	}
+	s.stores = newStores(initialiseStores, s.snapshot, s.observer, s.transport)
 	return &s
 }
 
+// SetGeocoder replaces the default geocoder, which uses the
+// postcodes.io web service with a fallback to an embedded outward code
+// table. SetGeocoder should be called before any distances are
+// calculated.
+func (sd *StoreDistances) SetGeocoder(g Geocoder) {
+	sd.locationFinder.Lock()
+	defer sd.locationFinder.Unlock()
+	sd.locationFinder.geocoder = g
+}
+
 // Distances finds the distances of the named stores from postcode and
 // returns a slice of StoreWithDistance sorted by increasing distance
 func (sd *StoreDistances) Distances(postcode string, storeNames []string) ([]StoreWithDistance, error) {
+	return sd.DistancesFrom(storeNames, PostcodeOrigin(postcode))
+}
+
+// DistancesFrom finds the distances of the named stores from one or
+// more origins and returns a slice of StoreWithDistance sorted by
+// increasing distance from the nearest origin. The origins are
+// validated before any network lookups.
+func (sd *StoreDistances) DistancesFrom(storeNames []string, origins ...Origin) ([]StoreWithDistance, error) {
 
 	foundStores := []StoreWithDistance{}
 
-	// return sparse stores if no postcode is provided or stores haven't
+	origins = slices.DeleteFunc(slices.Clone(origins), Origin.IsZero)
+
+	// return sparse stores if no origin is provided or stores haven't
 	// been initialised.
-	if postcode == "" || !sd.IsOperational() {
+	if len(origins) == 0 || !sd.IsOperational() {
 		foundStores := []StoreWithDistance{}
 		for _, name := range storeNames {
-			swd := StoreWithDistance{StoreName: name}
+			swd := StoreWithDistance{StoreName: sd.aliases.DisplayName(name), Unit: sd.unit}
 			foundStores = append(foundStores, swd)
 		}
 		storeSorter(foundStores)
 		return foundStores, nil
 	}
 
-	location, err := sd.locationFinder.getLocationFromPostcode(postcode)
+	locations, err := sd.resolve(origins)
 	if err != nil {
-		return nil, fmt.Errorf("could not resolve postcode: %w", err)
+		return nil, err
 	}
-	locationCoord := coord{Lat: location.Latitude, Lon: location.Longitude}
 
-	// hope the API uses consistent store names
+	// store names from the search API are matched to the stores API
 	for _, name := range storeNames {
-		fs := StoreWithDistance{StoreName: name}
-		thisStore, ok := sd.stores.get(name)
+		fs := StoreWithDistance{StoreName: sd.aliases.DisplayName(name), Unit: sd.unit}
+		thisStore, ok := sd.lookup(name)
 		if ok { // allow sparse stores
-			fs.StoreID = thisStore.StoreID
-			fs.RegionName = thisStore.RegionName
-			fs.Latitude = thisStore.Latitude
-			fs.Longitude = thisStore.Longitude
-
-			// haversine distance in miles
-			storeCoord := coord{Lat: fs.Latitude, Lon: fs.Longitude}
-			fs.DistanceMiles, _ = haversineDistance(locationCoord, storeCoord) // mi, km
+			sd.setDistances(&fs, thisStore, origins, locations)
+		}
+		foundStores = append(foundStores, fs)
+	}
+	storeSorter(foundStores)
+	return foundStores, nil
+}
+
+// StoresNamed is the same as DistancesFrom but, where no origins are
+// provided, still looks up the details of each store, such as its
+// region and closing time. Stores which can't be matched to a store
+// location have only a name.
+func (sd *StoreDistances) StoresNamed(storeNames []string, origins ...Origin) ([]StoreWithDistance, error) {
+	origins = slices.DeleteFunc(slices.Clone(origins), Origin.IsZero)
+	if len(origins) > 0 {
+		return sd.DistancesFrom(storeNames, origins...)
+	}
+	foundStores := []StoreWithDistance{}
+	for _, name := range storeNames {
+		fs := StoreWithDistance{StoreName: sd.aliases.DisplayName(name), Unit: sd.unit}
+		if thisStore, ok := sd.lookup(name); ok {
+			sd.setDistances(&fs, thisStore, nil, nil)
 		}
 		foundStores = append(foundStores, fs)
 	}
@@ -102,6 +287,199 @@ func (sd *StoreDistances) Distances(postcode string, storeNames []string) ([]Sto
 	return foundStores, nil
 }
 
+// Stores returns all known stores. If origins are provided, the stores
+// are sorted by increasing distance from the nearest origin, otherwise
+// by name.
+func (sd *StoreDistances) Stores(origins ...Origin) ([]StoreWithDistance, error) {
+
+	origins = slices.DeleteFunc(slices.Clone(origins), Origin.IsZero)
+	locations, err := sd.resolve(origins)
+	if err != nil {
+		return nil, err
+	}
+
+	allStores := []StoreWithDistance{}
+	for _, st := range sd.stores.all() {
+		fs := StoreWithDistance{StoreName: st.StoreName, Unit: sd.unit}
+		sd.setDistances(&fs, st, origins, locations)
+		allStores = append(allStores, fs)
+	}
+	storeSorter(allStores)
+	return allStores, nil
+}
+
+// Locate resolves an origin to a location, such as for plotting it on
+// a map. Postcodes and places are cached, so locating an origin already
+// used for distances makes no further lookups.
+func (sd *StoreDistances) Locate(origin Origin) (*Location, error) {
+	return sd.locationFinder.getLocation(origin)
+}
+
+// resolve resolves each origin to a location.
+func (sd *StoreDistances) resolve(origins []Origin) ([]*Location, error) {
+	locations := make([]*Location, len(origins))
+	for i, origin := range origins {
+		location, err := sd.locationFinder.getLocation(origin)
+		if err != nil {
+			return nil, fmt.Errorf("could not resolve %s: %w", origin.Label(), err)
+		}
+		locations[i] = location
+	}
+	return locations, nil
+}
+
+// setDistances sets the store details of fs from st, together with the
+// haversine distance in miles and km from each origin location.
+func (sd *StoreDistances) setDistances(fs *StoreWithDistance, st store, origins []Origin, locations []*Location) {
+	fs.StoreID = st.StoreID
+	fs.RegionName = st.RegionName
+	fs.Latitude = st.Latitude
+	fs.Longitude = st.Longitude
+	fs.ClosingTime = st.ClosingTime
+
+	storeCoord := coord{Lat: fs.Latitude, Lon: fs.Longitude}
+	for i, location := range locations {
+		locationCoord := coord{Lat: location.Latitude, Lon: location.Longitude}
+		od := OriginDistance{
+			Origin:     origins[i].Label(),
+			Resolution: location.Resolution,
+		}
+		od.DistanceMiles, od.DistanceKm = haversineDistance(locationCoord, storeCoord)
+		if i == 0 || od.DistanceMiles < fs.DistanceMiles {
+			fs.DistanceMiles = od.DistanceMiles
+			fs.DistanceKm = od.DistanceKm
+			fs.Resolution = od.Resolution
+			fs.Nearest = od.Origin
+		}
+		fs.Distances = append(fs.Distances, od)
+	}
+	if len(locations) == 1 {
+		fs.Nearest = ""
+	}
+}
+
+// lookup finds the store for a search API store name, matching it to a
+// stores API name if necessary.
+func (sd *StoreDistances) lookup(name string) (store, bool) {
+	if st, ok := sd.stores.get(name); ok {
+		return st, true
+	}
+
+	sd.matchMu.Lock()
+	defer sd.matchMu.Unlock()
+	if gen := sd.stores.gen(); gen != sd.matchGen {
+		sd.matches = map[string]string{}
+		sd.matchGen = gen
+	}
+	storeName, ok := sd.matches[name]
+	if !ok {
+		storeName = sd.match(name)
+		sd.matches[name] = storeName
+		if storeName == "" {
+			log.Printf("store %q could not be matched", name)
+		}
+	}
+	if storeName == "" {
+		return store{}, false
+	}
+	return sd.stores.get(storeName)
+}
+
+// minStoreSimilarity is the minimum similarity for a fuzzy store name
+// match.
+const minStoreSimilarity = 0.8
+
+// match matches a search API store name to a stores API store name
+// using, in order, the alias registry, normalised names and then fuzzy
+// matching. An empty string is returned if no single store matches.
+func (sd *StoreDistances) match(name string) string {
+	all := sd.stores.all()
+
+	if a, ok := sd.aliases.lookup(name); ok {
+		if a.Store != "" {
+			if _, ok := sd.stores.get(a.Store); ok {
+				return a.Store
+			}
+		}
+		found := []string{}
+		for _, st := range all {
+			if strings.Contains(st.StoreName, a.Match) {
+				found = append(found, st.StoreName)
+			}
+		}
+		if len(found) == 1 {
+			return found[0]
+		}
+	}
+
+	normalised := normaliseStoreName(name)
+	for _, st := range all {
+		if normaliseStoreName(st.StoreName) == normalised {
+			return st.StoreName
+		}
+	}
+
+	best, bestScore, ties := "", 0.0, 0
+	for _, st := range all {
+		score := storeNameSimilarity(normalised, normaliseStoreName(st.StoreName))
+		switch {
+		case score > bestScore:
+			best, bestScore, ties = st.StoreName, score, 1
+		case score == bestScore:
+			ties++
+		}
+	}
+	if bestScore >= minStoreSimilarity && ties == 1 {
+		return best
+	}
+	return ""
+}
+
+// UnmatchedStores reports the search API store names which could not
+// be matched to a store from the stores API, and which therefore have
+// no distances. These may be fixed by adding an Alias.
+func (sd *StoreDistances) UnmatchedStores() []string {
+	sd.matchMu.Lock()
+	defer sd.matchMu.Unlock()
+	unmatched := []string{}
+	for name, storeName := range sd.matches {
+		if storeName == "" {
+			unmatched = append(unmatched, name)
+		}
+	}
+	slices.Sort(unmatched)
+	return unmatched
+}
+
+// StoresAge reports the age of the store data used for distance
+// calculations and where it was loaded from. A StoresNone source
+// indicates no store data is available.
+func (sd *StoreDistances) StoresAge() (time.Duration, StoresSource) {
+	return sd.stores.age()
+}
+
+// StoresStatus reports the state of the store location data, including
+// the outcome of the last attempt to refresh it.
+func (sd *StoreDistances) StoresStatus() StoresStatus {
+	return sd.stores.status()
+}
+
+// SaveLocationCache saves the location cache to the file set with
+// WithLocationCacheFile, if any.
+func (sd *StoreDistances) SaveLocationCache() error {
+	return sd.locationFinder.cache.save()
+}
+
+// LocationCacheStats reports the size and use of the location cache.
+func (sd *StoreDistances) LocationCacheStats() LocationCacheStats {
+	return sd.locationFinder.cache.statistics()
+}
+
+// Unit returns the unit used to format distances.
+func (sd *StoreDistances) Unit() Unit {
+	return sd.unit
+}
+
 // IsOperational determines if the stores have been initalised and
 // therefore if distances are possible to be calculated
 func (sd *StoreDistances) IsOperational() bool {
@@ -1,6 +1,7 @@
 package location
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"testing"
@@ -42,6 +43,10 @@ func TestPrintStoredDistances(t *testing.T) {
 		StoreID       int
 		StoreName     string
 		DistanceMiles float64
+		DistanceKm    float64
+		Unit          Unit
+		Resolution    Resolution
+		Nearest       string
 		expected      string
 	}{
 		{
@@ -54,20 +59,69 @@ func TestPrintStoredDistances(t *testing.T) {
 			StoreID:       1,
 			StoreName:     "store 1",
 			DistanceMiles: 1.22567,
+			Resolution:    ResolutionPostcode,
 			expected:      "store 1 (1.2mi)",
 		},
 		{
 			StoreID:       2,
 			StoreName:     "store two",
 			DistanceMiles: 3.5111,
+			Resolution:    ResolutionPostcode,
 			expected:      "store two (3.5mi)",
 		},
 		{
 			StoreID:       3,
 			StoreName:     "store Three",
 			DistanceMiles: 10.9999,
+			Resolution:    ResolutionPostcode,
 			expected:      "store Three (11mi)",
 		},
+		{
+			StoreID:       4,
+			StoreName:     "store four",
+			DistanceMiles: 2.25,
+			Resolution:    ResolutionOutcode,
+			expected:      "store four (~2.2mi)",
+		},
+		{
+			StoreID:       5,
+			StoreName:     "store five",
+			DistanceMiles: 25.2,
+			Resolution:    ResolutionPostcode,
+			expected:      "store five (25mi)",
+		},
+		{
+			StoreID:       6,
+			StoreName:     "store six",
+			DistanceMiles: 3.5111,
+			Resolution:    ResolutionPostcode,
+			Nearest:       "work",
+			expected:      "store six (3.5mi work)",
+		},
+		{
+			StoreID:       7,
+			StoreName:     "store seven",
+			DistanceMiles: 5.6,
+			DistanceKm:    9.01,
+			Unit:          Kilometres,
+			Resolution:    ResolutionPostcode,
+			expected:      "store seven (9.0km)",
+		},
+		{
+			StoreID:       8,
+			StoreName:     "store eight",
+			DistanceMiles: 6.9,
+			DistanceKm:    11.1,
+			Unit:          Kilometres,
+			Resolution:    ResolutionOutcode,
+			Nearest:       "home",
+			expected:      "store eight (~11km home)",
+		},
+		{
+			StoreID:   9,
+			StoreName: "store nine",
+			expected:  "store nine",
+		},
 	}
 	for i, tt := range tests {
 		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
@@ -75,6 +129,10 @@ func TestPrintStoredDistances(t *testing.T) {
 				StoreID:       tt.StoreID,
 				StoreName:     tt.StoreName,
 				DistanceMiles: tt.DistanceMiles,
+				DistanceKm:    tt.DistanceKm,
+				Unit:          tt.Unit,
+				Resolution:    tt.Resolution,
+				Nearest:       tt.Nearest,
 			}
 			output := fmt.Sprint(swd)
 			if got, want := output, tt.expected; got != want {
@@ -133,3 +191,103 @@ func TestStoreSorting(t *testing.T) {
 		})
 	}
 }
+
+func TestAllStores(t *testing.T) {
+
+	sd := NewStoreDistances(false)
+	sd.stores.Lock()
+	sd.stores.storeMap["Havant"] = store{StoreID: 3058, StoreName: "Havant", Latitude: 50.852325, Longitude: -0.982041}
+	sd.stores.storeMap["Walthamstow"] = store{StoreID: 145, StoreName: "Walthamstow", Latitude: 51.583371, Longitude: -0.023809}
+	sd.stores.initialised = true
+	sd.stores.Unlock()
+
+	tests := []struct {
+		origins  []Origin
+		expected []string
+	}{
+		{nil, []string{"Havant", "Walthamstow"}},
+		{[]Origin{CoordOrigin(51.58, -0.02)}, []string{"Walthamstow", "Havant"}},
+		{[]Origin{CoordOrigin(50.85, -1.0)}, []string{"Havant", "Walthamstow"}},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			swd, err := sd.Stores(tt.origins...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, s := range swd {
+				got = append(got, s.StoreName)
+				if s.StoreID == 0 {
+					t.Errorf("store %s has no id", s.StoreName)
+				}
+				if got, want := len(s.Distances), len(tt.origins); got != want {
+					t.Errorf("got %d want %d distances", got, want)
+				}
+			}
+			if diff := cmp.Diff(tt.expected, got); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if _, err := sd.Stores(CoordOrigin(95, 0)); err == nil {
+		t.Error("expected an invalid origin error")
+	}
+}
+
+func TestLocate(t *testing.T) {
+	sd := NewStoreDistances(false)
+	l, err := sd.Locate(CoordOrigin(51.58, -0.02))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprintf("%.2f,%.2f %s", l.Latitude, l.Longitude, l.Resolution), "51.58,-0.02 coordinate"; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+	if _, err := sd.Locate(CoordOrigin(95, 0)); !errors.Is(err, ErrInvalidCoordinate) {
+		t.Errorf("got error %v want %v", err, ErrInvalidCoordinate)
+	}
+}
+
+func TestStoresNamed(t *testing.T) {
+
+	sd := NewStoreDistances(false)
+	sd.stores.Lock()
+	sd.stores.storeMap["Havant"] = store{StoreID: 3058, StoreName: "Havant", RegionName: "South", Latitude: 50.852325, Longitude: -0.982041, ClosingTime: "17:30"}
+	sd.stores.storeMap["Walthamstow"] = store{StoreID: 145, StoreName: "Walthamstow", Latitude: 51.583371, Longitude: -0.023809, ClosingTime: "18:00"}
+	sd.stores.initialised = true
+	sd.stores.Unlock()
+
+	// without origins the store details are still found
+	swd, err := sd.StoresNamed([]string{"Walthamstow", "Havant", "Atlantis"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []StoreWithDistance{
+		{StoreName: "Atlantis"},
+		{StoreID: 3058, StoreName: "Havant", RegionName: "South", Latitude: 50.852325, Longitude: -0.982041, ClosingTime: "17:30"},
+		{StoreID: 145, StoreName: "Walthamstow", Latitude: 51.583371, Longitude: -0.023809, ClosingTime: "18:00"},
+	}
+	if diff := cmp.Diff(want, swd); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	if got := swd[1].FormatDistance(); got != "" {
+		t.Errorf("got distance %q for a store without an origin", got)
+	}
+	if got, want := swd[1].String(), "Havant"; got != want {
+		t.Errorf("got %q want %q for a store without an origin", got, want)
+	}
+
+	// with an origin the stores are sorted by distance
+	swd, err = sd.StoresNamed([]string{"Havant", "Walthamstow"}, CoordOrigin(51.58, -0.02))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := swd[0].StoreName+" "+swd[0].ClosingTime, "Walthamstow 18:00"; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+	if swd[0].Resolution == ResolutionNone {
+		t.Error("expected a distance from the origin")
+	}
+}
@@ -1,12 +1,16 @@
 package location
 
 import (
+	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"strings"
+	"os"
+	"path/filepath"
+	"slices"
 	"sync"
 	"time"
 )
@@ -32,13 +36,58 @@ type storeLocations struct {
 
 // Store is a store rationalised from storeLocations
 type store struct {
-	StoreID    int
-	StoreName  string
-	RegionName string
-	Latitude   float64
-	Longitude  float64
+	StoreID     int
+	StoreName   string
+	RegionName  string
+	Latitude    float64
+	Longitude   float64
+	ClosingTime string
 }
 
+// StoresSource describes where the store data in use was loaded from.
+type StoresSource int
+
+const (
+	StoresNone     StoresSource = iota // no store data
+	StoresLive                         // the Cex stores endpoint
+	StoresCache                        // the snapshot cache file
+	StoresEmbedded                     // the snapshot embedded in the module
+)
+
+func (s StoresSource) String() string {
+	switch s {
+	case StoresNone:
+		return "none"
+	case StoresLive:
+		return "live"
+	case StoresCache:
+		return "cache"
+	case StoresEmbedded:
+		return "embedded"
+	}
+	return fmt.Sprintf("StoresSource(%d)", int(s))
+}
+
+// storesSnapshot configures where snapshots of the store data are
+// saved and loaded from.
+type storesSnapshot struct {
+	cacheFile string // saved on each refresh, loaded if the first refresh fails
+	embedded  bool   // load the embedded snapshot if all else fails
+}
+
+// snapshotFile is the on-disk format of a store snapshot.
+type snapshotFile struct {
+	Fetched time.Time `json:"fetched"`
+	Stores  []store   `json:"stores"`
+}
+
+// embeddedSnapshot is a snapshot of the stores shipped with the module,
+// which may be refreshed with "go generate".
+//
+//go:generate go run ./internal/snapshotgen data/stores.json
+//go:embed data/stores.json
+var embeddedSnapshot []byte
+
 // stores is a collection of store safe for concurrent access. The Store
 // cache is updated once a day.
 type stores struct {
@@ -46,30 +95,48 @@ type stores struct {
 	sync.RWMutex
 	initialised bool
 	update      *time.Ticker
+	snapshot    storesSnapshot
+	fetched     time.Time    // when the store data was retrieved
+	source      StoresSource // where the store data was loaded from
+	generation  int          // incremented on each load
+	observer    Observer
+	transport   http.RoundTripper // for retrieving the stores, if not the default
+	lastAttempt time.Time         // the last attempt to retrieve the stores
+	lastErr     error             // the error from the last attempt
+	failures    int               // consecutive failed attempts
 }
 
 var tickerOKDuration time.Duration = time.Minute * 60 * 24
 var tickerProblemDuration time.Duration = time.Minute * 10
 
 // newStores initialises a concurrent safe stores struct. The stores are
-// only initialised if true, which is the default in production.
-func newStores(initialiseStores bool) *stores {
+// only initialised if true, which is the default in production. If the
+// initial retrieval fails the stores are loaded from the snapshot cache
+// file or the embedded snapshot, if configured, and retrieval is retried
+// on the shorter problem schedule.
+func newStores(initialiseStores bool, snapshot storesSnapshot, observer Observer, transport http.RoundTripper) *stores {
 	s := stores{
-		storeMap: map[string]store{},
-		update:   time.NewTicker(tickerOKDuration),
+		storeMap:  map[string]store{},
+		update:    time.NewTicker(tickerOKDuration),
+		snapshot:  snapshot,
+		observer:  observer,
+		transport: transport,
 	}
 	if initialiseStores {
-		err := s.getStoreLocations()
+		err := s.refresh()
 		if err != nil {
 			log.Printf("store update error %s", err)
 			s.update.Reset(tickerProblemDuration)
+			if err := s.loadSnapshot(); err != nil {
+				log.Printf("store snapshot error %s", err)
+			}
 		} else {
 			s.initialised = true
 		}
 	}
 	go func() {
 		for range s.update.C {
-			err := s.getStoreLocations()
+			err := s.refresh()
 			if err != nil {
 				s.Lock()
 				s.update.Reset(tickerProblemDuration)
@@ -100,29 +167,74 @@ func (s *stores) isInitialised() bool {
 	return s.initialised
 }
 
+// age reports the age of the store data and where it was loaded from.
+func (s *stores) age() (time.Duration, StoresSource) {
+	s.RLock()
+	defer s.RUnlock()
+	if s.source == StoresNone {
+		return 0, StoresNone
+	}
+	return time.Since(s.fetched), s.source
+}
+
 func (s *stores) length() int {
 	s.RLock()
 	defer s.RUnlock()
 	return len(s.storeMap)
 }
 
-func (s *stores) addAliases() {
-	simpleMap := map[string]string{
-		"Tottenham Crt Rd": "London W1 TCR",
-		"Rathbone Place":   "London W1 Rathbone",
+// all returns all stores.
+func (s *stores) all() []store {
+	s.RLock()
+	defer s.RUnlock()
+	all := make([]store, 0, len(s.storeMap))
+	for _, st := range s.storeMap {
+		all = append(all, st)
 	}
+	return all
+}
+
+// gen returns the generation of the stores, which increments each time
+// the stores are loaded.
+func (s *stores) gen() int {
+	s.RLock()
+	defer s.RUnlock()
+	return s.generation
+}
+
+// refresh retrieves the store locations, recording the outcome.
+func (s *stores) refresh() error {
+	start := time.Now()
+	err := s.getStoreLocations()
 	s.Lock()
-	defer s.Unlock()
-LOOP:
-	for k, v := range s.storeMap {
-		for k2, v2 := range simpleMap {
-			if strings.Contains(k, k2) {
-				// make a new entry in the stores map
-				s.storeMap[v2] = v
-				continue LOOP
-			}
-		}
+	s.lastAttempt = start
+	s.lastErr = err
+	if err != nil {
+		s.failures++
+	} else {
+		s.failures = 0
+	}
+	s.Unlock()
+	s.observer.storesRefresh(start, err)
+	return err
+}
+
+// status reports the state of the stores.
+func (s *stores) status() StoresStatus {
+	s.RLock()
+	defer s.RUnlock()
+	st := StoresStatus{
+		Initialised: s.initialised,
+		Source:      s.source,
+		Count:       len(s.storeMap),
+		LastAttempt: s.lastAttempt,
+		LastError:   s.lastErr,
+		Failures:    s.failures,
 	}
+	if s.source != StoresNone {
+		st.Age = time.Since(s.fetched)
+	}
+	return st
 }
 
 // getStoreLocations gets the store locations from the storeURL and
@@ -131,7 +243,8 @@ func (s *stores) getStoreLocations() error {
 
 	var jsonStores storeLocations
 	client := &http.Client{
-		Timeout: 2 * time.Second,
+		Timeout:   2 * time.Second,
+		Transport: s.transport,
 	}
 	response, err := client.Get(storeURL)
 	if err != nil {
@@ -149,18 +262,103 @@ func (s *stores) getStoreLocations() error {
 		return fmt.Errorf("unmarshal error: %w", err)
 	}
 
+	if len(jsonStores.Response.Data.Stores) == 0 {
+		return errors.New("no stores found")
+	}
+
 	s.Lock()
 	for _, jStore := range jsonStores.Response.Data.Stores {
 		// fmt.Printf("%3d %20s lat %5.8f long %5.8f\n", store.StoreID, store.StoreName, store.Latitude, store.Longitude)
 		s.storeMap[jStore.StoreName] = store{
-			StoreID:    jStore.StoreID,
-			StoreName:  jStore.StoreName,
-			RegionName: jStore.RegionName,
-			Latitude:   jStore.Latitude,
-			Longitude:  jStore.Longitude,
+			StoreID:     jStore.StoreID,
+			StoreName:   jStore.StoreName,
+			RegionName:  jStore.RegionName,
+			Latitude:    jStore.Latitude,
+			Longitude:   jStore.Longitude,
+			ClosingTime: jStore.ClosingTime,
+		}
+	}
+	s.fetched = time.Now()
+	s.source = StoresLive
+	s.generation++
+	s.Unlock()
+
+	if s.snapshot.cacheFile != "" {
+		if err := s.saveSnapshot(s.snapshot.cacheFile); err != nil {
+			log.Printf("store snapshot save error %s", err)
+		}
+	}
+	return nil
+}
+
+// saveSnapshot saves the stores to path.
+func (s *stores) saveSnapshot(path string) error {
+	s.RLock()
+	snap := snapshotFile{Fetched: s.fetched}
+	for _, st := range s.storeMap {
+		snap.Stores = append(snap.Stores, st)
+	}
+	s.RUnlock()
+	slices.SortFunc(snap.Stores, func(a, b store) int { return a.StoreID - b.StoreID })
+
+	contents, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// write and rename to avoid leaving a partial snapshot
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, contents, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadSnapshot loads the stores from the cache file, falling back to
+// the embedded snapshot, if each is configured.
+func (s *stores) loadSnapshot() error {
+	var errs []error
+	if s.snapshot.cacheFile != "" {
+		contents, err := os.ReadFile(s.snapshot.cacheFile)
+		if err == nil {
+			err = s.useSnapshot(contents, StoresCache)
 		}
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("cache file: %w", err))
+	}
+	if s.snapshot.embedded {
+		err := s.useSnapshot(embeddedSnapshot, StoresEmbedded)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("embedded: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// useSnapshot unmarshals a snapshot into the stores map, marking the
+// stores as initialised.
+func (s *stores) useSnapshot(contents []byte, source StoresSource) error {
+	var snap snapshotFile
+	if err := json.Unmarshal(contents, &snap); err != nil {
+		return fmt.Errorf("unmarshal error: %w", err)
+	}
+	if len(snap.Stores) == 0 {
+		return errors.New("snapshot contains no stores")
+	}
+	s.Lock()
+	for _, st := range snap.Stores {
+		s.storeMap[st.StoreName] = st
 	}
+	s.fetched = snap.Fetched
+	s.source = source
+	s.initialised = true
+	s.generation++
 	s.Unlock()
-	s.addAliases()
+	log.Printf("stores loaded from %s snapshot of %s", source, snap.Fetched.Format(time.DateOnly))
 	return nil
 }
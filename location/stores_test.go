@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -22,12 +23,12 @@ func TestGetStores(t *testing.T) {
 	// repoint url
 	storeURL = svr.URL
 
-	stores := newStores(false)
+	stores := newStores(false, storesSnapshot{}, Observer{}, nil)
 	if stores.isInitialised() {
 		t.Fatal("initialisation should have failed")
 	}
 
-	stores = newStores(true)
+	stores = newStores(true, storesSnapshot{}, Observer{}, nil)
 	if !stores.isInitialised() {
 		t.Fatal("initialisation should be ok")
 	}
@@ -36,7 +37,7 @@ func TestGetStores(t *testing.T) {
 		consider a way of iterating over stores
 	*/
 
-	if got, want := stores.length(), 4; got != want {
+	if got, want := stores.length(), 2; got != want {
 		t.Errorf("got %d want %d stores", got, want)
 	}
 
@@ -49,7 +50,9 @@ func TestGetStores(t *testing.T) {
 		t.Errorf("got %d want %d storeid", got, want)
 	}
 
-	_, ok = stores.get("London W1 Rathbone")
+	sd := NewStoreDistances(false)
+	sd.stores = stores
+	_, ok = sd.lookup("London W1 Rathbone")
 	if !ok {
 		t.Error("expected alias value for London - W1 Rathbone Place")
 		return
@@ -65,3 +68,113 @@ func TestGetStores(t *testing.T) {
 	}
 
 }
+
+func TestStoresSnapshot(t *testing.T) {
+
+	testdata, err := os.ReadFile("testdata/stores.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	okSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, string(testdata))
+	}))
+	defer okSvr.Close()
+	failSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failSvr.Close()
+
+	cacheFile := filepath.Join(t.TempDir(), "cexfind", "stores.json")
+
+	// a successful retrieval saves the cache file
+	storeURL = okSvr.URL
+	s := newStores(true, storesSnapshot{cacheFile: cacheFile}, Observer{}, nil)
+	if _, source := s.age(); source != StoresLive {
+		t.Errorf("got source %s want %s", source, StoresLive)
+	}
+	if _, err := os.Stat(cacheFile); err != nil {
+		t.Fatalf("cache file not saved: %v", err)
+	}
+
+	// a failed retrieval loads the cache file
+	storeURL = failSvr.URL
+	s = newStores(true, storesSnapshot{cacheFile: cacheFile}, Observer{}, nil)
+	if !s.isInitialised() {
+		t.Fatal("stores should be initialised from the cache file")
+	}
+	age, source := s.age()
+	if got, want := source, StoresCache; got != want {
+		t.Errorf("got source %s want %s", got, want)
+	}
+	if age > time.Minute {
+		t.Errorf("cache age %s unexpectedly old", age)
+	}
+	if got, want := s.length(), 2; got != want {
+		t.Errorf("got %d want %d stores", got, want)
+	}
+
+	// a missing cache file falls back to the shipped embedded snapshot,
+	// reported with its capture time
+	s = newStores(true, storesSnapshot{cacheFile: cacheFile + ".missing", embedded: true}, Observer{}, nil)
+	if !s.isInitialised() {
+		t.Fatal("stores should be initialised from the embedded snapshot")
+	}
+	if s.length() == 0 {
+		t.Error("embedded snapshot has no stores")
+	}
+	age, source = s.age()
+	if source != StoresEmbedded {
+		t.Errorf("got source %s want %s", source, StoresEmbedded)
+	}
+	if age <= 0 || age > time.Since(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("embedded snapshot age %s has no capture time", age)
+	}
+	if _, ok := s.get("London - W1 Tottenham Crt Rd"); !ok {
+		t.Error("expected store from embedded snapshot")
+	}
+
+	// no snapshot configured
+	s = newStores(true, storesSnapshot{}, Observer{}, nil)
+	if s.isInitialised() {
+		t.Error("stores should not be initialised")
+	}
+	if _, source := s.age(); source != StoresNone {
+		t.Errorf("got source %s want %s", source, StoresNone)
+	}
+}
+
+// roundTripFunc is an http.RoundTripper func
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// TestStoresTransport checks the stores are retrieved with the
+// transport provided by WithTransport
+func TestStoresTransport(t *testing.T) {
+
+	testdata, err := os.ReadFile("testdata/stores.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, string(testdata))
+	}))
+	defer svr.Close()
+	storeURL = svr.URL
+
+	requested := []string{}
+	transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		requested = append(requested, r.URL.String())
+		return http.DefaultTransport.RoundTrip(r)
+	})
+
+	sd := NewStoreDistances(true, WithTransport(transport))
+	if !sd.IsOperational() {
+		t.Fatal("expected stores to be retrieved")
+	}
+	if got, want := fmt.Sprint(requested), fmt.Sprintf("[%s]", svr.URL); got != want {
+		t.Errorf("got requests %s want %s", got, want)
+	}
+}
@@ -0,0 +1,74 @@
+package location
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Unit is a unit of distance. The zero value is Miles.
+type Unit int
+
+const (
+	Miles Unit = iota
+	Kilometres
+)
+
+// kmPerMile converts miles to kilometres.
+const kmPerMile = 1.609344
+
+// shortDistance is the distance, in either unit, at or below which
+// distances are formatted to one decimal place.
+const shortDistance = 10
+
+// String returns the abbreviation of the unit.
+func (u Unit) String() string {
+	if u == Kilometres {
+		return "km"
+	}
+	return "mi"
+}
+
+// Name returns the plural name of the unit.
+func (u Unit) Name() string {
+	if u == Kilometres {
+		return "kilometres"
+	}
+	return "miles"
+}
+
+// ParseUnit parses a unit name or abbreviation, such as "mi", "miles",
+// "km" or "kilometres". An empty string returns Miles.
+func ParseUnit(s string) (Unit, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "mi", "mile", "miles":
+		return Miles, nil
+	case "km", "kms", "kilometre", "kilometres", "kilometer", "kilometers":
+		return Kilometres, nil
+	}
+	return Miles, fmt.Errorf("unknown distance unit %q, use \"mi\" or \"km\"", s)
+}
+
+// FromMiles converts a distance in miles to the unit.
+func (u Unit) FromMiles(miles float64) float64 {
+	if u == Kilometres {
+		return miles * kmPerMile
+	}
+	return miles
+}
+
+// ToMiles converts a distance in the unit to miles.
+func (u Unit) ToMiles(distance float64) float64 {
+	if u == Kilometres {
+		return distance / kmPerMile
+	}
+	return distance
+}
+
+// Format formats a distance in the unit with its abbreviation, to one
+// decimal place for short distances, for example "3.5km" or "25mi".
+func (u Unit) Format(distance float64) string {
+	if distance <= shortDistance {
+		return fmt.Sprintf("%.1f%s", distance, u)
+	}
+	return fmt.Sprintf("%.f%s", distance, u)
+}
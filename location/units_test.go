@@ -0,0 +1,67 @@
+package location
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestParseUnit(t *testing.T) {
+	tests := []struct {
+		input string
+		unit  Unit
+		isErr bool
+	}{
+		{input: "", unit: Miles},
+		{input: "mi", unit: Miles},
+		{input: "Miles", unit: Miles},
+		{input: "km", unit: Kilometres},
+		{input: " kilometers ", unit: Kilometres},
+		{input: "furlongs", isErr: true},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			unit, err := ParseUnit(tt.input)
+			if (err != nil) != tt.isErr {
+				t.Fatalf("unexpected error state %v", err)
+			}
+			if got, want := unit, tt.unit; got != want {
+				t.Errorf("got %s want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestUnitFormat(t *testing.T) {
+	tests := []struct {
+		unit     Unit
+		distance float64
+		expected string
+	}{
+		{unit: Miles, distance: 1.22567, expected: "1.2mi"},
+		{unit: Miles, distance: 10.9999, expected: "11mi"},
+		{unit: Kilometres, distance: 9.96, expected: "10.0km"},
+		{unit: Kilometres, distance: 176.3, expected: "176km"},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			if got, want := tt.unit.Format(tt.distance), tt.expected; got != want {
+				t.Errorf("got %s want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestUnitConversion(t *testing.T) {
+	// the haversine radii and the conversion factor differ slightly
+	mi, km := haversineDistance(coord{53.38, -1.47}, coord{51.58, -0.02})
+	if got, want := Kilometres.FromMiles(mi), km; math.Abs(got-want) > 0.5 {
+		t.Errorf("got %f want %f", got, want)
+	}
+	if got, want := Kilometres.ToMiles(Kilometres.FromMiles(mi)), mi; math.Abs(got-want) > 1e-9 {
+		t.Errorf("got %f want %f", got, want)
+	}
+	if got, want := Miles.FromMiles(mi), mi; got != want {
+		t.Errorf("got %f want %f", got, want)
+	}
+}
@@ -0,0 +1,46 @@
+package cexfind
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrProxy reports that a query failed because the proxy could not be
+// reached or refused the connection, rather than Cex blocking the
+// search.
+var ErrProxy = errors.New("proxy error")
+
+// proxyTransport returns an http transport connecting through the proxy
+// at proxyURL. HTTP proxies are sent a CONNECT request for https urls,
+// with any credentials in proxyURL sent as basic authentication;
+// SOCKS5 proxies are sent any credentials as a username and password.
+func proxyTransport(proxyURL *url.URL) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+	transport.OnProxyConnectResponse = func(ctx context.Context, u *url.URL, r *http.Request, resp *http.Response) error {
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%w: %s refused connection: %s", ErrProxy, u.Redacted(), resp.Status)
+		}
+		return nil
+	}
+	return transport
+}
+
+// proxyError wraps err with ErrProxy if it arose from the proxy: a
+// failure to connect to the proxy or a SOCKS5 handshake failure, both
+// reported by net/http as a net.OpError.
+func proxyError(err error) error {
+	var opErr *net.OpError
+	if errors.Is(err, ErrProxy) || !errors.As(err, &opErr) {
+		return err
+	}
+	if opErr.Op == "proxyconnect" || strings.HasPrefix(opErr.Op, "socks ") {
+		return fmt.Errorf("%w: %w", ErrProxy, err)
+	}
+	return err
+}
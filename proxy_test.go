@@ -0,0 +1,206 @@
+package cexfind
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// connectProxy is an http proxy stand-in which tunnels CONNECT requests
+// to the allowed address for clients authenticating as user:password.
+func connectProxy(t *testing.T, user, password, allowed string) *httptest.Server {
+	t.Helper()
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != auth {
+			w.Header().Set("Proxy-Authenticate", `Basic realm="proxy"`)
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		if r.Method != http.MethodConnect || r.Host != allowed {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer upstream.Close()
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprint(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go io.Copy(upstream, rw)
+		io.Copy(conn, upstream)
+	}))
+}
+
+// socks5Proxy is a SOCKS5 proxy stand-in which connects clients
+// authenticating as user:password to the allowed address. It returns
+// the proxy address.
+func socks5Proxy(t *testing.T, user, password, allowed string) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSOCKS5(c, user, password, allowed)
+		}
+	}()
+	return l.Addr().String()
+}
+
+// serveSOCKS5 serves a SOCKS5 connect request with username and
+// password authentication (RFC 1928 and RFC 1929).
+func serveSOCKS5(c net.Conn, user, password, allowed string) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	read := func(n int) []byte {
+		b := make([]byte, n)
+		io.ReadFull(r, b)
+		return b
+	}
+
+	// method negotiation; only username and password is supported
+	greeting := read(2)
+	if !slices.Contains(read(int(greeting[1])), 2) {
+		c.Write([]byte{5, 0xff})
+		return
+	}
+	c.Write([]byte{5, 2})
+
+	// authentication
+	auth := read(2)
+	gotUser := string(read(int(auth[1])))
+	gotPassword := string(read(int(read(1)[0])))
+	if gotUser != user || gotPassword != password {
+		c.Write([]byte{1, 1})
+		return
+	}
+	c.Write([]byte{1, 0})
+
+	// connect request
+	request := read(4)
+	var host string
+	switch request[3] {
+	case 1:
+		host = net.IP(read(4)).String()
+	case 3:
+		host = string(read(int(read(1)[0])))
+	default:
+		return
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(read(2)))))
+	reply := func(code byte) {
+		c.Write([]byte{5, code, 0, 1, 0, 0, 0, 0, 0, 0})
+	}
+	if addr != allowed {
+		reply(2) // not allowed by ruleset
+		return
+	}
+	upstream, err := net.Dial("tcp", addr)
+	if err != nil {
+		reply(5) // connection refused
+		return
+	}
+	defer upstream.Close()
+	reply(0)
+	go io.Copy(upstream, r)
+	io.Copy(c, upstream)
+}
+
+// TestProxy tests searching through http and socks5 proxies, and that
+// proxy failures are distinguished from Cex blocking the search
+func TestProxy(t *testing.T) {
+
+	contents, err := os.ReadFile("testdata/example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blockPage, err := os.ReadFile("testdata/error.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocked := false
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if blocked {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write(blockPage)
+			return
+		}
+		w.Write(contents)
+	}))
+	defer upstream.Close()
+	URL = upstream.URL
+	allowed := upstream.Listener.Addr().String()
+
+	httpProxy := connectProxy(t, "user", "secret", allowed)
+	defer httpProxy.Close()
+	closedProxy := connectProxy(t, "user", "secret", allowed)
+	closedProxy.Close()
+	socksProxy := socks5Proxy(t, "user", "secret", allowed)
+
+	tests := []struct {
+		proxy   string
+		blocked bool
+		wantErr error
+	}{
+		{proxy: "http://user:secret@" + httpProxy.Listener.Addr().String()},
+		{proxy: "http://user:wrong@" + httpProxy.Listener.Addr().String(), wantErr: ErrProxy},
+		{proxy: "http://user:secret@" + closedProxy.Listener.Addr().String(), wantErr: ErrProxy},
+		{proxy: "http://user:secret@" + httpProxy.Listener.Addr().String(), blocked: true, wantErr: ErrBlocked},
+		{proxy: "socks5://user:secret@" + socksProxy},
+		{proxy: "socks5://user:wrong@" + socksProxy, wantErr: ErrProxy},
+		{proxy: "socks5://user:secret@" + socksProxy, blocked: true, wantErr: ErrBlocked},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			proxyURL, err := url.Parse(tt.proxy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cex := NewCexFind(WithProxy(proxyURL))
+			cex.client.Transport.(*http.Transport).TLSClientConfig = upstream.Client().Transport.(*http.Transport).TLSClientConfig
+
+			blocked = tt.blocked
+			results, err := cex.Search([]string{"lenovo x390s"}, false, "")
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := len(results), 6; got != want {
+					t.Errorf("expected %d box results, got %d", want, got)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != ErrProxy && errors.Is(err, ErrProxy) {
+				t.Errorf("got proxy error %v for upstream error", err)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ package cexfind
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -48,6 +49,9 @@ var (
 	debug = false
 	// no results sentinel error
 	ErrNoResultsFound error = errors.New("no results found")
+	// ErrBlocked reports that an html page, such as a CloudFlare
+	// block, was returned instead of search results
+	ErrBlocked error = errors.New("search blocked")
 )
 
 // jsonResults encompasses the interesting fields in a Cex web search result
@@ -74,40 +78,32 @@ type boxResults struct {
 	err   error
 }
 
-// makeQueries makes queries concurrently; strict true requires that the
-// return results contain all terms in at least one query
-func makeQueries(queries []string, strict bool) chan boxResults {
+// queryFunc makes a single query; see queryBoxes.
+type queryFunc func(ctx context.Context, query string, queries []string, strict bool) ([]Box, error)
+
+// makeQueries makes queries concurrently with queryer; strict true
+// requires that the return results contain all terms in at least one
+// query. The queries are abandoned if ctx is cancelled.
+func makeQueries(ctx context.Context, queries []string, strict bool, queryer queryFunc) chan boxResults {
 	results := make(chan boxResults)
 	var wg sync.WaitGroup
 	for _, query := range queries {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			br := boxResults{query: query}
-			queryBody := strings.ReplaceAll(jsonBody, "MODEL", url.QueryEscape(query))
-			queryBytes := []byte(queryBody)
-			response, err := postQuery(queryBytes)
-			if err != nil {
-				br.err = err
-				results <- br
-				return
+			found, err := queryer(ctx, query, queries, strict)
+			for _, box := range found {
+				select {
+				case results <- boxResults{query: query, box: box}:
+				case <-ctx.Done():
+					return
+				}
 			}
-
-			for _, j := range response.Results[0].Hits {
-				br.box = Box{}
-				br.box.Model = extractModelType(j.BoxName)
-				br.box.Name = j.BoxName
-				br.box.Category = j.Category
-				br.box.ID = j.BoxID
-				br.box.Price = j.Price
-				br.box.PriceCash = j.PriceCash
-				br.box.PriceExchange = j.PriceExchange
-				br.box.storeNames = storeSimplifier(j.Stores)
-				// in strict mode, don't add box if it doesn't match any query
-				if strict && !br.box.inQuery(queries) {
-					continue
+			if err != nil {
+				select {
+				case results <- boxResults{query: query, err: err}:
+				case <-ctx.Done():
 				}
-				results <- br
 			}
 		}()
 	}
@@ -118,21 +114,48 @@ func makeQueries(queries []string, strict bool) chan boxResults {
 	return results
 }
 
-// postQuery posts the web query
-func postQuery(queryBytes []byte) (jsonResults, error) {
+// queryBoxes makes a single query, returning the boxes found.
+func queryBoxes(ctx context.Context, client *http.Client, query string) ([]Box, error) {
+	queryBody := strings.ReplaceAll(jsonBody, "MODEL", url.QueryEscape(query))
+	response, err := postQuery(ctx, client, []byte(queryBody))
+	if err != nil {
+		return nil, err
+	}
+
+	found := []Box{}
+	for _, j := range response.Results[0].Hits {
+		box := Box{
+			Model:         extractModelType(j.BoxName),
+			Name:          j.BoxName,
+			Category:      j.Category,
+			ID:            j.BoxID,
+			Price:         j.Price,
+			PriceCash:     j.PriceCash,
+			PriceExchange: j.PriceExchange,
+			storeNames:    j.Stores,
+		}
+		found = append(found, box)
+	}
+	return found, nil
+}
+
+// postQuery posts the web query with client
+func postQuery(ctx context.Context, client *http.Client, queryBytes []byte) (jsonResults, error) {
 	var r jsonResults
-	request, err := http.NewRequest("POST", URL, bytes.NewBuffer(queryBytes))
+	request, err := http.NewRequestWithContext(ctx, "POST", URL, bytes.NewBuffer(queryBytes))
 	if err != nil {
 		return r, err
 	}
 
 	request.Header.Set("Content-Type", "application/json; charset=UTF-8")
-	client := &http.Client{}
 	response, err := client.Do(request)
 	if err != nil {
-		return r, fmt.Errorf("http call error: %w", err)
+		return r, fmt.Errorf("http call error: %w", proxyError(err))
 	}
 	defer response.Body.Close()
+	if response.StatusCode == http.StatusProxyAuthRequired {
+		return r, fmt.Errorf("%w: %s", ErrProxy, response.Status)
+	}
 
 	responseBytes, err := io.ReadAll(response.Body)
 	if err != nil {
@@ -152,8 +175,7 @@ func postQuery(queryBytes []byte) (jsonResults, error) {
 		// html page might have been returned; try and extract heading
 		reason := errorExtract(responseBytes)
 		if reason != "" {
-			reason = "unknown API retrieval or unmarshalling error"
-			return r, errors.New(reason)
+			return r, fmt.Errorf("%w: %s", ErrBlocked, reason)
 		}
 		var ju *json.UnmarshalTypeError
 		if errors.As(err, &ju) {
@@ -167,6 +189,35 @@ func postQuery(queryBytes []byte) (jsonResults, error) {
 	return r, nil
 }
 
+// QueryErrorKind classifies an error from a query, such as one reported
+// to an Observer, as "blocked", "proxy", "decode", "no_results",
+// "cancelled", "http", "breaker_open" or "other", for use in metrics.
+// It returns "" for a nil error.
+func QueryErrorKind(err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var urlErr *url.Error
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrBlocked):
+		return "blocked"
+	case errors.Is(err, ErrProxy):
+		return "proxy"
+	case errors.Is(err, ErrCircuitOpen):
+		return "breaker_open"
+	case errors.Is(err, ErrNoResultsFound):
+		return "no_results"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "cancelled"
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		return "decode"
+	case errors.As(err, &urlErr):
+		return "http"
+	}
+	return "other"
+}
+
 // errorExtract attempts to extract meaningful error messages from html
 // error pages. It looks for an h1 heading from a stream of bytes,
 // typically needed if there is an html error page, alternatively a
@@ -183,26 +234,6 @@ func errorExtract(b []byte) string {
 	return ""
 }
 
-// storeSimplifier replaces some long store names with shorter ones
-func storeSimplifier(ss []string) []string {
-	output := []string{}
-	simpleMap := map[string]string{
-		"Tottenham Crt Rd": "London W1 TCR",
-		"Rathbone Place":   "London W1 Rathbone",
-	}
-LOOP:
-	for _, s := range ss {
-		for k, v := range simpleMap {
-			if strings.Contains(s, k) {
-				output = append(output, v)
-				continue LOOP
-			}
-		}
-		output = append(output, s)
-	}
-	return output
-}
-
 // extractModelType tries to extract a meaningful model type from a
 // boxname. Since models are not well normalised further cleaning work
 // is likely to be needed in future. The titling type is set to English.
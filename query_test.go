@@ -1,8 +1,11 @@
 package cexfind
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"slices"
 	"testing"
@@ -113,21 +116,61 @@ func TestHeadingExtract(t *testing.T) {
 	}
 }
 
-// TestStoreSimplifier tests shortening of some store names
-func TestStoreSimplifier(t *testing.T) {
+// TestQueryErrorKind tests classifying query errors from the Cex
+// endpoint for metrics
+func TestQueryErrorKind(t *testing.T) {
+
+	blocked, err := os.ReadFile("testdata/error.html")
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	for i, tt := range []struct {
-		provided []string
-		want     []string
+		body string
+		kind string
 	}{
-		{[]string{"Havant", "a"}, []string{"Havant", "a"}},
-		{[]string{"b", "London - W1 Tottenham Crt Rd"}, []string{"b", "London W1 TCR"}},
-		{[]string{"c", "London - W1 Rathbone Place"}, []string{"c", "London W1 Rathbone"}},
+		{body: `{"results":[{"hits":[{"boxId":"1","boxName":"x"}]}]}`, kind: ""},
+		{body: string(blocked), kind: "blocked"},
+		{body: `{"results":[]}`, kind: "no_results"},
+		{body: `{"results":`, kind: "decode"},
+		{body: `{"results":"x"}`, kind: "decode"},
 	} {
-		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
-			if got, want := storeSimplifier(tt.provided), tt.want; slices.Compare(got, want) != 0 {
-				t.Errorf("got %v want %v", got, want)
+		t.Run(fmt.Sprintf("subtest %d", i), func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, tt.body)
+			}))
+			defer ts.Close()
+			URL = ts.URL
+
+			_, err := postQuery(context.Background(), http.DefaultClient, []byte("{}"))
+			if got, want := QueryErrorKind(err), tt.kind; got != want {
+				t.Errorf("got %q want %q for error %v", got, want, err)
 			}
 		})
 	}
+
+	// http and cancellation errors
+	URL = "http://127.0.0.1:1"
+	_, err = postQuery(context.Background(), http.DefaultClient, []byte("{}"))
+	if got, want := QueryErrorKind(err), "http"; got != want {
+		t.Errorf("got %q want %q for error %v", got, want, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = postQuery(ctx, http.DefaultClient, []byte("{}"))
+	if got, want := QueryErrorKind(err), "cancelled"; got != want {
+		t.Errorf("got %q want %q for error %v", got, want, err)
+	}
+
+	// a plain http proxy requiring authentication
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusProxyAuthRequired)
+		fmt.Fprint(w, "<html><h1>Proxy Authentication Required</h1></html>")
+	}))
+	defer ts.Close()
+	URL = ts.URL
+	_, err = postQuery(context.Background(), http.DefaultClient, []byte("{}"))
+	if got, want := QueryErrorKind(err), "proxy"; got != want {
+		t.Errorf("got %q want %q for error %v", got, want, err)
+	}
 }
@@ -0,0 +1,149 @@
+package cexfind
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StaleResultsSize is the most queries whose results are kept by
+// WithStaleResults.
+var StaleResultsSize = 500
+
+// StaleError reports that a query failed and that its results are the
+// most recent cached results of the same query instead, which are Age
+// old. Err is the error of the failed query.
+type StaleError struct {
+	Query string
+	Age   time.Duration
+	Err   error
+}
+
+func (e *StaleError) Error() string {
+	s := fmt.Sprintf("results %s old: %v", e.Age.Round(time.Second), e.Err)
+	if e.Query != "" {
+		s = fmt.Sprintf("\"%s\": %s", e.Query, s)
+	}
+	return s
+}
+
+func (e *StaleError) Unwrap() error {
+	return e.Err
+}
+
+// joinStale joins the stale errors of several queries into one, whose
+// age is that of the oldest results.
+func joinStale(errs []*StaleError) *StaleError {
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	joined := &StaleError{}
+	wrapped := make([]error, len(errs))
+	for i, e := range errs {
+		joined.Age = max(joined.Age, e.Age)
+		wrapped[i] = fmt.Errorf("\"%s\": %w", e.Query, e.Err)
+	}
+	joined.Err = errors.Join(wrapped...)
+	return joined
+}
+
+// staleable reports whether the results of a query failing with err
+// may be replaced by cached results. Queries finding nothing, or
+// which were cancelled, are not failures of the upstream.
+func staleable(err error) bool {
+	return err != nil &&
+		!errors.Is(err, ErrNoResultsFound) &&
+		!errors.Is(err, context.Canceled) &&
+		!errors.Is(err, context.DeadlineExceeded)
+}
+
+// staleResults keeps the most recent results of each query, without
+// strict filtering, for up to maxAge, to be used if the query later
+// fails.
+type staleResults struct {
+	mu      sync.Mutex
+	size    int
+	maxAge  time.Duration
+	entries map[string]staleEntry
+	now     func() time.Time
+}
+
+type staleEntry struct {
+	boxes  []Box
+	stored time.Time
+}
+
+func newStaleResults(size int, maxAge time.Duration) *staleResults {
+	return &staleResults{
+		size:    size,
+		maxAge:  maxAge,
+		entries: map[string]staleEntry{},
+		now:     time.Now,
+	}
+}
+
+// staleKey normalises a query, so that queries differing only in case
+// or spacing share results.
+func staleKey(query string) string {
+	return strings.Join(strings.Fields(strings.ToLower(query)), " ")
+}
+
+// put stores the results of query, evicting expired entries and then
+// the oldest if full.
+func (sr *staleResults) put(query string, boxes []Box) {
+	if sr == nil || sr.size < 1 {
+		return
+	}
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	now := sr.now()
+	for k, e := range sr.entries {
+		if now.Sub(e.stored) > sr.maxAge {
+			delete(sr.entries, k)
+		}
+	}
+	key := staleKey(query)
+	if _, ok := sr.entries[key]; !ok && len(sr.entries) >= sr.size {
+		oldest := ""
+		for k, e := range sr.entries {
+			if oldest == "" || e.stored.Before(sr.entries[oldest].stored) {
+				oldest = k
+			}
+		}
+		delete(sr.entries, oldest)
+	}
+	sr.entries[key] = staleEntry{boxes: boxes, stored: now}
+}
+
+// get returns the results of query and their age, if they are no older
+// than maxAge.
+func (sr *staleResults) get(query string) ([]Box, time.Duration, bool) {
+	if sr == nil {
+		return nil, 0, false
+	}
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	e, ok := sr.entries[staleKey(query)]
+	if !ok {
+		return nil, 0, false
+	}
+	age := sr.now().Sub(e.stored)
+	if age > sr.maxAge {
+		return nil, 0, false
+	}
+	return e.boxes, age, true
+}
+
+// strictBoxes returns the boxes which match any of queries.
+func strictBoxes(found []Box, queries []string) []Box {
+	matched := []Box{}
+	for _, box := range found {
+		if box.inQuery(queries) {
+			matched = append(matched, box)
+		}
+	}
+	return matched
+}
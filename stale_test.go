@@ -0,0 +1,163 @@
+package cexfind
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestStaleResults tests the stale results cache
+func TestStaleResults(t *testing.T) {
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sr := newStaleResults(2, time.Hour)
+	sr.now = func() time.Time { return now }
+
+	sr.put("Lenovo  X390", []Box{{ID: "a"}})
+	now = now.Add(10 * time.Minute)
+	sr.put("t480", []Box{{ID: "b"}})
+
+	tests := []struct {
+		query   string
+		advance time.Duration
+		wantID  string
+		wantAge time.Duration
+	}{
+		{"lenovo x390", 0, "a", 10 * time.Minute},
+		{" LENOVO x390 ", 0, "a", 10 * time.Minute},
+		{"t480", 30 * time.Minute, "b", 30 * time.Minute},
+		{"lenovo x390", 25 * time.Minute, "", 0}, // expired
+		{"x1", 0, "", 0},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			now = now.Add(tt.advance)
+			boxes, age, ok := sr.get(tt.query)
+			if got, want := ok, tt.wantID != ""; got != want {
+				t.Fatalf("got ok %t want %t", got, want)
+			}
+			if !ok {
+				return
+			}
+			if got, want := boxes[0].ID, tt.wantID; got != want {
+				t.Errorf("got id %s want %s", got, want)
+			}
+			if got, want := age, tt.wantAge; got != want {
+				t.Errorf("got age %s want %s", got, want)
+			}
+		})
+	}
+
+	// the oldest is evicted when full
+	sr.put("x1", []Box{{ID: "c"}})
+	sr.put("x2", []Box{{ID: "d"}})
+	if _, _, ok := sr.get("t480"); ok {
+		t.Error("expected t480 to be evicted")
+	}
+
+	var nilResults *staleResults
+	nilResults.put("x1", nil)
+	if _, _, ok := nilResults.get("x1"); ok {
+		t.Error("expected no results without a cache")
+	}
+}
+
+// TestSearchStaleResults tests that searches return the most recent
+// results of queries which fail
+func TestSearchStaleResults(t *testing.T) {
+
+	contents, err := os.ReadFile("testdata/example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blockPage, err := os.ReadFile("testdata/error.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocked := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if blocked {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write(blockPage)
+			return
+		}
+		fmt.Fprintln(w, string(contents))
+	}))
+	defer ts.Close()
+	URL = ts.URL
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cex := NewCexFind(WithStaleResults(6 * time.Hour))
+	cex.stale.now = func() time.Time { return now }
+
+	live, err := cex.Search([]string{"lenovo x390s"}, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocked = true
+	now = now.Add(3 * time.Hour)
+
+	// the cached results of the normalised query are returned
+	results, err := cex.Search([]string{"Lenovo X390s "}, false, "")
+	var staleErr *StaleError
+	if !errors.As(err, &staleErr) {
+		t.Fatalf("expected a stale error, got %v", err)
+	}
+	if got, want := staleErr.Age, 3*time.Hour; got != want {
+		t.Errorf("got age %s want %s", got, want)
+	}
+	if !errors.Is(err, ErrBlocked) {
+		t.Errorf("expected the stale error to wrap %v, got %v", ErrBlocked, err)
+	}
+	if got, want := len(results), len(live); got != want {
+		t.Errorf("got %d stale results want %d", got, want)
+	}
+
+	// strict matching applies to the cached results
+	results, err = cex.Search([]string{"lenovo x390s"}, true, "")
+	if !errors.As(err, &staleErr) {
+		t.Fatalf("expected a stale error, got %v", err)
+	}
+	for _, b := range results {
+		if !b.inQuery([]string{"lenovo x390s"}) {
+			t.Errorf("unexpected strict result %s", b.Name)
+		}
+	}
+
+	// a query without cached results is reported with the stale one
+	results, err = cex.Search([]string{"lenovo x390s", "thinkpad"}, false, "")
+	if got, want := len(results), len(live); got != want {
+		t.Errorf("got %d results want %d", got, want)
+	}
+	if err == nil || !strings.Contains(err.Error(), `"thinkpad": search blocked`) {
+		t.Errorf("expected thinkpad error, got %v", err)
+	}
+	if _, ok := err.(*StaleError); ok {
+		t.Error("expected a failed query to not be reported only as stale")
+	}
+
+	// the streamed results are also from the cache
+	stream, err := cex.SearchStream(context.Background(), []string{"lenovo x390s"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for qr := range stream {
+		if !errors.As(qr.Err, &staleErr) || len(qr.Boxes) != len(live) {
+			t.Errorf("got %d boxes and error %v, want stale results", len(qr.Boxes), qr.Err)
+		}
+	}
+
+	// results older than the maximum age are not used
+	now = now.Add(4 * time.Hour)
+	_, err = cex.Search([]string{"lenovo x390s"}, false, "")
+	if errors.As(err, &staleErr) || !errors.Is(err, ErrBlocked) {
+		t.Errorf("expected blocked error, got %v", err)
+	}
+}